@@ -0,0 +1,205 @@
+// Package batch provides a kcl.RecordProcessor that buffers records across
+// ProcessRecords calls and hands them to a flush handler in batches sized for
+// the downstream sink rather than by maxRecords and fetch timing.
+//
+// A record is only checkpointed after the batch containing it has been
+// flushed successfully, so a record is never checkpointed before it has been
+// written. To flush batches that reach their maximum age while the shard is
+// idle, set callProcessRecordsEvenForEmptyRecordList = true in the properties
+// file so that ProcessRecords is called even when there are no new records.
+package batch
+
+import (
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+)
+
+var defaultLogger = log.New(ioutil.Discard, "", log.LstdFlags)
+
+// FlushReason describes why a batch was flushed.
+type FlushReason string
+
+const (
+	FlushReasonRecords           FlushReason = "records"
+	FlushReasonBytes             FlushReason = "bytes"
+	FlushReasonAge               FlushReason = "age"
+	FlushReasonShardEnded        FlushReason = "shardEnded"
+	FlushReasonShutdownRequested FlushReason = "shutdownRequested"
+)
+
+type FlushInput struct {
+	ShardID string
+	Records []kcl.Record
+	Reason  FlushReason
+}
+
+// FlushHandler writes a batch to the downstream sink. If it returns an error
+// nothing is checkpointed and the same records, plus any that arrive in the
+// meantime, are flushed again on the next ProcessRecords call.
+type FlushHandler func(*FlushInput) error
+
+// Option signifies the type of options that can be passed to the Processor.
+type Option func(*Processor)
+
+// WithMaxRecords flushes once the batch holds n records. Defaults to 500.
+func WithMaxRecords(n int) Option {
+	return func(p *Processor) {
+		p.maxRecords = n
+	}
+}
+
+// WithMaxBytes flushes once the record data in the batch adds up to n bytes.
+// Defaults to 5MB.
+func WithMaxBytes(n int) Option {
+	return func(p *Processor) {
+		p.maxBytes = n
+	}
+}
+
+// WithMaxAge flushes once the oldest record in the batch was buffered d ago.
+// Defaults to 5s.
+func WithMaxAge(d time.Duration) Option {
+	return func(p *Processor) {
+		p.maxAge = d
+	}
+}
+
+// WithLogger adds a logger option.
+func WithLogger(l *log.Logger) Option {
+	return func(p *Processor) {
+		p.logger = l
+	}
+}
+
+// Processor is a kcl.RecordProcessor that buffers records for a single shard.
+type Processor struct {
+	flush      FlushHandler
+	maxRecords int
+	maxBytes   int
+	maxAge     time.Duration
+	logger     *log.Logger
+	now        func() time.Time
+
+	shardID       string
+	buffer        []kcl.Record
+	bufferedBytes int
+	oldest        time.Time
+}
+
+// GetProcessor returns a Processor that calls flush with each batch. Use a
+// new Processor for every shard.
+func GetProcessor(flush FlushHandler, opts ...Option) *Processor {
+	p := &Processor{
+		flush:      flush,
+		maxRecords: 500,
+		maxBytes:   5 * 1024 * 1024,
+		maxAge:     5 * time.Second,
+		logger:     defaultLogger,
+		now:        time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Processor) Initialize(input *kcl.InitializationInput) {
+	p.shardID = input.ShardID
+	p.reset()
+}
+
+func (p *Processor) ProcessRecords(input *kcl.ProcessRecordsInput) {
+	// After a failed flush the rest of the call only buffers, the flush is
+	// retried on the next call.
+	flushFailed := false
+	for _, record := range input.Records {
+		if len(p.buffer) == 0 {
+			p.oldest = p.now()
+		}
+		p.buffer = append(p.buffer, record)
+		p.bufferedBytes += len(record.Data)
+
+		if flushFailed {
+			continue
+		}
+
+		switch {
+		case p.maxRecords > 0 && len(p.buffer) >= p.maxRecords:
+			flushFailed = !p.flushAndCheckpoint(FlushReasonRecords, input.Checkpoint)
+		case p.maxBytes > 0 && p.bufferedBytes >= p.maxBytes:
+			flushFailed = !p.flushAndCheckpoint(FlushReasonBytes, input.Checkpoint)
+		}
+	}
+
+	if !flushFailed && len(p.buffer) > 0 && p.now().Sub(p.oldest) >= p.maxAge {
+		p.flushAndCheckpoint(FlushReasonAge, input.Checkpoint)
+	}
+}
+
+// LeaseLost drops the buffer. Another worker now owns the shard and will
+// receive these records again since they were never checkpointed.
+func (p *Processor) LeaseLost(input *kcl.LeaseLostInput) {
+	if len(p.buffer) > 0 {
+		p.logger.Printf("Lease lost for shard %s, dropping %d buffered records", p.shardID, len(p.buffer))
+	}
+	p.reset()
+}
+
+// ShardEnded flushes the buffer and checkpoints the end of the shard.
+func (p *Processor) ShardEnded(input *kcl.ShardEndedInput) {
+	if len(p.buffer) > 0 && !p.flushBuffer(FlushReasonShardEnded) {
+		return
+	}
+
+	if err := input.Checkpoint(nil); err != nil {
+		p.logger.Printf("Failed to checkpoint end of shard %s: %+v", p.shardID, err)
+	}
+}
+
+// ShutdownRequested flushes the buffer and checkpoints its last record.
+func (p *Processor) ShutdownRequested(input *kcl.ShutdownRequestedInput) {
+	if len(p.buffer) > 0 {
+		p.flushAndCheckpoint(FlushReasonShutdownRequested, input.Checkpoint)
+	}
+}
+
+// flushAndCheckpoint flushes the buffer and checkpoints the last record in
+// it. It returns false if the flush failed.
+func (p *Processor) flushAndCheckpoint(reason FlushReason, checkpoint kcl.CheckpointFunc) bool {
+	sequenceNumber := p.buffer[len(p.buffer)-1].SequenceNumber
+	if !p.flushBuffer(reason) {
+		return false
+	}
+
+	if err := checkpoint(&sequenceNumber); err != nil {
+		p.logger.Printf("Failed to checkpoint shard %s at %s: %+v", p.shardID, sequenceNumber, err)
+	}
+	return true
+}
+
+// flushBuffer calls the flush handler and empties the buffer if it succeeds.
+func (p *Processor) flushBuffer(reason FlushReason) bool {
+	err := p.flush(&FlushInput{
+		ShardID: p.shardID,
+		Records: p.buffer,
+		Reason:  reason,
+	})
+	if err != nil {
+		p.logger.Printf("Failed to flush %d records for shard %s: %+v", len(p.buffer), p.shardID, err)
+		return false
+	}
+
+	p.reset()
+	return true
+}
+
+func (p *Processor) reset() {
+	p.buffer = nil
+	p.bufferedBytes = 0
+	p.oldest = time.Time{}
+}
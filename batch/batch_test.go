@@ -0,0 +1,155 @@
+package batch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+)
+
+type recorder struct {
+	flushes     []*FlushInput
+	checkpoints []*string
+	flushErr    error
+}
+
+func (r *recorder) flush(input *FlushInput) error {
+	if r.flushErr != nil {
+		return r.flushErr
+	}
+	r.flushes = append(r.flushes, input)
+	return nil
+}
+
+func (r *recorder) checkpoint(sequenceNumber *string) error {
+	r.checkpoints = append(r.checkpoints, sequenceNumber)
+	return nil
+}
+
+func records(sequenceNumbers ...string) []kcl.Record {
+	records := []kcl.Record{}
+	for _, s := range sequenceNumbers {
+		records = append(records, kcl.Record{Data: []byte("data-" + s), SequenceNumber: s})
+	}
+	return records
+}
+
+func TestProcessRecords_FlushesOnMaxRecordsAcrossCalls(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.flush, WithMaxRecords(3), WithMaxAge(time.Hour))
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0"})
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("1", "2"), Checkpoint: r.checkpoint})
+	if len(r.flushes) != 0 || len(r.checkpoints) != 0 {
+		t.Fatal("expected nothing to be flushed or checkpointed before the batch is full")
+	}
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("3", "4"), Checkpoint: r.checkpoint})
+	if len(r.flushes) != 1 {
+		t.Fatalf("expected 1 flush but got %d", len(r.flushes))
+	}
+	if len(r.flushes[0].Records) != 3 || r.flushes[0].Reason != FlushReasonRecords || r.flushes[0].ShardID != "shard-0" {
+		t.Errorf("unexpected flush %+v", r.flushes[0])
+	}
+	if len(r.checkpoints) != 1 || *r.checkpoints[0] != "3" {
+		t.Errorf("expected a checkpoint at the last flushed record '3' but got %v", r.checkpoints)
+	}
+}
+
+func TestProcessRecords_FlushesOnMaxBytes(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.flush, WithMaxBytes(12), WithMaxAge(time.Hour))
+
+	// Each record has 6 bytes of data.
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("1", "2", "3"), Checkpoint: r.checkpoint})
+	if len(r.flushes) != 1 || r.flushes[0].Reason != FlushReasonBytes || len(r.flushes[0].Records) != 2 {
+		t.Fatalf("expected a single flush of 2 records for bytes but got %+v", r.flushes)
+	}
+	if *r.checkpoints[0] != "2" {
+		t.Errorf("expected a checkpoint at '2' but got '%s'", *r.checkpoints[0])
+	}
+}
+
+func TestProcessRecords_FlushesOnAgeWhenIdle(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.flush, WithMaxAge(time.Minute))
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("1"), Checkpoint: r.checkpoint})
+
+	// An empty call, sent when callProcessRecordsEvenForEmptyRecordList is
+	// set, flushes the batch once it is old enough.
+	now = now.Add(time.Minute)
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Checkpoint: r.checkpoint})
+	if len(r.flushes) != 1 || r.flushes[0].Reason != FlushReasonAge {
+		t.Fatalf("expected a flush for age but got %+v", r.flushes)
+	}
+	if *r.checkpoints[0] != "1" {
+		t.Errorf("expected a checkpoint at '1' but got '%s'", *r.checkpoints[0])
+	}
+}
+
+func TestProcessRecords_DoesNotCheckpointWhenFlushFails(t *testing.T) {
+	r := &recorder{flushErr: errors.New("some error")}
+	p := GetProcessor(r.flush, WithMaxRecords(1))
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("1"), Checkpoint: r.checkpoint})
+	if len(r.checkpoints) != 0 {
+		t.Fatal("expected no checkpoint when the flush fails")
+	}
+
+	// The failed records are retried with the next batch.
+	r.flushErr = nil
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("2"), Checkpoint: r.checkpoint})
+	if len(r.flushes) != 1 || len(r.flushes[0].Records) != 2 {
+		t.Fatalf("expected both records to be flushed together but got %+v", r.flushes)
+	}
+	if *r.checkpoints[0] != "2" {
+		t.Errorf("expected a checkpoint at '2' but got '%s'", *r.checkpoints[0])
+	}
+}
+
+func TestShardEnded_FlushesAndCheckpointsEndOfShard(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.flush)
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("1"), Checkpoint: r.checkpoint})
+	p.ShardEnded(&kcl.ShardEndedInput{Checkpoint: r.checkpoint})
+
+	if len(r.flushes) != 1 || r.flushes[0].Reason != FlushReasonShardEnded {
+		t.Fatalf("expected a flush for shard end but got %+v", r.flushes)
+	}
+	if len(r.checkpoints) != 1 || r.checkpoints[0] != nil {
+		t.Errorf("expected a single nil checkpoint but got %v", r.checkpoints)
+	}
+}
+
+func TestShutdownRequested_FlushesAndCheckpoints(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.flush)
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("1", "2"), Checkpoint: r.checkpoint})
+	p.ShutdownRequested(&kcl.ShutdownRequestedInput{Checkpoint: r.checkpoint})
+
+	if len(r.flushes) != 1 || r.flushes[0].Reason != FlushReasonShutdownRequested {
+		t.Fatalf("expected a flush for shutdown but got %+v", r.flushes)
+	}
+	if *r.checkpoints[0] != "2" {
+		t.Errorf("expected a checkpoint at '2' but got '%s'", *r.checkpoints[0])
+	}
+}
+
+func TestLeaseLost_DropsBuffer(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.flush)
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("1"), Checkpoint: r.checkpoint})
+	p.LeaseLost(&kcl.LeaseLostInput{})
+	p.ShutdownRequested(&kcl.ShutdownRequestedInput{Checkpoint: r.checkpoint})
+
+	if len(r.flushes) != 0 || len(r.checkpoints) != 0 {
+		t.Errorf("expected nothing to be flushed after the lease was lost but got %+v", r.flushes)
+	}
+}
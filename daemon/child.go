@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The message formats below mirror the ones sent by the Java MultiLangDaemon,
+// see https://github.com/awslabs/amazon-kinesis-client/blob/master/amazon-kinesis-client-multilang/src/main/java/software/amazon/kinesis/multilang/package-info.java
+
+type initializeMessage struct {
+	Action            string `json:"action"`
+	ShardID           string `json:"shardId"`
+	SequenceNumber    string `json:"sequenceNumber"`
+	SubSequenceNumber int64  `json:"subSequenceNumber"`
+}
+
+type record struct {
+	Action                      string `json:"action"`
+	Data                        []byte `json:"data"`
+	PartitionKey                string `json:"partitionKey"`
+	SequenceNumber              string `json:"sequenceNumber"`
+	SubSequenceNumber           int64  `json:"subSequenceNumber"`
+	ApproximateArrivalTimestamp int64  `json:"approximateArrivalTimestamp"`
+}
+
+type processRecordsMessage struct {
+	Action             string   `json:"action"`
+	Records            []record `json:"records"`
+	MillisBehindLatest int64    `json:"millisBehindLatest"`
+}
+
+type actionMessage struct {
+	Action string `json:"action"`
+}
+
+type checkpointResponse struct {
+	Action            string  `json:"action"`
+	SequenceNumber    *string `json:"sequenceNumber"`
+	SubSequenceNumber *int64  `json:"subSequenceNumber"`
+	Error             string  `json:"error,omitempty"`
+}
+
+// childMessage is any message written by the record processor.
+type childMessage struct {
+	Action         string  `json:"action"`
+	ResponseFor    string  `json:"responseFor"`
+	SequenceNumber *string `json:"sequenceNumber"`
+}
+
+// childProcess is a record processor executable speaking the multi-language
+// protocol over its STDIN and STDOUT.
+type childProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	logger *log.Logger
+
+	// messages receives every message the child writes. It is closed when
+	// the child's STDOUT is closed.
+	messages chan *childMessage
+	closing  chan struct{}
+	exited   chan struct{}
+	exitErr  error
+}
+
+func startChild(config *Config, shardID string, logger *log.Logger) (*childProcess, error) {
+	name, args := config.command()
+	cmd := exec.Command(name, args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get child stdin")
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get child stdout")
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get child stderr")
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start record processor for shard %s", shardID)
+	}
+
+	c := &childProcess{
+		cmd:      cmd,
+		stdin:    stdin,
+		logger:   logger,
+		messages: make(chan *childMessage),
+		closing:  make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+
+	// Wait must not be called until both pipes have been read to the end.
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		c.readMessages(stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Printf("Record processor for shard %s: %s", shardID, scanner.Text())
+		}
+	}()
+	go func() {
+		readers.Wait()
+		c.exitErr = cmd.Wait()
+		close(c.exited)
+	}()
+
+	return c, nil
+}
+
+// readMessages parses each line the child writes. Blank lines are expected
+// since the kcl package surrounds every message with newlines; any other
+// output that is not a protocol message is logged and skipped.
+func (c *childProcess) readMessages(stdout io.Reader) {
+	defer close(c.messages)
+
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			var msg childMessage
+			if jsonErr := json.Unmarshal(line, &msg); jsonErr != nil || msg.Action == "" {
+				c.logger.Printf("Ignoring unexpected output from record processor: %s", line)
+			} else {
+				select {
+				case c.messages <- &msg:
+				case <-c.closing:
+					io.Copy(io.Discard, reader)
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *childProcess) send(msg interface{}) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal message")
+	}
+
+	if _, err = c.stdin.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write message to record processor")
+	}
+
+	return nil
+}
+
+// close closes the child's STDIN, which tells a kcl process to exit, and kills
+// it if it has not exited within timeout.
+func (c *childProcess) close(timeout time.Duration) {
+	close(c.closing)
+	c.stdin.Close()
+
+	select {
+	case <-c.exited:
+	case <-time.After(timeout):
+		c.logger.Printf("Record processor %d did not exit within %s, killing it", c.cmd.Process.Pid, timeout)
+		c.cmd.Process.Kill()
+		<-c.exited
+	}
+}
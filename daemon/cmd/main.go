@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/goguardian/goguardian-go-kcl/daemon"
+)
+
+const (
+	// command line flag names
+	propertiesKey = "properties"
+)
+
+func main() {
+	var pathToPropertiesFile string
+	flag.StringVar(&pathToPropertiesFile, propertiesKey, "", "The path to the properties file")
+
+	flag.Parse()
+
+	if pathToPropertiesFile == "" {
+		pathToPropertiesFile = os.Getenv(strings.ToUpper(propertiesKey))
+	}
+
+	if pathToPropertiesFile == "" {
+		fmt.Printf("Must provide %s\n", propertiesKey)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	d, err := daemon.GetDaemonFromPropertiesFile(pathToPropertiesFile)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err = d.Run(ctx); err != nil {
+		fmt.Printf("%+v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,225 @@
+package daemon
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/pkg/errors"
+)
+
+// Initial positions understood by the daemon. They are also the checkpoint
+// sentinel values stored in the lease table by the Java KCL.
+const (
+	TrimHorizon = "TRIM_HORIZON"
+	Latest      = "LATEST"
+	AtTimestamp = "AT_TIMESTAMP"
+	ShardEnd    = "SHARD_END"
+)
+
+// Config holds the subset of the MultiLangDaemon properties that the Go daemon
+// supports. Property names match the ones documented in sample.properties.
+type Config struct {
+	ExecutableName  string
+	StreamName      string
+	ApplicationName string
+	RegionName      string
+	WorkerID        string
+
+	InitialPositionInStream         string
+	InitialPositionInStreamExtended time.Time
+
+	KinesisEndpoint  string
+	DynamoDBEndpoint string
+
+	FailoverTime                             time.Duration
+	ShardSyncInterval                        time.Duration
+	IdleTimeBetweenReads                     time.Duration
+	ParentShardPollInterval                  time.Duration
+	TaskBackoffTime                          time.Duration
+	ShutdownGrace                            time.Duration
+	MaxRecords                               int64
+	MaxLeasesForWorker                       int
+	MaxLeasesToStealAtOneTime                int
+	CallProcessRecordsEvenForEmptyRecordList bool
+	CleanupLeasesUponShardCompletion         bool
+}
+
+// defaultConfig returns a Config populated with the same defaults as the Java
+// KCL.
+func defaultConfig() *Config {
+	return &Config{
+		RegionName:                       "us-east-1",
+		InitialPositionInStream:          Latest,
+		FailoverTime:                     10 * time.Second,
+		ShardSyncInterval:                60 * time.Second,
+		IdleTimeBetweenReads:             1 * time.Second,
+		ParentShardPollInterval:          10 * time.Second,
+		TaskBackoffTime:                  500 * time.Millisecond,
+		ShutdownGrace:                    5 * time.Second,
+		MaxRecords:                       10000,
+		MaxLeasesToStealAtOneTime:        1,
+		CleanupLeasesUponShardCompletion: true,
+	}
+}
+
+// ConfigFromProperties builds a Config from a parsed properties file. Keys the
+// Go daemon does not use are ignored so that the same file can be used with
+// the Java MultiLangDaemon.
+func ConfigFromProperties(p *properties.Properties) (*Config, error) {
+	c := defaultConfig()
+
+	c.ExecutableName = p.GetDefault("executableName", "")
+	c.StreamName = p.GetDefault("streamName", "")
+	c.ApplicationName = p.GetDefault("applicationName", "")
+	c.RegionName = p.GetDefault("regionName", c.RegionName)
+	c.WorkerID = p.GetDefault("workerId", "")
+	c.InitialPositionInStream = p.GetDefault("initialPositionInStream", c.InitialPositionInStream)
+	c.KinesisEndpoint = p.GetDefault("kinesisEndpoint", "")
+	c.DynamoDBEndpoint = p.GetDefault("dynamoDBEndpoint", "")
+
+	var err error
+	if v, ok := p.Get("initialPositionInStreamExtended"); ok && v != "" {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid initialPositionInStreamExtended")
+		}
+		c.InitialPositionInStream = AtTimestamp
+		c.InitialPositionInStreamExtended = time.Unix(seconds, 0)
+	}
+
+	millis := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"failoverTimeMillis", &c.FailoverTime},
+		{"shardSyncIntervalMillis", &c.ShardSyncInterval},
+		{"idleTimeBetweenReadsInMillis", &c.IdleTimeBetweenReads},
+		{"parentShardPollIntervalMillis", &c.ParentShardPollInterval},
+		{"taskBackoffTimeMillis", &c.TaskBackoffTime},
+		{"shutdownGraceMillis", &c.ShutdownGrace},
+	}
+	for _, m := range millis {
+		if err = parseMillis(p, m.key, m.dst); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := p.Get("maxRecords"); ok && v != "" {
+		if c.MaxRecords, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, errors.Wrap(err, "invalid maxRecords")
+		}
+	}
+
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"maxLeasesForWorker", &c.MaxLeasesForWorker},
+		{"maxLeasesToStealAtOneTime", &c.MaxLeasesToStealAtOneTime},
+	}
+	for _, i := range ints {
+		if v, ok := p.Get(i.key); ok && v != "" {
+			if *i.dst, err = strconv.Atoi(v); err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", i.key)
+			}
+		}
+	}
+
+	bools := []struct {
+		key string
+		dst *bool
+	}{
+		{"callProcessRecordsEvenForEmptyRecordList", &c.CallProcessRecordsEvenForEmptyRecordList},
+		{"cleanupLeasesUponShardCompletion", &c.CleanupLeasesUponShardCompletion},
+	}
+	for _, b := range bools {
+		if v, ok := p.Get(b.key); ok && v != "" {
+			if *b.dst, err = strconv.ParseBool(v); err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", b.key)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+func parseMillis(p *properties.Properties, key string, dst *time.Duration) error {
+	v, ok := p.Get(key)
+	if !ok || v == "" {
+		return nil
+	}
+
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", key)
+	}
+
+	*dst = time.Duration(ms) * time.Millisecond
+	return nil
+}
+
+// validate checks that the required settings are present and that the
+// intervals are positive, and fills in the worker ID if one was not provided.
+func (c *Config) validate() error {
+	if strings.TrimSpace(c.ExecutableName) == "" {
+		return errors.New("missing executableName")
+	}
+
+	if c.StreamName == "" {
+		return errors.New("missing streamName")
+	}
+
+	if c.ApplicationName == "" {
+		return errors.New("missing applicationName")
+	}
+
+	switch c.InitialPositionInStream {
+	case TrimHorizon, Latest:
+	case AtTimestamp:
+		if c.InitialPositionInStreamExtended.IsZero() {
+			return errors.New("AT_TIMESTAMP requires initialPositionInStreamExtended")
+		}
+	default:
+		return errors.Errorf("invalid initialPositionInStream '%s'", c.InitialPositionInStream)
+	}
+
+	// The intervals drive tickers and timers, which need a positive
+	// duration. Properties are in milliseconds, so shorter durations can only
+	// come from a Config built in Go.
+	intervals := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"failoverTimeMillis", c.FailoverTime},
+		{"shardSyncIntervalMillis", c.ShardSyncInterval},
+		{"idleTimeBetweenReadsInMillis", c.IdleTimeBetweenReads},
+		{"parentShardPollIntervalMillis", c.ParentShardPollInterval},
+		{"taskBackoffTimeMillis", c.TaskBackoffTime},
+		{"shutdownGraceMillis", c.ShutdownGrace},
+	}
+	for _, i := range intervals {
+		if i.value < time.Millisecond {
+			return errors.Errorf("%s must be positive", i.key)
+		}
+	}
+
+	if c.WorkerID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errors.Wrap(err, "failed to get hostname for worker id")
+		}
+		c.WorkerID = hostname + ":" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return nil
+}
+
+// command splits the executableName into the program and its arguments the
+// same way the MultiLangDaemon does, on runs of spaces.
+func (c *Config) command() (string, []string) {
+	fields := strings.Fields(c.ExecutableName)
+	return fields[0], fields[1:]
+}
@@ -0,0 +1,379 @@
+package daemon
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/pkg/errors"
+)
+
+type stopReason int
+
+const (
+	reasonNone stopReason = iota
+	reasonLeaseLost
+	reasonShutdown
+)
+
+// shardConsumer reads records for a single held lease and drives the record
+// processor executable for that shard.
+type shardConsumer struct {
+	daemon *Daemon
+	lease  *Lease
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	mu     sync.Mutex
+	reason stopReason
+
+	child         *childProcess
+	checkpoint    string
+	lastDelivered string
+}
+
+func newShardConsumer(d *Daemon, lease *Lease) *shardConsumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &shardConsumer{
+		daemon:     d,
+		lease:      lease,
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		checkpoint: lease.Checkpoint,
+	}
+}
+
+func (s *shardConsumer) start() {
+	go func() {
+		defer close(s.done)
+		s.err = s.run()
+	}()
+}
+
+// stop asks the consumer to finish. The first reason given wins.
+func (s *shardConsumer) stop(reason stopReason) {
+	s.mu.Lock()
+	if s.reason == reasonNone {
+		s.reason = reason
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+}
+
+func (s *shardConsumer) stopReason() stopReason {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reason
+}
+
+func (s *shardConsumer) run() error {
+	shardID := s.lease.Key
+	if s.checkpoint == ShardEnd {
+		return nil
+	}
+
+	if err := s.waitForParents(); err != nil {
+		return err
+	}
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	child, err := startChild(s.daemon.config, shardID, s.daemon.logger)
+	if err != nil {
+		return err
+	}
+	s.child = child
+	defer child.close(s.daemon.config.ShutdownGrace)
+
+	err = s.dispatch(&initializeMessage{
+		Action:            "initialize",
+		ShardID:           shardID,
+		SequenceNumber:    s.checkpoint,
+		SubSequenceNumber: s.lease.CheckpointSubSequenceNumber,
+	}, "initialize")
+	if err != nil {
+		return err
+	}
+
+	if err = s.consume(); err != nil {
+		return err
+	}
+
+	switch s.stopReason() {
+	case reasonLeaseLost:
+		return s.dispatch(&actionMessage{Action: "leaseLost"}, "leaseLost")
+	case reasonShutdown:
+		return s.dispatch(&actionMessage{Action: "shutdownRequested"}, "shutdownRequested")
+	}
+
+	return nil
+}
+
+// waitForParents blocks until every parent shard has been processed to the
+// end, so that records for a key are always delivered in order across a
+// reshard. A parent without a lease has already been cleaned up.
+func (s *shardConsumer) waitForParents() error {
+	for _, parent := range s.lease.ParentShardIDs {
+		for {
+			lease, err := s.daemon.store.GetLease(s.ctx, parent)
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if lease == nil || lease.Checkpoint == ShardEnd {
+				break
+			}
+
+			if !s.sleep(s.daemon.config.ParentShardPollInterval) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// consume fetches records until the shard ends or the consumer is stopped.
+func (s *shardConsumer) consume() error {
+	config := s.daemon.config
+
+	iterator, ok := s.getIterator(s.checkpoint)
+	if !ok {
+		return nil
+	}
+
+	for s.ctx.Err() == nil {
+		output, err := s.daemon.kinesis.GetRecordsWithContext(s.ctx, &kinesis.GetRecordsInput{
+			ShardIterator: iterator,
+			Limit:         aws.Int64(config.MaxRecords),
+		})
+		if s.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if !isAWSErrorCode(err, kinesis.ErrCodeExpiredIteratorException) {
+				s.daemon.logger.Printf("Failed to get records for shard %s: %+v", s.lease.Key, err)
+				s.sleep(config.TaskBackoffTime)
+			}
+
+			resumeFrom := s.checkpoint
+			if s.lastDelivered != "" {
+				resumeFrom = s.lastDelivered
+			}
+			if iterator, ok = s.getIterator(resumeFrom); !ok {
+				return nil
+			}
+			continue
+		}
+
+		if len(output.Records) > 0 || config.CallProcessRecordsEvenForEmptyRecordList {
+			if err = s.processRecords(output); err != nil {
+				return err
+			}
+		}
+
+		if output.NextShardIterator == nil {
+			return s.endShard()
+		}
+		iterator = output.NextShardIterator
+
+		s.sleep(config.IdleTimeBetweenReads)
+	}
+
+	return nil
+}
+
+// getIterator returns an iterator positioned after the given checkpoint,
+// retrying until it succeeds or the consumer is stopped.
+func (s *shardConsumer) getIterator(position string) (*string, bool) {
+	input := &kinesis.GetShardIteratorInput{
+		StreamName: aws.String(s.daemon.config.StreamName),
+		ShardId:    aws.String(s.lease.Key),
+	}
+
+	switch position {
+	case TrimHorizon, Latest:
+		input.ShardIteratorType = aws.String(position)
+	case AtTimestamp:
+		input.ShardIteratorType = aws.String(kinesis.ShardIteratorTypeAtTimestamp)
+		input.Timestamp = aws.Time(s.daemon.config.InitialPositionInStreamExtended)
+	default:
+		input.ShardIteratorType = aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber)
+		input.StartingSequenceNumber = aws.String(position)
+	}
+
+	for {
+		output, err := s.daemon.kinesis.GetShardIteratorWithContext(s.ctx, input)
+		if s.ctx.Err() != nil {
+			return nil, false
+		}
+		if err == nil {
+			return output.ShardIterator, true
+		}
+
+		s.daemon.logger.Printf("Failed to get shard iterator for shard %s: %+v", s.lease.Key, err)
+		if !s.sleep(s.daemon.config.TaskBackoffTime) {
+			return nil, false
+		}
+	}
+}
+
+func (s *shardConsumer) processRecords(output *kinesis.GetRecordsOutput) error {
+	records := make([]record, 0, len(output.Records))
+	for _, r := range output.Records {
+		rec := record{
+			Action:         "record",
+			Data:           r.Data,
+			PartitionKey:   aws.StringValue(r.PartitionKey),
+			SequenceNumber: aws.StringValue(r.SequenceNumber),
+		}
+		if r.ApproximateArrivalTimestamp != nil {
+			rec.ApproximateArrivalTimestamp = r.ApproximateArrivalTimestamp.UnixNano() / int64(time.Millisecond)
+		}
+		records = append(records, rec)
+	}
+
+	if len(records) > 0 {
+		s.lastDelivered = records[len(records)-1].SequenceNumber
+	}
+
+	return s.dispatch(&processRecordsMessage{
+		Action:             "processRecords",
+		Records:            records,
+		MillisBehindLatest: aws.Int64Value(output.MillisBehindLatest),
+	}, "processRecords")
+}
+
+func (s *shardConsumer) endShard() error {
+	if err := s.dispatch(&actionMessage{Action: "shardEnded"}, "shardEnded"); err != nil {
+		return err
+	}
+
+	if s.checkpoint != ShardEnd {
+		return errors.Errorf("record processor for shard %s did not checkpoint at the end of the shard", s.lease.Key)
+	}
+
+	s.daemon.logger.Printf("Finished processing shard %s", s.lease.Key)
+	return nil
+}
+
+// dispatch sends a message to the record processor and handles its
+// checkpoints until it reports the status for that message. Once the
+// consumer is stopped the record processor has shutdownGraceMillis to
+// respond.
+func (s *shardConsumer) dispatch(msg interface{}, action string) error {
+	if err := s.child.send(msg); err != nil {
+		return err
+	}
+
+	ctxDone := s.ctx.Done()
+	var deadline <-chan time.Time
+	for {
+		select {
+		case m, ok := <-s.child.messages:
+			if !ok {
+				return errors.Errorf("record processor for shard %s exited while handling %s", s.lease.Key, action)
+			}
+
+			switch m.Action {
+			case "checkpoint":
+				if err := s.handleCheckpoint(m, action); err != nil {
+					return err
+				}
+			case "status":
+				if m.ResponseFor != action {
+					return errors.Errorf("expected status for %s but got status for %s", action, m.ResponseFor)
+				}
+				return nil
+			default:
+				return errors.Errorf("unexpected message '%s' from record processor", m.Action)
+			}
+
+		case <-ctxDone:
+			ctxDone = nil
+			deadline = time.After(s.daemon.config.ShutdownGrace)
+
+		case <-deadline:
+			return errors.Errorf("record processor for shard %s did not finish %s within %s", s.lease.Key, action, s.daemon.config.ShutdownGrace)
+		}
+	}
+}
+
+// handleCheckpoint stores the checkpoint requested by the record processor
+// and writes the response it is waiting for.
+func (s *shardConsumer) handleCheckpoint(m *childMessage, action string) error {
+	response := &checkpointResponse{
+		Action:         "checkpoint",
+		SequenceNumber: m.SequenceNumber,
+	}
+
+	checkpoint, err := s.resolveCheckpoint(m.SequenceNumber, action)
+	if err == nil && checkpoint != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), s.daemon.config.FailoverTime)
+		err = s.daemon.coordinator.checkpoint(ctx, s.lease.Key, checkpoint)
+		cancel()
+	}
+
+	if err != nil {
+		s.daemon.logger.Printf("Failed to checkpoint shard %s: %+v", s.lease.Key, err)
+		response.Error = err.Error()
+	} else if checkpoint != "" {
+		s.checkpoint = checkpoint
+	}
+
+	return s.child.send(response)
+}
+
+// resolveCheckpoint validates the sequence number sent by the record
+// processor. A nil sequence number means the last record delivered, or the
+// end of the shard when responding to shardEnded. An empty result means there
+// is nothing to checkpoint.
+func (s *shardConsumer) resolveCheckpoint(sequenceNumber *string, action string) (string, error) {
+	if sequenceNumber == nil {
+		if action == "shardEnded" {
+			return ShardEnd, nil
+		}
+		return s.lastDelivered, nil
+	}
+
+	requested, ok := new(big.Int).SetString(*sequenceNumber, 10)
+	if !ok {
+		return "", errors.Errorf("invalid sequence number '%s'", *sequenceNumber)
+	}
+
+	if s.lastDelivered == "" {
+		return "", errors.Errorf("sequence number '%s' has not been delivered", *sequenceNumber)
+	}
+	if last, _ := new(big.Int).SetString(s.lastDelivered, 10); last != nil && requested.Cmp(last) > 0 {
+		return "", errors.Errorf("sequence number '%s' is after the last record delivered", *sequenceNumber)
+	}
+	if current, ok := new(big.Int).SetString(s.checkpoint, 10); ok && requested.Cmp(current) < 0 {
+		return "", errors.Errorf("sequence number '%s' is before the current checkpoint", *sequenceNumber)
+	}
+
+	return *sequenceNumber, nil
+}
+
+// sleep waits for d and returns false if the consumer was stopped first.
+func (s *shardConsumer) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,325 @@
+package daemon
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errLeaseLost is returned when an operation fails because this worker no
+// longer holds the lease.
+var errLeaseLost = errors.New("lease lost")
+
+// heldLease guards a lease this worker owns. Renewals and checkpoints both
+// bump the lease counter so they must not interleave.
+type heldLease struct {
+	mu    sync.Mutex
+	lease *Lease
+}
+
+// leaseCoordinator takes, renews and steals leases following the same
+// algorithm as the Java KCL lease taker: every worker aims to hold an equal
+// share of the leases, expired leases are taken first and, when none are
+// available, leases are stolen from the most loaded worker.
+type leaseCoordinator struct {
+	store                     LeaseStore
+	workerID                  string
+	failoverTime              time.Duration
+	maxLeasesForWorker        int
+	maxLeasesToStealAtOneTime int
+	logger                    *log.Logger
+	now                       func() time.Time
+
+	mu   sync.Mutex
+	held map[string]*heldLease
+	// seen tracks every lease from the previous scan so that expiry can be
+	// detected from lease counters that stop moving.
+	seen map[string]*Lease
+	// lost holds the keys of leases that were found to be lost outside of
+	// renewLeases, e.g. by a failed checkpoint, until renewLeases reports
+	// them.
+	lost []string
+}
+
+func newLeaseCoordinator(store LeaseStore, config *Config, logger *log.Logger) *leaseCoordinator {
+	return &leaseCoordinator{
+		store:                     store,
+		workerID:                  config.WorkerID,
+		failoverTime:              config.FailoverTime,
+		maxLeasesForWorker:        config.MaxLeasesForWorker,
+		maxLeasesToStealAtOneTime: config.MaxLeasesToStealAtOneTime,
+		logger:                    logger,
+		now:                       time.Now,
+
+		held: map[string]*heldLease{},
+		seen: map[string]*Lease{},
+	}
+}
+
+// heldLeases returns copies of the leases this worker currently holds.
+func (c *leaseCoordinator) heldLeases() []*Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	leases := make([]*Lease, 0, len(c.held))
+	for _, h := range c.held {
+		h.mu.Lock()
+		leases = append(leases, h.lease.copy())
+		h.mu.Unlock()
+	}
+
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Key < leases[j].Key })
+	return leases
+}
+
+func (c *leaseCoordinator) getHeld(key string) (*heldLease, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.held[key]
+	return h, ok
+}
+
+func (c *leaseCoordinator) dropHeld(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.held, key)
+}
+
+// loseHeld drops a held lease that another worker took, so that the next
+// renewLeases reports it as lost.
+func (c *leaseCoordinator) loseHeld(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.held[key]; !ok {
+		return
+	}
+	delete(c.held, key)
+	c.lost = append(c.lost, key)
+}
+
+// renewLeases renews every held lease and returns the keys of the leases that
+// were lost, either because another worker took them, which may also have
+// been found by a checkpoint since the last renewal, or because they could
+// not be renewed within the failover time.
+func (c *leaseCoordinator) renewLeases(ctx context.Context) []string {
+	c.mu.Lock()
+	held := make([]*heldLease, 0, len(c.held))
+	for _, h := range c.held {
+		held = append(held, h)
+	}
+	lost := append([]string{}, c.lost...)
+	c.lost = nil
+	c.mu.Unlock()
+
+	for _, h := range held {
+		h.mu.Lock()
+		key := h.lease.Key
+		ok, err := c.store.RenewLease(ctx, h.lease)
+		switch {
+		case err != nil && h.lease.isExpired(c.failoverTime, c.now()):
+			c.logger.Printf("Failed to renew lease %s before it expired: %+v", key, err)
+			ok = false
+		case err != nil:
+			c.logger.Printf("Failed to renew lease %s, will retry: %+v", key, err)
+			ok = true
+		case ok:
+			h.lease.lastCounterIncrement = c.now()
+		}
+		h.mu.Unlock()
+
+		if !ok {
+			c.logger.Printf("Lost lease %s", key)
+			c.dropHeld(key)
+			lost = append(lost, key)
+		}
+	}
+
+	return lost
+}
+
+// takeLeases scans the lease table and takes or steals leases until this
+// worker holds its share. It returns the keys of the newly taken leases.
+func (c *leaseCoordinator) takeLeases(ctx context.Context) ([]string, error) {
+	leases, err := c.store.ListLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	c.updateSeen(leases, now)
+
+	candidates := c.chooseLeasesToTake(leases, now)
+
+	taken := []string{}
+	for _, candidate := range candidates {
+		// Work on a copy since the scanned lease is kept for expiry tracking.
+		lease := candidate.copy()
+		previousOwner := lease.Owner
+		ok, err := c.store.TakeLease(ctx, lease, c.workerID)
+		if err != nil {
+			c.logger.Printf("Failed to take lease %s: %+v", lease.Key, err)
+			continue
+		}
+		if !ok {
+			// Another worker got to it first.
+			continue
+		}
+
+		if previousOwner != "" && previousOwner != c.workerID {
+			c.logger.Printf("Stole lease %s from %s", lease.Key, previousOwner)
+		} else {
+			c.logger.Printf("Took lease %s", lease.Key)
+		}
+
+		lease.lastCounterIncrement = c.now()
+		c.mu.Lock()
+		c.held[lease.Key] = &heldLease{lease: lease}
+		c.mu.Unlock()
+		taken = append(taken, lease.Key)
+	}
+
+	return taken, nil
+}
+
+func (c *leaseCoordinator) updateSeen(leases []*Lease, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[string]*Lease, len(leases))
+	for _, lease := range leases {
+		lease.lastCounterIncrement = now
+		if old, ok := c.seen[lease.Key]; ok && old.Counter == lease.Counter {
+			lease.lastCounterIncrement = old.lastCounterIncrement
+		}
+		seen[lease.Key] = lease
+	}
+	c.seen = seen
+}
+
+// chooseLeasesToTake decides which leases to take. It must only be called
+// with the leases from the latest scan.
+func (c *leaseCoordinator) chooseLeasesToTake(leases []*Lease, now time.Time) []*Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	available := []*Lease{}
+	leaseCounts := map[string]int{c.workerID: 0}
+	for _, lease := range leases {
+		_, isHeld := c.held[lease.Key]
+		switch {
+		case isHeld:
+			leaseCounts[c.workerID]++
+		case lease.Owner == c.workerID:
+			// Left over from a previous run with the same worker ID.
+			available = append(available, lease)
+		case lease.isExpired(c.failoverTime, now):
+			available = append(available, lease)
+		default:
+			leaseCounts[lease.Owner]++
+		}
+	}
+
+	target := (len(leases) + len(leaseCounts) - 1) / len(leaseCounts)
+	if c.maxLeasesForWorker > 0 && target > c.maxLeasesForWorker {
+		target = c.maxLeasesForWorker
+	}
+
+	needed := target - leaseCounts[c.workerID]
+	if needed <= 0 {
+		return nil
+	}
+
+	if len(available) > 0 {
+		rand.Shuffle(len(available), func(i, j int) {
+			available[i], available[j] = available[j], available[i]
+		})
+		if len(available) > needed {
+			available = available[:needed]
+		}
+		return available
+	}
+
+	return c.chooseLeasesToSteal(leases, leaseCounts, target, needed)
+}
+
+func (c *leaseCoordinator) chooseLeasesToSteal(leases []*Lease, leaseCounts map[string]int, target, needed int) []*Lease {
+	mostLoaded := ""
+	for owner, count := range leaseCounts {
+		if owner != c.workerID && (mostLoaded == "" || count > leaseCounts[mostLoaded]) {
+			mostLoaded = owner
+		}
+	}
+
+	if mostLoaded == "" || leaseCounts[mostLoaded] <= target {
+		return nil
+	}
+
+	numToSteal := leaseCounts[mostLoaded] - target
+	if numToSteal > needed {
+		numToSteal = needed
+	}
+	if c.maxLeasesToStealAtOneTime > 0 && numToSteal > c.maxLeasesToStealAtOneTime {
+		numToSteal = c.maxLeasesToStealAtOneTime
+	}
+
+	candidates := []*Lease{}
+	for _, lease := range leases {
+		if lease.Owner == mostLoaded {
+			candidates = append(candidates, lease)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	return candidates[:numToSteal]
+}
+
+// checkpoint stores a checkpoint for a held lease.
+func (c *leaseCoordinator) checkpoint(ctx context.Context, key, checkpoint string) error {
+	h, ok := c.getHeld(key)
+	if !ok {
+		return errLeaseLost
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ok, err := c.store.UpdateCheckpoint(ctx, h.lease, checkpoint)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		c.logger.Printf("Lost lease %s", key)
+		c.loseHeld(key)
+		return errLeaseLost
+	}
+
+	h.lease.lastCounterIncrement = c.now()
+	return nil
+}
+
+// evictLease gives up a held lease so that another worker can take it
+// without waiting for it to expire.
+func (c *leaseCoordinator) evictLease(ctx context.Context, key string) {
+	h, ok := c.getHeld(key)
+	if !ok {
+		return
+	}
+	c.dropHeld(key)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := c.store.EvictLease(ctx, h.lease); err != nil {
+		c.logger.Printf("Failed to evict lease %s: %+v", key, err)
+	}
+}
@@ -0,0 +1,236 @@
+// Package daemon is a Go implementation of the Java MultiLangDaemon. It
+// discovers shards, balances leases with other workers through a DynamoDB
+// lease table using the same schema as the Java KCL, and runs the configured
+// record processor executable for each held shard using the multi-language
+// protocol, so programs built with the kcl package run unchanged without a
+// JVM.
+//
+// Aggregated KPL records are passed to the record processor as is.
+package daemon
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/pkg/errors"
+)
+
+type Option func(*Daemon)
+
+func WithLogger(logger *log.Logger) Option {
+	return func(d *Daemon) {
+		d.logger = logger
+	}
+}
+
+// WithKinesisClient overrides the Kinesis client created from the config.
+func WithKinesisClient(client kinesisiface.KinesisAPI) Option {
+	return func(d *Daemon) {
+		d.kinesis = client
+	}
+}
+
+// WithLeaseStore overrides the DynamoDB lease table created from the config.
+func WithLeaseStore(store LeaseStore) Option {
+	return func(d *Daemon) {
+		d.store = store
+	}
+}
+
+type Daemon struct {
+	logger  *log.Logger
+	config  *Config
+	kinesis kinesisiface.KinesisAPI
+	store   LeaseStore
+
+	coordinator *leaseCoordinator
+	consumers   map[string]*shardConsumer
+}
+
+// GetDaemonFromPropertiesFile reads a MultiLangDaemon properties file and
+// returns a Daemon configured from it.
+func GetDaemonFromPropertiesFile(path string, opts ...Option) (*Daemon, error) {
+	p, err := properties.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ConfigFromProperties(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetDaemon(config, opts...)
+}
+
+func GetDaemon(config *Config, opts ...Option) (*Daemon, error) {
+	d := &Daemon{
+		logger:    log.New(os.Stdout, "", log.LstdFlags),
+		config:    config,
+		consumers: map[string]*shardConsumer{},
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if d.kinesis == nil || d.store == nil {
+		sess, err := session.NewSession(&aws.Config{
+			Region: aws.String(config.RegionName),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create aws session")
+		}
+
+		if d.kinesis == nil {
+			kinesisConfig := &aws.Config{}
+			if config.KinesisEndpoint != "" {
+				kinesisConfig.Endpoint = aws.String(config.KinesisEndpoint)
+			}
+			d.kinesis = kinesis.New(sess, kinesisConfig)
+		}
+
+		if d.store == nil {
+			dynamoConfig := &aws.Config{}
+			if config.DynamoDBEndpoint != "" {
+				dynamoConfig.Endpoint = aws.String(config.DynamoDBEndpoint)
+			}
+			d.store = NewDynamoDBLeaseStore(dynamodb.New(sess, dynamoConfig), config.ApplicationName)
+		}
+	}
+
+	d.coordinator = newLeaseCoordinator(d.store, config, d.logger)
+
+	return d, nil
+}
+
+// Run processes the stream until ctx is cancelled. On cancellation every
+// record processor is sent shutdownRequested and given shutdownGraceMillis to
+// checkpoint, then the worker's leases are released so that other workers
+// can pick them up immediately.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.logger.Printf("Starting worker %s for stream %s", d.config.WorkerID, d.config.StreamName)
+
+	if err := d.store.CreateLeaseTableIfNotExists(ctx); err != nil {
+		return err
+	}
+
+	if err := d.syncShards(ctx); err != nil {
+		return err
+	}
+
+	renewTicker := time.NewTicker(d.config.FailoverTime / 3)
+	defer renewTicker.Stop()
+
+	// The Java KCL takes leases every two failover periods.
+	takeTicker := time.NewTicker(d.config.FailoverTime * 2)
+	defer takeTicker.Stop()
+
+	syncTicker := time.NewTicker(d.config.ShardSyncInterval)
+	defer syncTicker.Stop()
+
+	d.takeLeases(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			d.shutdown()
+			return nil
+
+		case <-renewTicker.C:
+			for _, key := range d.coordinator.renewLeases(ctx) {
+				if consumer, ok := d.consumers[key]; ok {
+					consumer.stop(reasonLeaseLost)
+				}
+			}
+			d.reapConsumers()
+
+		case <-takeTicker.C:
+			d.takeLeases(ctx)
+
+		case <-syncTicker.C:
+			if err := d.syncShards(ctx); err != nil {
+				d.logger.Printf("Failed to sync shards: %+v", err)
+			}
+		}
+	}
+}
+
+// takeLeases takes this worker's share of leases and starts a consumer for
+// every held lease that does not have one.
+func (d *Daemon) takeLeases(ctx context.Context) {
+	if _, err := d.coordinator.takeLeases(ctx); err != nil {
+		d.logger.Printf("Failed to take leases: %+v", err)
+	}
+
+	for _, lease := range d.coordinator.heldLeases() {
+		if _, ok := d.consumers[lease.Key]; ok {
+			continue
+		}
+
+		consumer := newShardConsumer(d, lease)
+		d.consumers[lease.Key] = consumer
+		consumer.start()
+	}
+}
+
+// reapConsumers forgets consumers that have finished. A consumer that failed
+// gives up its lease so that it is retried, possibly by another worker.
+// Consumers that finished their shard keep the lease until it is cleaned up.
+func (d *Daemon) reapConsumers() {
+	for key, consumer := range d.consumers {
+		select {
+		case <-consumer.done:
+		default:
+			continue
+		}
+
+		if consumer.stopReason() == reasonLeaseLost {
+			delete(d.consumers, key)
+			continue
+		}
+
+		if consumer.err != nil {
+			d.logger.Printf("Record processor for shard %s failed: %+v", key, consumer.err)
+			ctx, cancel := context.WithTimeout(context.Background(), d.config.FailoverTime)
+			d.coordinator.evictLease(ctx, key)
+			cancel()
+			delete(d.consumers, key)
+		}
+	}
+}
+
+func (d *Daemon) shutdown() {
+	d.logger.Printf("Shutting down worker %s", d.config.WorkerID)
+
+	for _, consumer := range d.consumers {
+		consumer.stop(reasonShutdown)
+	}
+
+	for key, consumer := range d.consumers {
+		<-consumer.done
+		if consumer.err != nil {
+			d.logger.Printf("Record processor for shard %s failed during shutdown: %+v", key, consumer.err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.FailoverTime)
+	defer cancel()
+
+	for _, lease := range d.coordinator.heldLeases() {
+		d.coordinator.evictLease(ctx, lease.Key)
+	}
+
+	d.logger.Printf("Worker %s shut down", d.config.WorkerID)
+}
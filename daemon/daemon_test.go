@@ -0,0 +1,578 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+)
+
+type memoryLeaseStore struct {
+	mu     sync.Mutex
+	leases map[string]*Lease
+}
+
+func newMemoryLeaseStore(leases ...*Lease) *memoryLeaseStore {
+	s := &memoryLeaseStore{leases: map[string]*Lease{}}
+	for _, lease := range leases {
+		s.leases[lease.Key] = lease
+	}
+	return s
+}
+
+func (s *memoryLeaseStore) get(key string) *Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lease, ok := s.leases[key]; ok {
+		return lease.copy()
+	}
+	return nil
+}
+
+func (s *memoryLeaseStore) CreateLeaseTableIfNotExists(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryLeaseStore) ListLeases(ctx context.Context) ([]*Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	leases := []*Lease{}
+	for _, lease := range s.leases {
+		leases = append(leases, lease.copy())
+	}
+	return leases, nil
+}
+
+func (s *memoryLeaseStore) GetLease(ctx context.Context, key string) (*Lease, error) {
+	return s.get(key), nil
+}
+
+func (s *memoryLeaseStore) CreateLeaseIfNotExists(ctx context.Context, lease *Lease) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.leases[lease.Key]; ok {
+		return false, nil
+	}
+	s.leases[lease.Key] = lease.copy()
+	return true, nil
+}
+
+// update applies fn to the stored lease if its counter matches and, when
+// owner is set, it is owned by owner.
+func (s *memoryLeaseStore) update(lease *Lease, checkOwner bool, fn func(stored *Lease)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.leases[lease.Key]
+	if !ok || stored.Counter != lease.Counter || (checkOwner && stored.Owner != lease.Owner) {
+		return false
+	}
+
+	fn(stored)
+	stored.Counter++
+	*lease = *stored.copy()
+	return true
+}
+
+func (s *memoryLeaseStore) RenewLease(ctx context.Context, lease *Lease) (bool, error) {
+	return s.update(lease, true, func(*Lease) {}), nil
+}
+
+func (s *memoryLeaseStore) TakeLease(ctx context.Context, lease *Lease, owner string) (bool, error) {
+	return s.update(lease, false, func(stored *Lease) {
+		if stored.Owner != owner {
+			stored.OwnerSwitchesSinceCheckpoint++
+		}
+		stored.Owner = owner
+	}), nil
+}
+
+func (s *memoryLeaseStore) EvictLease(ctx context.Context, lease *Lease) (bool, error) {
+	return s.update(lease, true, func(stored *Lease) {
+		stored.Owner = ""
+	}), nil
+}
+
+func (s *memoryLeaseStore) UpdateCheckpoint(ctx context.Context, lease *Lease, checkpoint string) (bool, error) {
+	return s.update(lease, true, func(stored *Lease) {
+		stored.Checkpoint = checkpoint
+		stored.OwnerSwitchesSinceCheckpoint = 0
+	}), nil
+}
+
+func (s *memoryLeaseStore) DeleteLease(ctx context.Context, lease *Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.leases, lease.Key)
+	return nil
+}
+
+type fakeShard struct {
+	id      string
+	parents []string
+	closed  bool
+	records []string
+}
+
+type fakeKinesis struct {
+	kinesisiface.KinesisAPI
+	shards []*fakeShard
+}
+
+func (k *fakeKinesis) shard(id string) *fakeShard {
+	for _, shard := range k.shards {
+		if shard.id == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+func (k *fakeKinesis) ListShardsWithContext(ctx aws.Context, input *kinesis.ListShardsInput, opts ...request.Option) (*kinesis.ListShardsOutput, error) {
+	output := &kinesis.ListShardsOutput{}
+	for _, shard := range k.shards {
+		s := &kinesis.Shard{
+			ShardId:             aws.String(shard.id),
+			SequenceNumberRange: &kinesis.SequenceNumberRange{StartingSequenceNumber: aws.String("0")},
+		}
+		if len(shard.parents) > 0 {
+			s.ParentShardId = aws.String(shard.parents[0])
+		}
+		if len(shard.parents) > 1 {
+			s.AdjacentParentShardId = aws.String(shard.parents[1])
+		}
+		if shard.closed {
+			s.SequenceNumberRange.EndingSequenceNumber = aws.String("999")
+		}
+		output.Shards = append(output.Shards, s)
+	}
+	return output, nil
+}
+
+// Iterators are "<shardID>/<index of next record>".
+func (k *fakeKinesis) GetShardIteratorWithContext(ctx aws.Context, input *kinesis.GetShardIteratorInput, opts ...request.Option) (*kinesis.GetShardIteratorOutput, error) {
+	shard := k.shard(*input.ShardId)
+	index := 0
+	switch *input.ShardIteratorType {
+	case kinesis.ShardIteratorTypeLatest:
+		index = len(shard.records)
+	case kinesis.ShardIteratorTypeAfterSequenceNumber:
+		index, _ = strconv.Atoi(*input.StartingSequenceNumber)
+	}
+	return &kinesis.GetShardIteratorOutput{
+		ShardIterator: aws.String(fmt.Sprintf("%s/%d", shard.id, index)),
+	}, nil
+}
+
+// Sequence numbers are the 1 based index of the record in its shard.
+func (k *fakeKinesis) GetRecordsWithContext(ctx aws.Context, input *kinesis.GetRecordsInput, opts ...request.Option) (*kinesis.GetRecordsOutput, error) {
+	parts := strings.Split(*input.ShardIterator, "/")
+	shard := k.shard(parts[0])
+	index, _ := strconv.Atoi(parts[1])
+
+	output := &kinesis.GetRecordsOutput{MillisBehindLatest: aws.Int64(0)}
+	for ; index < len(shard.records); index++ {
+		output.Records = append(output.Records, &kinesis.Record{
+			Data:                        []byte(shard.records[index]),
+			PartitionKey:                aws.String("key"),
+			SequenceNumber:              aws.String(strconv.Itoa(index + 1)),
+			ApproximateArrivalTimestamp: aws.Time(time.Now()),
+		})
+	}
+
+	if !shard.closed {
+		output.NextShardIterator = aws.String(fmt.Sprintf("%s/%d", shard.id, index))
+	}
+	return output, nil
+}
+
+// helperProcessor is the record processor run by TestHelperProcess. It
+// appends every record to a file named after the shard and checkpoints after
+// every batch.
+type helperProcessor struct {
+	dir     string
+	shardID string
+}
+
+func (p *helperProcessor) Initialize(input *kcl.InitializationInput) {
+	p.shardID = input.ShardID
+}
+
+func (p *helperProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) {
+	f, err := os.OpenFile(filepath.Join(p.dir, p.shardID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	for _, record := range input.Records {
+		fmt.Fprintln(f, string(record.Data))
+	}
+
+	if len(input.Records) > 0 {
+		if err := input.Checkpoint(&input.Records[len(input.Records)-1].SequenceNumber); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (p *helperProcessor) LeaseLost(*kcl.LeaseLostInput) {}
+
+func (p *helperProcessor) ShardEnded(input *kcl.ShardEndedInput) {
+	if err := input.Checkpoint(nil); err != nil {
+		panic(err)
+	}
+}
+
+func (p *helperProcessor) ShutdownRequested(*kcl.ShutdownRequestedInput) {}
+
+// TestHelperProcess is not a real test. It is the record processor executable
+// started by the daemon in the tests below.
+func TestHelperProcess(t *testing.T) {
+	dir := os.Getenv("DAEMON_TEST_OUTPUT_DIR")
+	if dir == "" {
+		return
+	}
+
+	if err := kcl.GetKCLProcess(&helperProcessor{dir: dir}).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func testConfig() *Config {
+	config := defaultConfig()
+	config.ExecutableName = os.Args[0] + " -test.run=TestHelperProcess"
+	config.StreamName = "some_stream"
+	config.ApplicationName = "some_app"
+	config.WorkerID = "worker"
+	config.InitialPositionInStream = TrimHorizon
+	config.FailoverTime = 300 * time.Millisecond
+	config.ShardSyncInterval = 100 * time.Millisecond
+	config.IdleTimeBetweenReads = 10 * time.Millisecond
+	config.ParentShardPollInterval = 10 * time.Millisecond
+	return config
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRun_ProcessesShardsInOrderAndReleasesLeases(t *testing.T) {
+	// setup
+	outputDir := t.TempDir()
+	os.Setenv("DAEMON_TEST_OUTPUT_DIR", outputDir)
+	defer os.Unsetenv("DAEMON_TEST_OUTPUT_DIR")
+
+	k := &fakeKinesis{shards: []*fakeShard{
+		{id: "shard-0", closed: true, records: []string{"alice", "bob"}},
+		{id: "shard-1", parents: []string{"shard-0"}, records: []string{"charlie"}},
+	}}
+	store := newMemoryLeaseStore()
+
+	config := testConfig()
+	config.CleanupLeasesUponShardCompletion = false
+	d, err := GetDaemon(config,
+		WithKinesisClient(k),
+		WithLeaseStore(store),
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- d.Run(ctx)
+	}()
+
+	// test
+	waitFor(t, "checkpoints", func() bool {
+		parent, child := store.get("shard-0"), store.get("shard-1")
+		return parent != nil && parent.Checkpoint == ShardEnd && child != nil && child.Checkpoint == "1"
+	})
+	cancel()
+	if err = <-finished; err != nil {
+		t.Errorf("unexpected error: %+v", err)
+	}
+
+	// validate
+	parentOutput, _ := os.ReadFile(filepath.Join(outputDir, "shard-0"))
+	if string(parentOutput) != "alice\nbob\n" {
+		t.Errorf("unexpected records for shard-0: '%s'", parentOutput)
+	}
+
+	childOutput, _ := os.ReadFile(filepath.Join(outputDir, "shard-1"))
+	if string(childOutput) != "charlie\n" {
+		t.Errorf("unexpected records for shard-1: '%s'", childOutput)
+	}
+
+	if owner := store.get("shard-1").Owner; owner != "" {
+		t.Errorf("expected lease to be released on shutdown but it is owned by '%s'", owner)
+	}
+}
+
+func TestSyncShards_CreatesLeases(t *testing.T) {
+	shards := []*fakeShard{
+		{id: "parent", closed: true},
+		{id: "child-a", parents: []string{"parent"}},
+		{id: "child-b", parents: []string{"parent"}},
+	}
+
+	tests := []struct {
+		name            string
+		initialPosition string
+		existing        []*Lease
+		expected        map[string]string
+	}{
+		{
+			name:            "trim horizon",
+			initialPosition: TrimHorizon,
+			expected:        map[string]string{"parent": TrimHorizon, "child-a": TrimHorizon, "child-b": TrimHorizon},
+		},
+		{
+			name:            "latest skips closed shards",
+			initialPosition: Latest,
+			expected:        map[string]string{"child-a": Latest, "child-b": Latest},
+		},
+		{
+			name:            "children of leased shards start at trim horizon",
+			initialPosition: Latest,
+			existing:        []*Lease{{Key: "parent", Checkpoint: "5"}},
+			expected:        map[string]string{"parent": "5", "child-a": TrimHorizon, "child-b": TrimHorizon},
+		},
+		{
+			name:            "processed shards are not recreated",
+			initialPosition: TrimHorizon,
+			existing:        []*Lease{{Key: "child-a", Checkpoint: "5"}},
+			expected:        map[string]string{"child-a": "5", "child-b": TrimHorizon},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newMemoryLeaseStore(test.existing...)
+			config := testConfig()
+			config.InitialPositionInStream = test.initialPosition
+
+			d, err := GetDaemon(config,
+				WithKinesisClient(&fakeKinesis{shards: shards}),
+				WithLeaseStore(store),
+				WithLogger(log.New(ioutil.Discard, "", 0)),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = d.syncShards(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			leases, _ := store.ListLeases(context.Background())
+			actual := map[string]string{}
+			for _, lease := range leases {
+				actual[lease.Key] = lease.Checkpoint
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(test.expected) {
+				t.Errorf("expected leases %v but got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSyncShards_CleansUpFinishedLeases(t *testing.T) {
+	store := newMemoryLeaseStore(
+		&Lease{Key: "parent", Checkpoint: ShardEnd},
+		&Lease{Key: "child", Checkpoint: "3", ParentShardIDs: []string{"parent"}},
+	)
+
+	d, err := GetDaemon(testConfig(),
+		WithKinesisClient(&fakeKinesis{shards: []*fakeShard{
+			{id: "parent", closed: true},
+			{id: "child", parents: []string{"parent"}},
+		}}),
+		WithLeaseStore(store),
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.syncShards(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.get("parent") != nil {
+		t.Error("expected the finished parent lease to be deleted")
+	}
+	if store.get("child") == nil {
+		t.Error("expected the child lease to be kept")
+	}
+}
+
+func leaseKeys(leases []*Lease) []string {
+	keys := []string{}
+	for _, lease := range leases {
+		keys = append(keys, lease.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestTakeLeases_TakesExpiredLeases(t *testing.T) {
+	store := newMemoryLeaseStore(
+		&Lease{Key: "a", Owner: "other"},
+		&Lease{Key: "b", Owner: "other"},
+		&Lease{Key: "c"},
+	)
+	config := testConfig()
+	c := newLeaseCoordinator(store, config, log.New(ioutil.Discard, "", 0))
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	// The first scan only sees the unowned lease as available. With two
+	// workers each should hold two of the three leases.
+	taken, err := c.takeLeases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(taken) != "[c]" {
+		t.Errorf("expected to take the unowned lease but took %v", taken)
+	}
+
+	// Once the other worker stops renewing, its leases expire.
+	now = now.Add(config.FailoverTime + time.Millisecond)
+	if _, err = c.takeLeases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := leaseKeys(c.heldLeases()); fmt.Sprint(keys) != "[a b c]" {
+		t.Errorf("expected to hold every lease but held %v", keys)
+	}
+}
+
+func TestTakeLeases_StealsFromMostLoadedWorker(t *testing.T) {
+	store := newMemoryLeaseStore(
+		&Lease{Key: "a", Owner: "busy"},
+		&Lease{Key: "b", Owner: "busy"},
+		&Lease{Key: "c", Owner: "busy"},
+		&Lease{Key: "d", Owner: "busy"},
+	)
+	c := newLeaseCoordinator(store, testConfig(), log.New(ioutil.Discard, "", 0))
+
+	taken, err := c.takeLeases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// maxLeasesToStealAtOneTime defaults to 1.
+	if len(taken) != 1 {
+		t.Fatalf("expected to steal one lease but took %v", taken)
+	}
+	if owner := store.get(taken[0]).Owner; owner != "worker" {
+		t.Errorf("expected stolen lease to be owned by worker but it is owned by '%s'", owner)
+	}
+}
+
+func TestRenewLeases_ReportsLostLeases(t *testing.T) {
+	store := newMemoryLeaseStore(&Lease{Key: "a"})
+	c := newLeaseCoordinator(store, testConfig(), log.New(ioutil.Discard, "", 0))
+
+	if _, err := c.takeLeases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another worker steals the lease.
+	stolen := store.get("a")
+	if ok, _ := store.TakeLease(context.Background(), stolen, "other"); !ok {
+		t.Fatal("failed to steal lease")
+	}
+
+	lost := c.renewLeases(context.Background())
+	if fmt.Sprint(lost) != "[a]" {
+		t.Errorf("expected lease 'a' to be lost but got %v", lost)
+	}
+	if len(c.heldLeases()) != 0 {
+		t.Error("expected no held leases")
+	}
+}
+
+func TestRenewLeases_ReportsLeasesLostByCheckpoint(t *testing.T) {
+	store := newMemoryLeaseStore(&Lease{Key: "a"}, &Lease{Key: "b"})
+	c := newLeaseCoordinator(store, testConfig(), log.New(ioutil.Discard, "", 0))
+
+	if _, err := c.takeLeases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if lost := c.renewLeases(context.Background()); len(lost) != 0 {
+		t.Fatalf("expected no lost leases but got %v", lost)
+	}
+
+	// Another worker bumps the lease counter between the renewal and the
+	// checkpoint.
+	stolen := store.get("a")
+	if ok, _ := store.TakeLease(context.Background(), stolen, "other"); !ok {
+		t.Fatal("failed to steal lease")
+	}
+
+	if err := c.checkpoint(context.Background(), "a", "123"); err != errLeaseLost {
+		t.Fatalf("expected the checkpoint to fail with %v but got %v", errLeaseLost, err)
+	}
+	if checkpoint := store.get("a").Checkpoint; checkpoint != "" {
+		t.Errorf("expected no checkpoint to be stored but got '%s'", checkpoint)
+	}
+
+	lost := c.renewLeases(context.Background())
+	if fmt.Sprint(lost) != "[a]" {
+		t.Errorf("expected lease 'a' to be lost but got %v", lost)
+	}
+	if keys := leaseKeys(c.heldLeases()); fmt.Sprint(keys) != "[b]" {
+		t.Errorf("expected to hold only lease 'b' but held %v", keys)
+	}
+	if lost = c.renewLeases(context.Background()); len(lost) != 0 {
+		t.Errorf("expected the lost lease to be reported once but got %v", lost)
+	}
+}
+
+func TestConfigValidate_RejectsInvalidSettings(t *testing.T) {
+	tests := map[string]func(c *Config){
+		"missing executableName":                   func(c *Config) { c.ExecutableName = "  \t" },
+		"shardSyncIntervalMillis must be positive": func(c *Config) { c.ShardSyncInterval = 0 },
+		"failoverTimeMillis must be positive":      func(c *Config) { c.FailoverTime = time.Nanosecond },
+		"taskBackoffTimeMillis must be positive":   func(c *Config) { c.TaskBackoffTime = -time.Second },
+	}
+
+	for expected, change := range tests {
+		config := testConfig()
+		change(config)
+		if err := config.validate(); err == nil || err.Error() != expected {
+			t.Errorf("expected the error '%s' but got %+v", expected, err)
+		}
+	}
+}
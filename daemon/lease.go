@@ -0,0 +1,393 @@
+package daemon
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// Attribute names of the Java KCL lease table. A lease table written by the Go
+// daemon can be read by the Java MultiLangDaemon and vice versa.
+const (
+	attrLeaseKey                     = "leaseKey"
+	attrLeaseOwner                   = "leaseOwner"
+	attrLeaseCounter                 = "leaseCounter"
+	attrCheckpoint                   = "checkpoint"
+	attrCheckpointSubSequenceNumber  = "checkpointSubSequenceNumber"
+	attrOwnerSwitchesSinceCheckpoint = "ownerSwitchesSinceCheckpoint"
+	attrParentShardID                = "parentShardId"
+	attrChildShardIDs                = "childShardIds"
+	attrStartingHashKey              = "startingHashKey"
+	attrEndingHashKey                = "endingHashKey"
+)
+
+// Lease is a row of the lease table. The lease key is the shard ID.
+type Lease struct {
+	Key                          string
+	Owner                        string
+	Counter                      int64
+	Checkpoint                   string
+	CheckpointSubSequenceNumber  int64
+	OwnerSwitchesSinceCheckpoint int64
+	ParentShardIDs               []string
+	ChildShardIDs                []string
+	StartingHashKey              string
+	EndingHashKey                string
+
+	// lastCounterIncrement is when this worker last saw Counter change. It is
+	// used to detect expired leases and is never persisted.
+	lastCounterIncrement time.Time
+}
+
+func (l *Lease) copy() *Lease {
+	c := *l
+	c.ParentShardIDs = append([]string(nil), l.ParentShardIDs...)
+	c.ChildShardIDs = append([]string(nil), l.ChildShardIDs...)
+	return &c
+}
+
+// isExpired reports whether the lease is unowned or its counter has not
+// changed within the failover time.
+func (l *Lease) isExpired(failoverTime time.Duration, now time.Time) bool {
+	return l.Owner == "" || now.Sub(l.lastCounterIncrement) > failoverTime
+}
+
+// LeaseStore persists leases. All of the update methods are conditional on the
+// lease counter so that two workers can never both believe they hold a lease.
+// They return false, and no error, when the condition fails. On success the
+// passed lease is updated to reflect the stored row.
+type LeaseStore interface {
+	CreateLeaseTableIfNotExists(ctx context.Context) error
+	ListLeases(ctx context.Context) ([]*Lease, error)
+	// GetLease returns nil, and no error, if the lease does not exist.
+	GetLease(ctx context.Context, key string) (*Lease, error)
+	CreateLeaseIfNotExists(ctx context.Context, lease *Lease) (bool, error)
+	RenewLease(ctx context.Context, lease *Lease) (bool, error)
+	TakeLease(ctx context.Context, lease *Lease, owner string) (bool, error)
+	EvictLease(ctx context.Context, lease *Lease) (bool, error)
+	UpdateCheckpoint(ctx context.Context, lease *Lease, checkpoint string) (bool, error)
+	DeleteLease(ctx context.Context, lease *Lease) error
+}
+
+type dynamoLeaseStore struct {
+	client    dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBLeaseStore returns a LeaseStore backed by a DynamoDB table with
+// the same schema as the Java KCL lease table.
+func NewDynamoDBLeaseStore(client dynamodbiface.DynamoDBAPI, tableName string) LeaseStore {
+	return &dynamoLeaseStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+func (s *dynamoLeaseStore) CreateLeaseTableIfNotExists(ctx context.Context) error {
+	_, err := s.client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.tableName),
+	})
+	if err == nil {
+		return s.waitForTable(ctx)
+	}
+	if !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return errors.Wrap(err, "failed to describe lease table")
+	}
+
+	_, err = s.client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(s.tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{{
+			AttributeName: aws.String(attrLeaseKey),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		}},
+		KeySchema: []*dynamodb.KeySchemaElement{{
+			AttributeName: aws.String(attrLeaseKey),
+			KeyType:       aws.String(dynamodb.KeyTypeHash),
+		}},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+	if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceInUseException) {
+		return errors.Wrap(err, "failed to create lease table")
+	}
+
+	return s.waitForTable(ctx)
+}
+
+func (s *dynamoLeaseStore) waitForTable(ctx context.Context) error {
+	err := s.client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.tableName),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed waiting for lease table to become active")
+	}
+	return nil
+}
+
+func (s *dynamoLeaseStore) ListLeases(ctx context.Context) ([]*Lease, error) {
+	leases := []*Lease{}
+	err := s.client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:      aws.String(s.tableName),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range page.Items {
+			leases = append(leases, leaseFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan lease table")
+	}
+
+	return leases, nil
+}
+
+func (s *dynamoLeaseStore) GetLease(ctx context.Context, key string) (*Lease, error) {
+	output, err := s.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            leaseKey(&Lease{Key: key}),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get lease")
+	}
+
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+
+	return leaseFromItem(output.Item), nil
+}
+
+func (s *dynamoLeaseStore) CreateLeaseIfNotExists(ctx context.Context, lease *Lease) (bool, error) {
+	_, err := s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                leaseToItem(lease),
+		ConditionExpression: aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key": aws.String(attrLeaseKey),
+		},
+	})
+	return s.conditionalResult(err, "failed to create lease")
+}
+
+func (s *dynamoLeaseStore) RenewLease(ctx context.Context, lease *Lease) (bool, error) {
+	_, err := s.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 leaseKey(lease),
+		UpdateExpression:    aws.String("SET #counter = #counter + :one"),
+		ConditionExpression: aws.String("#counter = :counter AND #owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#counter": aws.String(attrLeaseCounter),
+			"#owner":   aws.String(attrLeaseOwner),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":     numberValue(1),
+			":counter": numberValue(lease.Counter),
+			":owner":   {S: aws.String(lease.Owner)},
+		},
+	})
+
+	ok, err := s.conditionalResult(err, "failed to renew lease")
+	if ok {
+		lease.Counter++
+	}
+	return ok, err
+}
+
+func (s *dynamoLeaseStore) TakeLease(ctx context.Context, lease *Lease, owner string) (bool, error) {
+	switches := lease.OwnerSwitchesSinceCheckpoint
+	if lease.Owner != owner {
+		switches++
+	}
+
+	_, err := s.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 leaseKey(lease),
+		UpdateExpression:    aws.String("SET #owner = :newOwner, #counter = #counter + :one, #switches = :switches"),
+		ConditionExpression: aws.String("#counter = :counter"),
+		ExpressionAttributeNames: map[string]*string{
+			"#counter":  aws.String(attrLeaseCounter),
+			"#owner":    aws.String(attrLeaseOwner),
+			"#switches": aws.String(attrOwnerSwitchesSinceCheckpoint),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":      numberValue(1),
+			":counter":  numberValue(lease.Counter),
+			":newOwner": {S: aws.String(owner)},
+			":switches": numberValue(switches),
+		},
+	})
+
+	ok, err := s.conditionalResult(err, "failed to take lease")
+	if ok {
+		lease.Owner = owner
+		lease.Counter++
+		lease.OwnerSwitchesSinceCheckpoint = switches
+	}
+	return ok, err
+}
+
+func (s *dynamoLeaseStore) EvictLease(ctx context.Context, lease *Lease) (bool, error) {
+	_, err := s.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 leaseKey(lease),
+		UpdateExpression:    aws.String("REMOVE #owner SET #counter = #counter + :one"),
+		ConditionExpression: aws.String("#counter = :counter AND #owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#counter": aws.String(attrLeaseCounter),
+			"#owner":   aws.String(attrLeaseOwner),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":     numberValue(1),
+			":counter": numberValue(lease.Counter),
+			":owner":   {S: aws.String(lease.Owner)},
+		},
+	})
+
+	ok, err := s.conditionalResult(err, "failed to evict lease")
+	if ok {
+		lease.Owner = ""
+		lease.Counter++
+	}
+	return ok, err
+}
+
+func (s *dynamoLeaseStore) UpdateCheckpoint(ctx context.Context, lease *Lease, checkpoint string) (bool, error) {
+	_, err := s.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 leaseKey(lease),
+		UpdateExpression:    aws.String("SET #checkpoint = :checkpoint, #subSeq = :zero, #switches = :zero, #counter = #counter + :one"),
+		ConditionExpression: aws.String("#counter = :counter AND #owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#checkpoint": aws.String(attrCheckpoint),
+			"#subSeq":     aws.String(attrCheckpointSubSequenceNumber),
+			"#switches":   aws.String(attrOwnerSwitchesSinceCheckpoint),
+			"#counter":    aws.String(attrLeaseCounter),
+			"#owner":      aws.String(attrLeaseOwner),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":checkpoint": {S: aws.String(checkpoint)},
+			":zero":       numberValue(0),
+			":one":        numberValue(1),
+			":counter":    numberValue(lease.Counter),
+			":owner":      {S: aws.String(lease.Owner)},
+		},
+	})
+
+	ok, err := s.conditionalResult(err, "failed to update checkpoint")
+	if ok {
+		lease.Checkpoint = checkpoint
+		lease.CheckpointSubSequenceNumber = 0
+		lease.OwnerSwitchesSinceCheckpoint = 0
+		lease.Counter++
+	}
+	return ok, err
+}
+
+func (s *dynamoLeaseStore) DeleteLease(ctx context.Context, lease *Lease) error {
+	_, err := s.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       leaseKey(lease),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete lease")
+	}
+	return nil
+}
+
+// conditionalResult turns a failed condition check into a false result.
+func (s *dynamoLeaseStore) conditionalResult(err error, msg string) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if isAWSErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return false, nil
+	}
+	return false, errors.Wrap(err, msg)
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == code
+}
+
+func leaseKey(lease *Lease) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		attrLeaseKey: {S: aws.String(lease.Key)},
+	}
+}
+
+func numberValue(n int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+}
+
+func leaseToItem(lease *Lease) map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		attrLeaseKey:                     {S: aws.String(lease.Key)},
+		attrLeaseCounter:                 numberValue(lease.Counter),
+		attrCheckpoint:                   {S: aws.String(lease.Checkpoint)},
+		attrCheckpointSubSequenceNumber:  numberValue(lease.CheckpointSubSequenceNumber),
+		attrOwnerSwitchesSinceCheckpoint: numberValue(lease.OwnerSwitchesSinceCheckpoint),
+	}
+
+	if lease.Owner != "" {
+		item[attrLeaseOwner] = &dynamodb.AttributeValue{S: aws.String(lease.Owner)}
+	}
+	if len(lease.ParentShardIDs) > 0 {
+		item[attrParentShardID] = &dynamodb.AttributeValue{SS: aws.StringSlice(lease.ParentShardIDs)}
+	}
+	if len(lease.ChildShardIDs) > 0 {
+		item[attrChildShardIDs] = &dynamodb.AttributeValue{SS: aws.StringSlice(lease.ChildShardIDs)}
+	}
+	if lease.StartingHashKey != "" {
+		item[attrStartingHashKey] = &dynamodb.AttributeValue{S: aws.String(lease.StartingHashKey)}
+	}
+	if lease.EndingHashKey != "" {
+		item[attrEndingHashKey] = &dynamodb.AttributeValue{S: aws.String(lease.EndingHashKey)}
+	}
+
+	return item
+}
+
+func leaseFromItem(item map[string]*dynamodb.AttributeValue) *Lease {
+	lease := &Lease{
+		Key:                          stringAttr(item, attrLeaseKey),
+		Owner:                        stringAttr(item, attrLeaseOwner),
+		Counter:                      numberAttr(item, attrLeaseCounter),
+		Checkpoint:                   stringAttr(item, attrCheckpoint),
+		CheckpointSubSequenceNumber:  numberAttr(item, attrCheckpointSubSequenceNumber),
+		OwnerSwitchesSinceCheckpoint: numberAttr(item, attrOwnerSwitchesSinceCheckpoint),
+		StartingHashKey:              stringAttr(item, attrStartingHashKey),
+		EndingHashKey:                stringAttr(item, attrEndingHashKey),
+	}
+
+	if v, ok := item[attrParentShardID]; ok {
+		lease.ParentShardIDs = aws.StringValueSlice(v.SS)
+	}
+	if v, ok := item[attrChildShardIDs]; ok {
+		lease.ChildShardIDs = aws.StringValueSlice(v.SS)
+	}
+
+	return lease
+}
+
+func stringAttr(item map[string]*dynamodb.AttributeValue, name string) string {
+	if v, ok := item[name]; ok && v.S != nil {
+		return *v.S
+	}
+	return ""
+}
+
+func numberAttr(item map[string]*dynamodb.AttributeValue, name string) int64 {
+	if v, ok := item[name]; ok && v.N != nil {
+		n, _ := strconv.ParseInt(*v.N, 10, 64)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/pkg/errors"
+)
+
+func (d *Daemon) listShards(ctx context.Context) ([]*kinesis.Shard, error) {
+	shards := []*kinesis.Shard{}
+	input := &kinesis.ListShardsInput{
+		StreamName: aws.String(d.config.StreamName),
+	}
+
+	for {
+		output, err := d.kinesis.ListShardsWithContext(ctx, input)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list shards")
+		}
+
+		shards = append(shards, output.Shards...)
+		if output.NextToken == nil {
+			return shards, nil
+		}
+
+		// StreamName must not be set when paginating with a NextToken.
+		input = &kinesis.ListShardsInput{NextToken: output.NextToken}
+	}
+}
+
+func parentShardIDs(shard *kinesis.Shard) []string {
+	parents := []string{}
+	if shard.ParentShardId != nil {
+		parents = append(parents, *shard.ParentShardId)
+	}
+	if shard.AdjacentParentShardId != nil {
+		parents = append(parents, *shard.AdjacentParentShardId)
+	}
+	return parents
+}
+
+func isShardClosed(shard *kinesis.Shard) bool {
+	return shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil
+}
+
+func isInitialPosition(checkpoint string) bool {
+	return checkpoint == TrimHorizon || checkpoint == Latest || checkpoint == AtTimestamp
+}
+
+// syncShards creates leases for shards that do not have one yet and, when
+// cleanupLeasesUponShardCompletion is set, deletes the leases of finished
+// shards whose children have started processing.
+//
+// Like the Java KCL, a lease is not created for a shard whose descendants
+// already have leases since that shard has already been processed, and the
+// children of leased shards always start at TRIM_HORIZON so that no records
+// are skipped after a reshard.
+func (d *Daemon) syncShards(ctx context.Context) error {
+	shards, err := d.listShards(ctx)
+	if err != nil {
+		return err
+	}
+
+	leases, err := d.store.ListLeases(ctx)
+	if err != nil {
+		return err
+	}
+
+	leaseByKey := map[string]*Lease{}
+	for _, lease := range leases {
+		leaseByKey[lease.Key] = lease
+	}
+
+	shardByID := map[string]*kinesis.Shard{}
+	children := map[string][]string{}
+	for _, shard := range shards {
+		shardByID[*shard.ShardId] = shard
+		for _, parent := range parentShardIDs(shard) {
+			children[parent] = append(children[parent], *shard.ShardId)
+		}
+	}
+
+	var hasLeasedDescendant func(shardID string) bool
+	hasLeasedDescendant = func(shardID string) bool {
+		for _, child := range children[shardID] {
+			if _, ok := leaseByKey[child]; ok || hasLeasedDescendant(child) {
+				return true
+			}
+		}
+		return false
+	}
+
+	toCreate := map[string]bool{}
+	for _, shard := range shards {
+		shardID := *shard.ShardId
+		if _, ok := leaseByKey[shardID]; ok || hasLeasedDescendant(shardID) {
+			continue
+		}
+		if d.config.InitialPositionInStream == Latest && isShardClosed(shard) {
+			continue
+		}
+		toCreate[shardID] = true
+	}
+
+	var hasLeasedAncestor func(shardID string) bool
+	hasLeasedAncestor = func(shardID string) bool {
+		shard, ok := shardByID[shardID]
+		if !ok {
+			return false
+		}
+		for _, parent := range parentShardIDs(shard) {
+			if _, ok := leaseByKey[parent]; ok || toCreate[parent] || hasLeasedAncestor(parent) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, shard := range shards {
+		shardID := *shard.ShardId
+		if !toCreate[shardID] {
+			continue
+		}
+
+		checkpoint := d.config.InitialPositionInStream
+		if hasLeasedAncestor(shardID) {
+			checkpoint = TrimHorizon
+		}
+
+		lease := &Lease{
+			Key:            shardID,
+			Checkpoint:     checkpoint,
+			ParentShardIDs: parentShardIDs(shard),
+			ChildShardIDs:  children[shardID],
+		}
+		if shard.HashKeyRange != nil {
+			lease.StartingHashKey = aws.StringValue(shard.HashKeyRange.StartingHashKey)
+			lease.EndingHashKey = aws.StringValue(shard.HashKeyRange.EndingHashKey)
+		}
+
+		created, err := d.store.CreateLeaseIfNotExists(ctx, lease)
+		if err != nil {
+			return err
+		}
+		if created {
+			d.logger.Printf("Created lease for shard %s at %s", shardID, checkpoint)
+			leaseByKey[shardID] = lease
+		}
+	}
+
+	if d.config.CleanupLeasesUponShardCompletion {
+		d.cleanupFinishedLeases(ctx, leases, leaseByKey, children)
+	}
+
+	return nil
+}
+
+func (d *Daemon) cleanupFinishedLeases(ctx context.Context, leases []*Lease, leaseByKey map[string]*Lease, children map[string][]string) {
+	for _, lease := range leases {
+		if lease.Checkpoint != ShardEnd || len(children[lease.Key]) == 0 {
+			continue
+		}
+
+		childrenStarted := true
+		for _, child := range children[lease.Key] {
+			childLease, ok := leaseByKey[child]
+			if !ok || isInitialPosition(childLease.Checkpoint) {
+				childrenStarted = false
+				break
+			}
+		}
+		if !childrenStarted {
+			continue
+		}
+
+		if err := d.store.DeleteLease(ctx, lease); err != nil {
+			d.logger.Printf("Failed to clean up lease for finished shard %s: %+v", lease.Key, err)
+			continue
+		}
+		d.logger.Printf("Cleaned up lease for finished shard %s", lease.Key)
+	}
+}
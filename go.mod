@@ -1,6 +1,6 @@
 module github.com/goguardian/goguardian-go-kcl
 
-go 1.19
+go 1.21
 
 require (
 	github.com/aws/aws-sdk-go v1.44.245
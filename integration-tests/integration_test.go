@@ -1,29 +1,27 @@
 package integration_tests
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"testing"
-	"text/template"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/goguardian/goguardian-go-kcl/daemon"
 	"github.com/goguardian/goguardian-go-kcl/runner"
 )
 
 const defaultTimeout = 30 * time.Second
 
-type propertiesVar struct {
-	StreamName string
-	AppName    string
-}
-
-func TestRecordsReceived(t *testing.T) {
+// setupTestStream creates a fresh stream containing the records "alice",
+// "bob" and "charlie" and returns the path of a properties file for a
+// consumer of that stream.
+func setupTestStream(t *testing.T) string {
 	now := time.Now()
-	testStreamName := fmt.Sprintf("stream_%d", now.Unix())
-	testAppName := fmt.Sprintf("app_%d", now.Unix())
+	testStreamName := fmt.Sprintf("stream_%d", now.UnixNano())
+	testAppName := fmt.Sprintf("app_%d", now.UnixNano())
 
 	fmt.Println("Getting local kinesis client")
 	tClient, err := GetLocalKinesisClient()
@@ -50,66 +48,106 @@ func TestRecordsReceived(t *testing.T) {
 	}
 
 	// Create properties file for this test consumer
-	tmpl, err := template.ParseFiles("test-app/test_app_properties.tmpl")
-	if err != nil {
-		t.Fatal("failed to parse properties template file")
+	config := &runner.Config{
+		ExecutableName:          "../integration-tests/test-app/test_app",
+		StreamName:              testStreamName,
+		ApplicationName:         testAppName,
+		RegionName:              "us-east-1",
+		ProcessingLanguage:      "go",
+		AWSCredentialsProvider:  "DefaultCredentialsProvider",
+		InitialPositionInStream: runner.TrimHorizon,
+		FailoverTime:            3 * time.Second,
+		ShardSyncInterval:       5 * time.Second,
+		ShutdownGrace:           10 * time.Second,
+
+		// These settings are needed in order to work with localstack
+		KinesisEndpoint:  "http://localhost:4566",
+		DynamoDBEndpoint: "http://localhost:4566",
+
+		// localstack uses kinesalite which doesn't support HTTP2 enhanced fanout yet
+		// https://github.com/mhart/kinesalite/issues/75
+		RetrievalMode: runner.RetrievalModePolling,
 	}
 
-	propertiesFile, err := ioutil.TempFile("", "test_app_properties")
+	propertiesFile, err := config.WriteTempFile()
 	if err != nil {
-		t.Fatal("failed to create properties file")
+		t.Fatal(err)
 	}
-
-	err = tmpl.Execute(propertiesFile, propertiesVar{
-		AppName:    testAppName,
-		StreamName: testStreamName,
+	t.Cleanup(func() {
+		os.Remove(propertiesFile)
 	})
-	if err != nil {
-		t.Fatal("failed to populate properties file")
-	}
 
-	propertiesFile.Close()
+	return propertiesFile
+}
 
-	javaHome := os.Getenv("JAVA_HOME")
-	if javaHome == "" {
-		t.Fatal("JAVA_HOME environment variable not specified")
+// waitForTestRecords blocks until every record put by setupTestStream has
+// been received.
+func waitForTestRecords() {
+	receiver := GetMessageReceiver()
+	receivedRecords := map[string]bool{}
+	for {
+		req := <-receiver.processRecordsChan
+		for _, record := range req.Records {
+			receivedRecords[string(record.Data)] = true
+		}
+
+		if receivedRecords["alice"] && receivedRecords["bob"] && receivedRecords["charlie"] {
+			fmt.Println("found all the records")
+			return
+		}
 	}
+}
+
+func TestRecordsReceived(t *testing.T) {
+	propertiesFile := setupTestStream(t)
+
 	r, err := runner.GetRunner(
 		runner.WithPathToJarFolder("../jar"),
-		runner.WithPathToPropertiesFile(propertiesFile.Name()),
-		runner.WithPathToJavaBinary(javaHome+"/bin/java"),
+		runner.WithPathToPropertiesFile(propertiesFile),
 		runner.WithLogger(log.New(os.Stdout, "CUSTOM PREFIX:", 0)),
+		runner.WithCredentials(credentials.NewStaticCredentials("some_key", "some_secret_key", ""), "", runner.CredentialsEndpoint),
 	)
 	if err != nil {
 		t.Fatal("failed to get runner")
 	}
+	defer r.Close()
 
-	testPassed := make(chan bool)
-	receiver := GetMessageReceiver()
-	go func() {
-		receivedRecords := map[string]bool{}
-		for {
-			req := <-receiver.processRecordsChan
-			for _, record := range req.Records {
-				receivedRecords[string(record.Data)] = true
-			}
-
-			if receivedRecords["alice"] && receivedRecords["bob"] && receivedRecords["charlie"] {
-				fmt.Println("found all the records")
-				testPassed <- true
-			}
-		}
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd, err := r.RunJavaDaemon(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForTestRecords()
+	cancel()
+	cmd.Wait()
+}
+
+func TestRecordsReceivedWithGoDaemon(t *testing.T) {
+	propertiesFile := setupTestStream(t)
 
-	var cmd *exec.Cmd
+	// The Go daemon uses the default credential chain of the Go AWS SDK.
+	t.Setenv("AWS_ACCESS_KEY_ID", "some_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "some_secret_key")
+
+	d, err := daemon.GetDaemonFromPropertiesFile(propertiesFile,
+		daemon.WithLogger(log.New(os.Stdout, "GO DAEMON:", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
 	go func() {
-		var err error
-		cmd, err = r.RunJavaDaemon("-Daws.accessKeyId=some_key", "-Daws.secretKey=some_secret_key")
-		if err != nil {
-			t.Fatal(err)
-		}
+		finished <- d.Run(ctx)
 	}()
 
-	<-testPassed
-	cmd.Process.Kill()
+	waitForTestRecords()
+	cancel()
+	if err = <-finished; err != nil {
+		t.Fatal(err)
+	}
 }
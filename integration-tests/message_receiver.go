@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
 
 	"github.com/goguardian/goguardian-go-kcl/kcl"
 )
@@ -15,13 +16,22 @@ type messageReceiver struct {
 	processRecordsChan chan *kcl.ProcessRecordsInput
 }
 
+var (
+	receiver     *messageReceiver
+	receiverOnce sync.Once
+)
+
+// GetMessageReceiver returns the receiver shared by every test since the
+// HTTP server can only listen on ReceiverPort once.
 func GetMessageReceiver() *messageReceiver {
-	m := &messageReceiver{
-		processRecordsChan: make(chan *kcl.ProcessRecordsInput),
-	}
+	receiverOnce.Do(func() {
+		receiver = &messageReceiver{
+			processRecordsChan: make(chan *kcl.ProcessRecordsInput),
+		}
 
-	go m.startHTTPServer()
-	return m
+		go receiver.startHTTPServer()
+	})
+	return receiver
 }
 
 func (m *messageReceiver) startHTTPServer() {
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"strconv"
+
+	"github.com/goguardian/goguardian-go-kcl/jars"
 )
 
 // Credit to https://github.com/arthurbailao/aws-kcl/blob/master/cmd/aws-kcl/download.go
@@ -17,7 +20,7 @@ func main() {
 		dstFolder = os.Args[1]
 	}
 
-	mavenBaseURL := "https://repo1.maven.org/maven2/"
+	mavenBaseURL := jars.DefaultMavenBaseURL
 	if os.Getenv("MAVEN_BASE_URL") != "" {
 		mavenBaseURL = os.Getenv("MAVEN_BASE_URL")
 	}
@@ -34,8 +37,12 @@ func main() {
 	}
 	fmt.Printf("Using max Maven HTTP retries: %d\n", maxRetries)
 
-	d := getDownloader(maxRetries, mavenBaseURL)
-	err := d.download(dstFolder)
+	d := jars.GetDownloader(
+		jars.WithMavenBaseURL(mavenBaseURL),
+		jars.WithMaxRetries(maxRetries),
+		jars.WithLogger(log.New(os.Stdout, "", 0)),
+	)
+	err := d.Download(dstFolder)
 	if err != nil {
 		fmt.Printf("failed to download due to error: %+v\n", err)
 		os.Exit(1)
@@ -0,0 +1,294 @@
+package jars
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMavenBaseURL is the Maven repository jars are downloaded from by
+// default.
+const DefaultMavenBaseURL = "https://repo1.maven.org/maven2/"
+
+// Option configures a Downloader.
+type Option func(*Downloader)
+
+// WithMaxRetries sets how many times a jar is requested before the download
+// fails. Defaults to 3.
+func WithMaxRetries(n int) Option {
+	return func(d *Downloader) {
+		d.maxRetries = n
+	}
+}
+
+// WithMavenBaseURL sets the Maven repository to download from, e.g. a mirror.
+// Defaults to DefaultMavenBaseURL.
+func WithMavenBaseURL(url string) Option {
+	return func(d *Downloader) {
+		d.mavenBaseURL = url
+	}
+}
+
+// WithPackages sets the jars to download. Defaults to KCLPackages.
+func WithPackages(packages []MavenPackage) Option {
+	return func(d *Downloader) {
+		d.packages = packages
+	}
+}
+
+// WithHTTPClient sets the client the jars are downloaded with.
+func WithHTTPClient(c *http.Client) Option {
+	return func(d *Downloader) {
+		d.httpClient = c
+	}
+}
+
+// WithLogger sets the logger downloads are reported to.
+func WithLogger(logger *log.Logger) Option {
+	return func(d *Downloader) {
+		d.logger = logger
+	}
+}
+
+// Downloader downloads jars from Maven into a jar folder and writes its
+// manifest.
+type Downloader struct {
+	logger     *log.Logger
+	maxRetries int
+	backoff    time.Duration
+	httpClient *http.Client
+
+	mavenBaseURL string
+	packages     []MavenPackage
+}
+
+func GetDownloader(opts ...Option) *Downloader {
+	d := &Downloader{
+		logger:     log.New(os.Stdout, "", log.LstdFlags),
+		maxRetries: 3,
+		backoff:    500 * time.Millisecond,
+		httpClient: &http.Client{},
+
+		packages:     KCLPackages,
+		mavenBaseURL: DefaultMavenBaseURL,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Download fetches each jar package from Maven and saves it in the specified
+// dstPath. Jars that are already in dstPath are kept.
+func (d *Downloader) Download(dstPath string) error {
+	return d.download(dstPath, func(pkg MavenPackage) bool {
+		_, err := os.Stat(filepath.Join(dstPath, pkg.Name()))
+		return err == nil
+	})
+}
+
+// Ensure makes sure that the jar folder dir holds exactly the jars of the
+// downloader, as listed by its manifest. A folder that matches is left as
+// is. Otherwise the jars that are missing or do not match the manifest are
+// downloaded, those of the previous manifest that are no longer needed are
+// removed, and the manifest is rewritten.
+func (d *Downloader) Ensure(dir string) error {
+	valid := map[string]bool{}
+	manifest, err := ReadManifest(dir)
+	if err == nil {
+		err = manifest.Verify(dir)
+		if err == nil && manifest.lists(d.packages) {
+			return nil
+		}
+
+		for _, pkg := range manifest.Packages {
+			path := filepath.Join(dir, pkg.File)
+			checksum, err := FileSHA256(path)
+			if err == nil && checksum == pkg.SHA256 && d.needs(pkg.File) {
+				valid[pkg.File] = true
+				continue
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "failed to remove jar %s", path)
+			}
+		}
+	}
+
+	switch {
+	case os.IsNotExist(errors.Cause(err)):
+		d.logger.Printf("Downloading jars into %s.", dir)
+	case err != nil:
+		d.logger.Printf("Updating jar folder %s: %s", dir, err)
+	default:
+		d.logger.Printf("Updating jar folder %s to the required packages.", dir)
+	}
+
+	err = d.download(dir, func(pkg MavenPackage) bool {
+		return valid[pkg.Name()]
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest, err = ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	return manifest.Verify(dir)
+}
+
+// needs reports whether file is one of the jars of the downloader.
+func (d *Downloader) needs(file string) bool {
+	for _, pkg := range d.packages {
+		if pkg.Name() == file {
+			return true
+		}
+	}
+	return false
+}
+
+// lists reports whether the manifest lists exactly packages.
+func (m *Manifest) lists(packages []MavenPackage) bool {
+	if len(m.Packages) != len(packages) {
+		return false
+	}
+
+	listed := map[MavenPackage]bool{}
+	for _, pkg := range m.Packages {
+		listed[MavenPackage{pkg.Group, pkg.Artifact, pkg.Version}] = true
+	}
+	for _, pkg := range packages {
+		if !listed[pkg] {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheDir returns the folder in the user's cache directory that the jars of
+// the downloader are kept in. Each set of packages gets its own folder, so
+// runners of different versions can share the cache.
+func (d *Downloader) CacheDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find cache directory")
+	}
+
+	names := make([]string, len(d.packages))
+	for i, pkg := range d.packages {
+		names[i] = pkg.Group + ":" + pkg.Name()
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		io.WriteString(h, name+"\n")
+	}
+	return filepath.Join(cache, "goguardian-go-kcl", "jars-"+hex.EncodeToString(h.Sum(nil))[:12]), nil
+}
+
+// download fetches the packages that keep returns false for into dstPath and
+// writes the manifest of all of them.
+func (d *Downloader) download(dstPath string, keep func(MavenPackage) bool) error {
+	if err := os.MkdirAll(dstPath, 0o755); err != nil {
+		return errors.Wrap(err, "failed to make jar directory")
+	}
+
+	manifest := &Manifest{}
+	for _, pkg := range d.packages {
+		filename := filepath.Join(dstPath, pkg.Name())
+
+		if !keep(pkg) {
+			if err := d.downloadFileWithRetry(pkg.URL(d.mavenBaseURL), filename); err != nil {
+				return err
+			}
+		}
+
+		checksum, err := FileSHA256(filename)
+		if err != nil {
+			return err
+		}
+		manifest.Packages = append(manifest.Packages, Package{
+			Group:    pkg.Group,
+			Artifact: pkg.Artifact,
+			Version:  pkg.Version,
+			File:     pkg.Name(),
+			SHA256:   checksum,
+		})
+	}
+
+	// The runner can verify the folder against the manifest before starting
+	// the daemon.
+	return manifest.Write(dstPath)
+}
+
+func (d *Downloader) downloadFileWithRetry(src, dst string) error {
+	var err error
+	backoff := d.backoff
+
+	for i := 0; i < d.maxRetries; i++ {
+		d.logger.Printf("Downloading %s to %s", src, dst)
+		err = d.downloadFile(src, dst)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2 // exponentially backoff
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// downloadFile downloads src to a temporary file next to dst that is renamed
+// to dst once complete, so that an interrupted download leaves no partial
+// jar behind.
+func (d *Downloader) downloadFile(src, dst string) error {
+	resp, err := d.httpClient.Get(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to download file")
+	}
+	defer resp.Body.Close()
+
+	if !(resp.StatusCode >= 200 && resp.StatusCode <= 299) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("non-2XX status code '%d'\n%s\n", resp.StatusCode, string(body))
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.part")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create destination file: %s", dst)
+	}
+	defer os.Remove(out.Name())
+
+	_, err = io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write data to file")
+	}
+
+	if err = os.Chmod(out.Name(), 0o644); err != nil {
+		return errors.Wrapf(err, "failed to make destination file readable: %s", dst)
+	}
+	if err = os.Rename(out.Name(), dst); err != nil {
+		return errors.Wrapf(err, "failed to move download to destination file: %s", dst)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package jars
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownload(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("packageData"))
+	}))
+	defer server.Close()
+
+	d := GetDownloader(WithMavenBaseURL(server.URL+"/"), WithPackages([]MavenPackage{
+		{
+			Artifact: "some.artifact.path",
+			Group:    "some-package-group",
+			Version:  "1.2.3",
+		},
+	}))
+
+	tempDir, err := os.MkdirTemp("", "someDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Test function
+	err = d.Download(tempDir)
+	if err != nil {
+		t.Errorf("failed to download jar package: %+v", err)
+	}
+
+	// Validate file is saved properly
+	downloadedFile := path.Join(tempDir, "some.artifact.path-1.2.3.jar")
+	data, err := os.ReadFile(downloadedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "packageData" {
+		t.Errorf("expected jar file to contain 'packageData', but instead it contained '%s'", string(data))
+	}
+
+	manifest, err := ReadManifest(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manifest.Verify(tempDir); err != nil {
+		t.Errorf("expected the jar folder to match its manifest: %+v", err)
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("jar of " + path.Base(req.URL.Path)))
+	}))
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "cache", "jars")
+	ensure := func(packages ...MavenPackage) {
+		t.Helper()
+		d := GetDownloader(WithMavenBaseURL(server.URL+"/"), WithPackages(packages))
+		if err := d.Ensure(dir); err != nil {
+			t.Fatalf("failed to ensure jars: %+v", err)
+		}
+	}
+	expectRequests := func(expected int32) {
+		t.Helper()
+		if n := atomic.SwapInt32(&requests, 0); n != expected {
+			t.Errorf("expected %d downloads, got %d", expected, n)
+		}
+	}
+
+	a := MavenPackage{"com.example", "a", "1.0"}
+	b := MavenPackage{"com.example", "b", "1.0"}
+	b2 := MavenPackage{"com.example", "b", "2.0"}
+
+	// A missing folder is created and filled.
+	ensure(a, b)
+	expectRequests(2)
+
+	// A folder that matches its manifest is left alone.
+	ensure(a, b)
+	expectRequests(0)
+
+	// A corrupted jar is downloaded again.
+	if err := os.WriteFile(filepath.Join(dir, "a-1.0.jar"), []byte("corrupted"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ensure(a, b)
+	expectRequests(1)
+
+	// Only the changed package is downloaded and the old jar is removed.
+	ensure(a, b2)
+	expectRequests(1)
+	if _, err := os.Stat(filepath.Join(dir, "b-1.0.jar")); !os.IsNotExist(err) {
+		t.Errorf("expected the jar of the previous version to be removed, got %v", err)
+	}
+
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.lists([]MavenPackage{a, b2}) {
+		t.Errorf("expected the manifest to list the new packages, got %+v", manifest.Packages)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "b-2.0.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "jar of b-2.0.jar" {
+		t.Errorf("unexpected jar content '%s'", data)
+	}
+}
+
+func TestEnsureFailedDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := GetDownloader(
+		WithMavenBaseURL(server.URL+"/"),
+		WithMaxRetries(1),
+		WithPackages([]MavenPackage{{"com.example", "a", "1.0"}}),
+	)
+	if err := d.Ensure(dir); err == nil {
+		t.Fatal("expected the download to fail")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no files to be left behind, got %v", files)
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	a := GetDownloader(WithPackages([]MavenPackage{{"com.example", "a", "1.0"}, {"com.example", "b", "1.0"}}))
+	b := GetDownloader(WithPackages([]MavenPackage{{"com.example", "b", "1.0"}, {"com.example", "a", "1.0"}}))
+	c := GetDownloader(WithPackages([]MavenPackage{{"com.example", "a", "2.0"}, {"com.example", "b", "1.0"}}))
+
+	dirA, err := a.CacheDir()
+	if err != nil {
+		t.Skipf("no cache directory: %v", err)
+	}
+	dirB, _ := b.CacheDir()
+	dirC, _ := c.CacheDir()
+
+	if dirA != dirB {
+		t.Errorf("expected the order of packages not to matter, got %s and %s", dirA, dirB)
+	}
+	if dirA == dirC {
+		t.Errorf("expected different packages to get different folders, got %s", dirA)
+	}
+}
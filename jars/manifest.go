@@ -0,0 +1,116 @@
+// Package jars downloads and describes the folder of jars the MultiLangDaemon
+// runs from.
+package jars
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ManifestFile is the name of the manifest in a jar folder.
+const ManifestFile = "manifest.json"
+
+// Package is a jar downloaded from Maven.
+type Package struct {
+	Group    string `json:"group"`
+	Artifact string `json:"artifact"`
+	Version  string `json:"version"`
+	// File is the name of the jar in the jar folder.
+	File string `json:"file"`
+	// SHA256 is the hex encoded SHA-256 checksum of the jar.
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists the jars that belong in a jar folder.
+type Manifest struct {
+	Packages []Package `json:"packages"`
+}
+
+// ReadManifest reads the manifest of the jar folder dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read jar manifest")
+	}
+
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "failed to parse jar manifest")
+	}
+	return m, nil
+}
+
+// Write writes the manifest to the jar folder dir.
+func (m *Manifest) Write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode jar manifest")
+	}
+
+	err = os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0o644)
+	if err != nil {
+		return errors.Wrap(err, "failed to write jar manifest")
+	}
+	return nil
+}
+
+// Verify checks that every package of the manifest is in the jar folder dir
+// with the expected checksum and that the folder has no other jars.
+func (m *Manifest) Verify(dir string) error {
+	expected := map[string]bool{}
+	problems := []string{}
+	for _, pkg := range m.Packages {
+		expected[pkg.File] = true
+
+		checksum, err := FileSHA256(filepath.Join(dir, pkg.File))
+		if os.IsNotExist(errors.Cause(err)) {
+			problems = append(problems, "missing "+pkg.File)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if checksum != pkg.SHA256 {
+			problems = append(problems, "checksum mismatch for "+pkg.File)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to read jar folder")
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".jar") && !expected[f.Name()] {
+			problems = append(problems, "unexpected "+f.Name())
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.Errorf("jar folder %s does not match its manifest: %s", dir, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// FileSHA256 returns the hex encoded SHA-256 checksum of the file at path.
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open jar")
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", errors.Wrap(err, "failed to read jar")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,40 @@
+package jars
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestVerify(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"a-1.0.jar", "b-1.0.jar", "stale-0.9.jar"} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &Manifest{}
+	for _, file := range []string{"a-1.0.jar", "b-1.0.jar", "c-1.0.jar"} {
+		m.Packages = append(m.Packages, Package{File: file, SHA256: "0000"})
+	}
+	checksum, err := FileSHA256(filepath.Join(dir, "a-1.0.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Packages[0].SHA256 = checksum
+
+	if err = m.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	m, err = ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Verify(dir)
+	expected := "jar folder " + dir + " does not match its manifest: checksum mismatch for b-1.0.jar, missing c-1.0.jar, unexpected stale-0.9.jar"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected the error '%s' but got %+v", expected, err)
+	}
+}
@@ -1,26 +1,32 @@
-package main
+package jars
 
 import (
 	"fmt"
 	"strings"
 )
 
-type mavenPackage struct {
+// MavenPackage identifies a jar in a Maven repository.
+type MavenPackage struct {
 	Group    string
 	Artifact string
 	Version  string
 }
 
-func (pkg *mavenPackage) URL(mavenBaseURL string) string {
+// URL returns the URL of the jar in the Maven repository at mavenBaseURL,
+// which ends with a slash.
+func (pkg *MavenPackage) URL(mavenBaseURL string) string {
 	paths := strings.Split(pkg.Group, ".")
 	paths = append(paths, pkg.Artifact, pkg.Version, pkg.Name())
 	return mavenBaseURL + strings.Join(paths, "/")
 }
 
-func (pkg *mavenPackage) Name() string {
+// Name returns the file name of the jar.
+func (pkg *MavenPackage) Name() string {
 	return fmt.Sprintf("%s-%s.jar", pkg.Artifact, pkg.Version)
 }
 
+// KCLPackages are the jars the MultiLangDaemon runs from.
+//
 // To update the packages to newer versions follow instructions here:
 // https://github.com/awslabs/amazon-kinesis-client-python/blob/master/scripts/build_deps.py
 // TODO: Be careful when updating the aws java sdk dependencies:
@@ -28,7 +34,7 @@ func (pkg *mavenPackage) Name() string {
 // These versions include an issue that causes an exception error related to KCL's DynamoDB usage.
 // We recommend that you use the AWS SDK for Java version 2.28.0 or later to avoid this issue.
 // See: https://docs.aws.amazon.com/streams/latest/dev/kcl-migration-from-2-3.html#kcl-migration-from-2-3-prerequisites
-var mavenPackages = []mavenPackage{
+var KCLPackages = []MavenPackage{
 	{"software.amazon.kinesis", "amazon-kinesis-client-multilang", "3.0.3"},
 	{"software.amazon.kinesis", "amazon-kinesis-client", "3.0.3"},
 	{"software.amazon.glue", "schema-registry-common", "1.1.19"},
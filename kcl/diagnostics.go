@@ -0,0 +1,114 @@
+package kcl
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DiagnosticsDirEnv is the environment variable the runner sets to its
+// diagnostics folder. A record processor started with it registers in the
+// ProcessorsDir subfolder, touches its registration whenever it checkpoints so
+// that the runner can tell that it makes progress, and writes a dump of its
+// goroutines to the folder when it receives SIGUSR1.
+const DiagnosticsDirEnv = "GO_KCL_DIAGNOSTICS_DIR"
+
+// ProcessorsDir is the subfolder of the diagnostics folder that record
+// processors register in, with a file named after their PID.
+const ProcessorsDir = "processors"
+
+var (
+	diagnosticsOnce sync.Once
+	diagnostics     *processDiagnostics
+)
+
+// processDiagnostics is the registration of the process in the diagnostics
+// folder. It is shared by every record processor of the process.
+type processDiagnostics struct {
+	logger       *log.Logger
+	dir          string
+	registration string
+}
+
+// startDiagnostics registers the process in the diagnostics folder the first
+// time it is called. It returns nil if the process was not started by the
+// runner with a diagnostics folder.
+func startDiagnostics(logger *log.Logger) *processDiagnostics {
+	diagnosticsOnce.Do(func() {
+		dir := os.Getenv(DiagnosticsDirEnv)
+		if dir == "" {
+			return
+		}
+
+		d := newProcessDiagnostics(dir, logger)
+		if err := d.register(); err != nil {
+			logger.Printf("Failed to register for diagnostics: %+v", err)
+			return
+		}
+		notifyGoroutineDumps(d)
+		diagnostics = d
+	})
+	return diagnostics
+}
+
+func newProcessDiagnostics(dir string, logger *log.Logger) *processDiagnostics {
+	return &processDiagnostics{
+		logger:       logger,
+		dir:          dir,
+		registration: filepath.Join(dir, ProcessorsDir, strconv.Itoa(os.Getpid())),
+	}
+}
+
+// register creates the registration file of the process.
+func (d *processDiagnostics) register() error {
+	if err := os.MkdirAll(filepath.Dir(d.registration), 0o755); err != nil {
+		return errors.Wrap(err, "failed to make processors folder")
+	}
+
+	f, err := os.Create(d.registration)
+	if err != nil {
+		return errors.Wrap(err, "failed to create registration file")
+	}
+	return f.Close()
+}
+
+// checkpointed records that a record processor checkpointed. It does nothing
+// on a nil receiver.
+func (d *processDiagnostics) checkpointed() {
+	if d == nil {
+		return
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(d.registration, now, now); err != nil {
+		// The runner clears the folder when it starts a new daemon.
+		if err = d.register(); err != nil {
+			d.logger.Printf("Failed to record checkpoint for diagnostics: %+v", err)
+		}
+	}
+}
+
+// writeGoroutineDump writes the stacks of all goroutines to a file in the
+// diagnostics folder and returns its path.
+func (d *processDiagnostics) writeGoroutineDump() (string, error) {
+	name := fmt.Sprintf("goroutines-%d-%s.txt", os.Getpid(), time.Now().UTC().Format("20060102T150405.000Z"))
+	path := filepath.Join(d.dir, name)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create goroutine dump")
+	}
+	defer f.Close()
+
+	if err = pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		return "", errors.Wrap(err, "failed to write goroutine dump")
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package kcl
+
+// notifyGoroutineDumps does nothing, since there is no SIGUSR1 to ask for a
+// goroutine dump.
+func notifyGoroutineDumps(d *processDiagnostics) {}
@@ -0,0 +1,57 @@
+package kcl
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	d := newProcessDiagnostics(dir, log.New(io.Discard, "", 0))
+	if err := d.register(); err != nil {
+		t.Fatalf("failed to register: %+v", err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(d.registration, old, old); err != nil {
+		t.Fatal(err)
+	}
+	d.checkpointed()
+	info, err := os.Stat(d.registration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(info.ModTime()) > time.Minute {
+		t.Errorf("expected the checkpoint to touch the registration, modified at %s", info.ModTime())
+	}
+
+	// The registration is created again after the runner cleared the folder.
+	if err = os.Remove(d.registration); err != nil {
+		t.Fatal(err)
+	}
+	d.checkpointed()
+	if _, err = os.Stat(d.registration); err != nil {
+		t.Errorf("expected the registration to be created again: %v", err)
+	}
+
+	path, err := d.writeGoroutineDump()
+	if err != nil {
+		t.Fatalf("failed to dump goroutines: %+v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "TestProcessDiagnostics") {
+		t.Errorf("expected the dump to contain the stack of the test, got:\n%s", data)
+	}
+}
+
+func TestProcessDiagnostics_Nil(t *testing.T) {
+	var d *processDiagnostics
+	d.checkpointed()
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package kcl
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyGoroutineDumps writes a goroutine dump whenever the process receives
+// SIGUSR1.
+func notifyGoroutineDumps(d *processDiagnostics) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	go func() {
+		for range signals {
+			path, err := d.writeGoroutineDump()
+			if err != nil {
+				d.logger.Printf("Failed to dump goroutines: %+v", err)
+				continue
+			}
+			d.logger.Printf("Dumped goroutines to %s", path)
+		}
+	}()
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -19,18 +20,30 @@ type KCLProcess interface {
 
 // Record format comes from https://github.com/awslabs/amazon-kinesis-client/blob/master/amazon-kinesis-client-multilang/src/main/java/software/amazon/kinesis/multilang/package-info.java
 type Record struct {
-	Data           []byte `json:"data"`
-	PartitionKey   string `json:"partitionKey"`
-	SequenceNumber string `json:"sequenceNumber"`
+	Data              []byte `json:"data"`
+	PartitionKey      string `json:"partitionKey"`
+	SequenceNumber    string `json:"sequenceNumber"`
+	SubSequenceNumber int64  `json:"subSequenceNumber"`
+
+	// ApproximateArrivalTimestamp is when Kinesis accepted the record, in
+	// milliseconds since the epoch.
+	ApproximateArrivalTimestamp int64 `json:"approximateArrivalTimestamp"`
+}
+
+// ArrivalTime returns ApproximateArrivalTimestamp as a time.Time.
+func (r *Record) ArrivalTime() time.Time {
+	return time.Unix(0, r.ApproximateArrivalTimestamp*int64(time.Millisecond))
 }
 
 // message format comes from https://github.com/awslabs/amazon-kinesis-client/blob/master/amazon-kinesis-client-multilang/src/main/java/software/amazon/kinesis/multilang/package-info.java
 type message struct {
-	Action     string   `json:"action"`
-	ShardID    string   `json:"shardId"`
-	Checkpoint string   `json:"checkpoint"`
-	Records    []Record `json:"records"`
-	Error      string   `json:"error"`
+	Action            string   `json:"action"`
+	ShardID           string   `json:"shardId"`
+	SequenceNumber    string   `json:"sequenceNumber"`
+	SubSequenceNumber int64    `json:"subSequenceNumber"`
+	Checkpoint        string   `json:"checkpoint"`
+	Records           []Record `json:"records"`
+	Error             string   `json:"error"`
 }
 
 // statusMessage represents a status.
@@ -52,6 +65,8 @@ type kclProcess struct {
 
 	reader *bufio.Reader
 	writer *bufio.Writer
+
+	diagnostics *processDiagnostics
 }
 
 // Option signifies the type of options that can be passed to the kclProcess.
@@ -149,6 +164,8 @@ func (k *kclProcess) readLine() ([]byte, error) {
 }
 
 func (k *kclProcess) Run() error {
+	k.diagnostics = startDiagnostics(k.logger)
+
 	for {
 		msg, err := k.readMessage()
 
@@ -167,7 +184,9 @@ func (k *kclProcess) Run() error {
 		case "initialize":
 			k.shardID = msg.ShardID
 			k.recordProcessor.Initialize(&InitializationInput{
-				ShardID: k.shardID,
+				ShardID:           k.shardID,
+				SequenceNumber:    msg.SequenceNumber,
+				SubSequenceNumber: msg.SubSequenceNumber,
 			})
 
 		case "processRecords":
@@ -193,6 +212,12 @@ func (k *kclProcess) Run() error {
 			return errors.New("unknown message")
 		}
 
+		if f, ok := k.recordProcessor.(FailingRecordProcessor); ok {
+			if err := f.Err(); err != nil {
+				return errors.Wrapf(err, "record processor for shard %s failed", k.shardID)
+			}
+		}
+
 		if err := k.writeStatus(msg.Action); err != nil {
 			return errors.Wrap(err, "error writing status")
 		}
@@ -226,6 +251,7 @@ func (k *kclProcess) checkpoint(sequenceNumber *string) error {
 	switch checkpointMsgOutput.Action {
 	case "checkpoint":
 		// successful checkpoint
+		k.diagnostics.checkpointed()
 	default:
 		// unsuccessful checkpoint
 		return errors.Errorf("unknown message '%s', expecting checkpoint message", checkpointMsgOutput.Action)
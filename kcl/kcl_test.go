@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockProcessor struct {
@@ -41,7 +43,7 @@ func (p *mockProcessor) ShutdownRequested(input *ShutdownRequestedInput) {
 func TestRun_Initialize(t *testing.T) {
 	mProcessor := &mockProcessor{}
 	outputBuffer := &bytes.Buffer{}
-	inputLines := `{"action": "initialize", "shardId": "someShardID"}` +
+	inputLines := `{"action": "initialize", "shardId": "someShardID", "sequenceNumber": "123", "subSequenceNumber": 4}` +
 		"\n" +
 		`{"action": "shutdownRequested"}` +
 		"\n"
@@ -80,6 +82,10 @@ func TestRun_Initialize(t *testing.T) {
 		t.Errorf("unexpected shardID from initialize call %s", mProcessor.initializeCall.ShardID)
 	}
 
+	if mProcessor.initializeCall.SequenceNumber != "123" || mProcessor.initializeCall.SubSequenceNumber != 4 {
+		t.Errorf("unexpected checkpoint from initialize call %+v", mProcessor.initializeCall)
+	}
+
 	if mProcessor.shutdownRequestedCall == nil {
 		t.Errorf("expected shutdownRequested to have been called, but it was not")
 	}
@@ -91,7 +97,7 @@ func TestRun_ProcessRecords(t *testing.T) {
 	data := "testData"
 	base64Data := base64.StdEncoding.EncodeToString([]byte(data))
 	inputLines := `{"action": "processRecords", "records": ` +
-		`[{"data": "` + base64Data + `", "partitionKey": "somePartitionKey", "sequenceNumber": "someSequenceNumber"}]}` +
+		`[{"data": "` + base64Data + `", "partitionKey": "somePartitionKey", "sequenceNumber": "someSequenceNumber", "approximateArrivalTimestamp": 1636609142123}]}` +
 		"\n" +
 		`{"action": "shutdownRequested"}` +
 		"\n"
@@ -139,6 +145,11 @@ func TestRun_ProcessRecords(t *testing.T) {
 		t.Errorf("expected 'someSequenceNumber', but got '%s'", processRecordsCall.SequenceNumber)
 	}
 
+	expectedArrivalTime := time.Date(2021, 11, 11, 5, 39, 2, 123000000, time.UTC)
+	if !processRecordsCall.ArrivalTime().Equal(expectedArrivalTime) {
+		t.Errorf("expected '%s', but got '%s'", expectedArrivalTime, processRecordsCall.ArrivalTime())
+	}
+
 	if mProcessor.shutdownRequestedCall == nil {
 		t.Errorf("expected shutdownRequested to have been called, but it was not")
 	}
@@ -289,6 +300,42 @@ func TestRun_UnknownAction(t *testing.T) {
 	}
 }
 
+type failingProcessor struct {
+	mockProcessor
+	err error
+}
+
+func (p *failingProcessor) Err() error {
+	return p.err
+}
+
+func TestRun_StopsWhenProcessorFailed(t *testing.T) {
+	mProcessor := &failingProcessor{err: errors.New("failed to load state")}
+	outputBuffer := &bytes.Buffer{}
+	inputLines := `{"action": "initialize", "shardId": "someShardID", "sequenceNumber": "123"}` +
+		"\n" +
+		`{"action": "processRecords", "records": []}` +
+		"\n"
+
+	k := &kclProcess{
+		recordProcessor: mProcessor,
+		logger:          defaultLogger,
+		reader:          bufio.NewReader(strings.NewReader(inputLines)),
+		writer:          bufio.NewWriter(outputBuffer),
+	}
+
+	err := k.Run()
+	if err == nil || !strings.Contains(err.Error(), "failed to load state") {
+		t.Errorf("expected the error of the record processor but got %+v", err)
+	}
+	if outputBuffer.Len() != 0 {
+		t.Errorf("expected the failed initialize not to be acknowledged but got '%s'", outputBuffer.String())
+	}
+	if mProcessor.processRecordsCall != nil {
+		t.Error("expected no records to be processed after the record processor failed")
+	}
+}
+
 func TestCheckpoint(t *testing.T) {
 	someSequenceNumber := "123"
 	testCases := []struct {
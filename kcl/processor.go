@@ -5,6 +5,10 @@ type CheckpointFunc = func(*string) error
 type (
 	InitializationInput struct {
 		ShardID string
+		// SequenceNumber is the shard's last checkpoint, either a sequence
+		// number or one of TRIM_HORIZON, LATEST, AT_TIMESTAMP or SHARD_END.
+		SequenceNumber    string
+		SubSequenceNumber int64
 	}
 	ProcessRecordsInput struct {
 		Records    []Record
@@ -26,3 +30,14 @@ type RecordProcessor interface {
 	ShardEnded(*ShardEndedInput)
 	ShutdownRequested(*ShutdownRequestedInput)
 }
+
+// FailingRecordProcessor is a RecordProcessor that can fail in a way its
+// callbacks can not report, e.g. when it can not load the state it needs in
+// Initialize. Once Err returns an error after a callback, the KCL process
+// stops with it without acknowledging the message, so that the
+// MultiLangDaemon retries the shard instead of it being processed further.
+type FailingRecordProcessor interface {
+	RecordProcessor
+	// Err returns the error the record processor failed with, or nil.
+	Err() error
+}
@@ -0,0 +1,150 @@
+package kcl
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ProcessorFactory returns a new RecordProcessor. The server calls it once
+// for every shard that connects.
+type ProcessorFactory func() RecordProcessor
+
+// Server hosts the record processors for many shards in a single long-lived
+// process. The MultiLangDaemon is configured to run the tiny shim executable
+// from the shim package, which forwards the multi-language protocol from its
+// STDIN and STDOUT over a Unix socket to the server. Each connection gets its
+// own RecordProcessor and is served on its own goroutine, so the callbacks
+// for one shard never run concurrently and a panic only ends the connection
+// it happened on.
+type Server struct {
+	newProcessor ProcessorFactory
+	opts         []Option
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// GetServer returns a Server that creates a RecordProcessor per connection
+// with newProcessor. The options are applied to the process serving each
+// connection.
+func GetServer(newProcessor ProcessorFactory, opts ...Option) *Server {
+	return &Server{
+		newProcessor: newProcessor,
+		opts:         opts,
+		conns:        map[net.Conn]struct{}{},
+	}
+}
+
+// ListenAndServe listens on the Unix socket at socketPath, removing a stale
+// socket file left behind by a previous run, and serves connections until
+// Close is called.
+func (s *Server) ListenAndServe(socketPath string) error {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove existing socket")
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen on socket")
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener until Close is called. It always
+// returns a non-nil error; after Close it returns net.ErrClosed.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return errors.Wrap(err, "failed to accept connection")
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return net.ErrClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	k := &kclProcess{
+		recordProcessor: s.newProcessor(),
+		logger:          defaultLogger,
+		reader:          bufio.NewReader(conn),
+		writer:          bufio.NewWriter(conn),
+	}
+	for _, opt := range s.opts {
+		opt(k)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			k.logger.Printf("Record processor for shard %s panicked: %v\n%s", k.shardID, r, debug.Stack())
+		}
+
+		conn.Close()
+
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	// Run returns nil when the shim closes its side of the connection, which
+	// happens when the MultiLangDaemon closes the shim's STDIN.
+	if err := k.Run(); err != nil {
+		k.logger.Printf("Record processor for shard %s stopped: %+v", k.shardID, err)
+	}
+}
+
+// Close stops accepting connections, closes every open connection and waits
+// for their record processors to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if err != nil {
+		return errors.Wrap(err, "failed to close listener")
+	}
+	return nil
+}
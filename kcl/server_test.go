@@ -0,0 +1,158 @@
+package kcl
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/shim"
+)
+
+type panickingProcessor struct {
+	mockProcessor
+}
+
+func (p *panickingProcessor) ProcessRecords(input *ProcessRecordsInput) {
+	panic("some panic")
+}
+
+func startTestServer(t *testing.T, newProcessor ProcessorFactory) (*Server, string) {
+	socketPath := filepath.Join(t.TempDir(), "kcl.sock")
+	server := GetServer(newProcessor)
+
+	served := make(chan error)
+	go func() {
+		served <- server.ListenAndServe(socketPath)
+	}()
+
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; err != net.ErrClosed {
+			t.Errorf("expected ListenAndServe to return net.ErrClosed but got %+v", err)
+		}
+	})
+
+	return server, socketPath
+}
+
+func runShim(t *testing.T, socketPath, input string) string {
+	output := &bytes.Buffer{}
+	err := shim.Run(socketPath, 5*time.Second, strings.NewReader(input), output)
+	if err != nil {
+		t.Errorf("unexpected error: %+v", err)
+	}
+	return output.String()
+}
+
+func TestServer_ServesEachShardWithItsOwnProcessor(t *testing.T) {
+	var mu sync.Mutex
+	processors := []*mockProcessor{}
+	_, socketPath := startTestServer(t, func() RecordProcessor {
+		mu.Lock()
+		defer mu.Unlock()
+
+		p := &mockProcessor{}
+		processors = append(processors, p)
+		return p
+	})
+
+	var wg sync.WaitGroup
+	outputs := make([]string, 2)
+	for i, shardID := range []string{"shard-0", "shard-1"} {
+		wg.Add(1)
+		go func(i int, shardID string) {
+			defer wg.Done()
+			input := `{"action": "initialize", "shardId": "` + shardID + `"}` + "\n" +
+				`{"action": "shutdownRequested"}` + "\n"
+			outputs[i] = runShim(t, socketPath, input)
+		}(i, shardID)
+	}
+	wg.Wait()
+
+	expectedOutput := `
+{"action":"status","responseFor":"initialize"}
+
+{"action":"status","responseFor":"shutdownRequested"}
+`
+	for _, output := range outputs {
+		if output != expectedOutput {
+			t.Errorf("expected the server to write '%s', but instead it wrote '%s'", expectedOutput, output)
+		}
+	}
+
+	if len(processors) != 2 {
+		t.Fatalf("expected a processor per shard but got %d", len(processors))
+	}
+
+	shardIDs := map[string]bool{}
+	for _, p := range processors {
+		shardIDs[p.initializeCall.ShardID] = true
+		if p.shutdownRequestedCall == nil {
+			t.Errorf("expected shutdownRequested to have been called, but it was not")
+		}
+	}
+	if !shardIDs["shard-0"] || !shardIDs["shard-1"] {
+		t.Errorf("expected both shards to be initialized but got %v", shardIDs)
+	}
+}
+
+func TestServer_PanicOnlyEndsItsConnection(t *testing.T) {
+	calls := 0
+	_, socketPath := startTestServer(t, func() RecordProcessor {
+		calls++
+		if calls == 1 {
+			return &panickingProcessor{}
+		}
+		return &mockProcessor{}
+	})
+
+	output := runShim(t, socketPath, `{"action": "processRecords", "records": []}`+"\n")
+	if output != "" {
+		t.Errorf("expected no status from the panicking processor but got '%s'", output)
+	}
+
+	// The server keeps serving other shards.
+	output = runShim(t, socketPath, `{"action": "shutdownRequested"}`+"\n")
+	expectedOutput := "\n" + `{"action":"status","responseFor":"shutdownRequested"}` + "\n"
+	if output != expectedOutput {
+		t.Errorf("expected the server to write '%s', but instead it wrote '%s'", expectedOutput, output)
+	}
+}
+
+func TestServer_CloseEndsOpenConnections(t *testing.T) {
+	server, socketPath := startTestServer(t, func() RecordProcessor {
+		return &mockProcessor{}
+	})
+
+	// The shim's STDIN stays open, so only closing the server ends it.
+	stdin, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+
+	finished := make(chan error)
+	go func() {
+		finished <- shim.Run(socketPath, 5*time.Second, stdin, io.Discard)
+	}()
+
+	// Wait for the connection to be accepted.
+	for {
+		server.mu.Lock()
+		n := len(server.conns)
+		server.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server.Close()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the shim to exit after the server closed")
+	}
+}
@@ -0,0 +1,65 @@
+package properties
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Expand replaces ${NAME} and ${NAME:-default} in every value with the value
+// lookup returns for NAME. The default is used when NAME is unset or empty,
+// and it is an error if there is no default. $${ is written as a literal ${.
+func (p *Properties) Expand(lookup func(name string) (string, bool)) error {
+	for _, l := range p.lines {
+		if l.entry == nil || !strings.Contains(l.entry.Value, "${") {
+			continue
+		}
+
+		value, err := expand(l.entry.Value, lookup)
+		if err != nil {
+			if l.entry.Line != 0 {
+				return errors.Wrapf(err, "line %d: %s", l.entry.Line, l.entry.Key)
+			}
+			return errors.Wrap(err, l.entry.Key)
+		}
+		l.entry.Value = value
+	}
+	return nil
+}
+
+func expand(s string, lookup func(name string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", errors.Errorf("unterminated ${ in '%s'", s[i:])
+		}
+		name, def, hasDefault := strings.Cut(s[i+2:i+end], ":-")
+		if name == "" {
+			return "", errors.New("empty variable name in ${}")
+		}
+
+		value, ok := lookup(name)
+		if !ok || value == "" {
+			if !hasDefault {
+				return "", errors.Errorf("%s is not set and has no default", name)
+			}
+			value = def
+		}
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
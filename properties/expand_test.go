@@ -0,0 +1,48 @@
+package properties
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	env := map[string]string{"STAGE": "prod", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := map[string]string{
+		"stream-${STAGE}":             "stream-prod",
+		"${REGION:-us-east-1}":        "us-east-1",
+		"${EMPTY:-fallback}":          "fallback",
+		"${STAGE}-${STAGE:-x}":        "prod-prod",
+		"literal $${STAGE} and $HOME": "literal ${STAGE} and $HOME",
+		"no variables":                "no variables",
+	}
+	for value, expected := range tests {
+		p := New()
+		p.Set("key", value)
+		if err := p.Expand(lookup); err != nil {
+			t.Errorf("unexpected error for '%s': %+v", value, err)
+			continue
+		}
+		if actual, _ := p.Get("key"); actual != expected {
+			t.Errorf("expected '%s' to expand to '%s' but got '%s'", value, expected, actual)
+		}
+	}
+}
+
+func TestExpand_Errors(t *testing.T) {
+	lookup := func(string) (string, bool) { return "", false }
+
+	for _, input := range []string{"a = ${MISSING}\n", "a = ${UNTERMINATED\n", "a = ${}\n"} {
+		p, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Expand(lookup); err == nil || !strings.HasPrefix(err.Error(), "line 1: a: ") {
+			t.Errorf("expected an error on line 1 for '%s' but got %+v", strings.TrimSpace(input), err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package properties
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is a single key/value pair from a properties file.
+type Entry struct {
+	Key   string
+	Value string
+
+	// Line is the line number the entry starts on, or 0 if the entry was not
+	// read from a file.
+	Line int
+}
+
+// line is either a comment/blank line, kept verbatim so that files can be
+// rewritten without losing their documentation, or an entry.
+type line struct {
+	raw   string
+	entry *Entry
+}
+
+// Properties holds the contents of a Java style properties file like the ones
+// read by the MultiLangDaemon. The order of entries and any comments are
+// preserved.
+type Properties struct {
+	lines []line
+}
+
+// New returns an empty Properties.
+func New() *Properties {
+	return &Properties{}
+}
+
+// Load reads and parses the properties file at path.
+func Load(path string) (*Properties, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open properties file")
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse reads properties from r. It supports the subset of the
+// java.util.Properties format used by KCL properties files: '#' and '!'
+// comments, '=', ':' or whitespace separators and trailing backslash line
+// continuations.
+func Parse(r io.Reader) (*Properties, error) {
+	p := New()
+	scanner := bufio.NewScanner(r)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		raw := scanner.Text()
+		trimmed := strings.TrimSpace(raw)
+
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			p.lines = append(p.lines, line{raw: raw})
+			continue
+		}
+
+		startLine := lineNumber
+		logical := trimmed
+		for endsWithContinuation(logical) && scanner.Scan() {
+			lineNumber++
+			logical = logical[:len(logical)-1] + strings.TrimSpace(scanner.Text())
+		}
+
+		key, value := splitEntry(logical)
+		p.lines = append(p.lines, line{entry: &Entry{
+			Key:   key,
+			Value: value,
+			Line:  startLine,
+		}})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read properties")
+	}
+
+	return p, nil
+}
+
+// endsWithContinuation reports whether s ends in an odd number of
+// backslashes, i.e. an unescaped line continuation.
+func endsWithContinuation(s string) bool {
+	count := 0
+	for i := len(s) - 1; i >= 0 && s[i] == '\\'; i-- {
+		count++
+	}
+	return count%2 == 1
+}
+
+func splitEntry(s string) (string, string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case '=', ':':
+			return unescape(strings.TrimSpace(s[:i])), unescape(strings.TrimSpace(s[i+1:]))
+		case ' ', '\t', '\f':
+			key := s[:i]
+			rest := strings.TrimSpace(s[i:])
+			if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+				rest = strings.TrimSpace(rest[1:])
+			}
+			return unescape(key), unescape(rest)
+		}
+	}
+	return unescape(s), ""
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Get returns the value of key. If the key appears more than once the last
+// value wins, matching java.util.Properties.
+func (p *Properties) Get(key string) (string, bool) {
+	for i := len(p.lines) - 1; i >= 0; i-- {
+		if e := p.lines[i].entry; e != nil && e.Key == key {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetDefault returns the value of key or def if the key is not set.
+func (p *Properties) GetDefault(key, def string) string {
+	if v, ok := p.Get(key); ok {
+		return v
+	}
+	return def
+}
+
+// Entries returns every entry in file order, including duplicate keys.
+func (p *Properties) Entries() []Entry {
+	entries := []Entry{}
+	for _, l := range p.lines {
+		if l.entry != nil {
+			entries = append(entries, *l.entry)
+		}
+	}
+	return entries
+}
+
+// Keys returns the distinct keys in the order they first appear.
+func (p *Properties) Keys() []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, l := range p.lines {
+		if l.entry != nil && !seen[l.entry.Key] {
+			seen[l.entry.Key] = true
+			keys = append(keys, l.entry.Key)
+		}
+	}
+	return keys
+}
+
+// Set updates the last entry for key in place or appends a new entry if the
+// key is not present.
+func (p *Properties) Set(key, value string) {
+	for i := len(p.lines) - 1; i >= 0; i-- {
+		if e := p.lines[i].entry; e != nil && e.Key == key {
+			e.Value = value
+			return
+		}
+	}
+	p.lines = append(p.lines, line{entry: &Entry{Key: key, Value: value}})
+}
+
+// Merge sets every key of other to its last value in other, replacing the
+// value of keys already present in place and appending the others in the
+// order they appear in other.
+func (p *Properties) Merge(other *Properties) {
+	for _, key := range other.Keys() {
+		value, _ := other.Get(key)
+		p.Set(key, value)
+	}
+}
+
+// Rename changes the key of every entry for oldKey to newKey, keeping the
+// entries in place.
+func (p *Properties) Rename(oldKey, newKey string) {
+	for _, l := range p.lines {
+		if l.entry != nil && l.entry.Key == oldKey {
+			l.entry.Key = newKey
+		}
+	}
+}
+
+// Delete removes every entry for key.
+func (p *Properties) Delete(key string) {
+	lines := p.lines[:0]
+	for _, l := range p.lines {
+		if l.entry != nil && l.entry.Key == key {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	p.lines = lines
+}
+
+// AddComment appends a comment line. Each line of comment is prefixed with
+// "# ".
+func (p *Properties) AddComment(comment string) {
+	for _, c := range strings.Split(comment, "\n") {
+		p.lines = append(p.lines, line{raw: strings.TrimRight("# "+c, " ")})
+	}
+}
+
+// AddBlankLine appends an empty line.
+func (p *Properties) AddBlankLine() {
+	p.lines = append(p.lines, line{})
+}
+
+// WriteTo writes the properties, including comments, to w.
+func (p *Properties) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, l := range p.lines {
+		text := l.raw
+		if l.entry != nil {
+			text = fmt.Sprintf("%s = %s", escapeKey(l.entry.Key), escapeValue(l.entry.Value))
+		}
+
+		n, err := io.WriteString(w, text+"\n")
+		written += int64(n)
+		if err != nil {
+			return written, errors.Wrap(err, "failed to write properties")
+		}
+	}
+	return written, nil
+}
+
+// String returns the properties in file format.
+func (p *Properties) String() string {
+	var b strings.Builder
+	p.WriteTo(&b)
+	return b.String()
+}
+
+var keyEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	" ", `\ `,
+	"=", `\=`,
+	":", `\:`,
+	"\t", `\t`,
+	"\n", `\n`,
+)
+
+var valueEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+func escapeKey(key string) string {
+	return keyEscaper.Replace(key)
+}
+
+func escapeValue(value string) string {
+	return valueEscaper.Replace(value)
+}
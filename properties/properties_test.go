@@ -0,0 +1,118 @@
+package properties
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `# a comment
+! another comment
+streamName = some_stream
+applicationName:some_app
+regionName us-east-1
+  executableName =  ./bin/processor --flag \
+      value
+emptyValue =
+escaped\ key = a\tb
+`
+
+	p, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"streamName":      "some_stream",
+		"applicationName": "some_app",
+		"regionName":      "us-east-1",
+		"executableName":  "./bin/processor --flag value",
+		"emptyValue":      "",
+		"escaped key":     "a\tb",
+	}
+	for key, value := range expected {
+		actual, ok := p.Get(key)
+		if !ok {
+			t.Errorf("expected key '%s' to be present", key)
+		}
+		if actual != value {
+			t.Errorf("expected '%s' to be '%s' but got '%s'", key, value, actual)
+		}
+	}
+
+	entries := p.Entries()
+	if entries[3].Line != 6 {
+		t.Errorf("expected executableName to start on line 6 but got %d", entries[3].Line)
+	}
+}
+
+func TestGet_LastValueWins(t *testing.T) {
+	p, err := Parse(strings.NewReader("a = 1\na = 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := p.Get("a"); v != "2" {
+		t.Errorf("expected '2' but got '%s'", v)
+	}
+	if len(p.Keys()) != 1 {
+		t.Errorf("expected 1 distinct key but got %d", len(p.Keys()))
+	}
+}
+
+func TestWriteTo_PreservesComments(t *testing.T) {
+	input := "# comment\n\nstreamName = some_stream\nregionName = us-east-1\n"
+	p, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Set("regionName", "us-west-2")
+	p.Set("workerId", "worker 1")
+	p.Delete("streamName")
+
+	expected := "# comment\n\nregionName = us-west-2\nworkerId = worker 1\n"
+	if p.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, p.String())
+	}
+
+	reparsed, err := Parse(strings.NewReader(p.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := reparsed.Get("workerId"); v != "worker 1" {
+		t.Errorf("expected round trip of 'worker 1' but got '%s'", v)
+	}
+}
+
+func TestRename_KeepsEntriesInPlace(t *testing.T) {
+	p, err := Parse(strings.NewReader("a = 1\nb = 2\na = 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Rename("a", "c")
+
+	expected := "c = 1\nb = 2\nc = 3\n"
+	if p.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, p.String())
+	}
+}
+
+func TestMerge_OverridesInPlace(t *testing.T) {
+	base, err := Parse(strings.NewReader("# base\na = 1\nb = 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := Parse(strings.NewReader("c = 3\nb = 4\nb = 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base.Merge(overlay)
+
+	expected := "# base\na = 1\nb = 5\nc = 3\n"
+	if base.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, base.String())
+	}
+}
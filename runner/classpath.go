@@ -0,0 +1,206 @@
+package runner
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/goguardian/goguardian-go-kcl/jars"
+	"github.com/pkg/errors"
+)
+
+// jarNamePattern splits a jar's file name into its artifact and version, e.g.
+// amazon-kinesis-client-3.0.3.jar.
+var jarNamePattern = regexp.MustCompile(`^(.+?)-(\d[^/]*)\.jar$`)
+
+// JarPaths returns the absolute paths of the jars to put on the classpath. It
+// fails if the manifest of the jar folder shows several versions of an
+// artifact, only warning when the folder has no manifest, or, with jar
+// verification, if the folder does not match its manifest. With a jar
+// download the jars are first downloaded if needed.
+func (r *Runner) JarPaths() ([]string, error) {
+	if r.jarDownloader != nil && !r.jarsVerified {
+		if err := r.jarDownloader.Ensure(r.pathToJarFolder); err != nil {
+			return nil, errors.Wrap(err, "failed to download jars")
+		}
+		r.jarsVerified = true
+	}
+
+	if r.verifyJars && !r.jarsVerified {
+		manifest, err := jars.ReadManifest(r.pathToJarFolder)
+		if err != nil {
+			return nil, err
+		}
+		if err = manifest.Verify(r.pathToJarFolder); err != nil {
+			return nil, err
+		}
+		r.jarsVerified = true
+	}
+
+	return FindJars(r.pathToJarFolder, r.recursiveJarFolder, r.logger)
+}
+
+// FindJars returns the absolute paths of the jars in jarFolder, and in its
+// subfolders too if recursive is set. It fails if the manifest of the folder
+// shows several versions of an artifact. Without a manifest such jars are
+// only reported to logger.
+func FindJars(jarFolder string, recursive bool, logger *log.Logger) ([]string, error) {
+	jarPaths, err := getJarPaths(jarFolder, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := jars.ReadManifest(jarFolder)
+	if os.IsNotExist(errors.Cause(err)) {
+		// Artifacts of different groups can share a name, so without a
+		// manifest jars that look like several versions of an artifact may
+		// well be different artifacts.
+		if duplicates := duplicateJars(jarPaths, nil); len(duplicates) > 0 {
+			logger.Printf("Warning: the jar folder may have several versions of %s. Without the %s written by jar-download they can not be told apart from artifacts of different groups.",
+				strings.Join(duplicates, "; "), jars.ManifestFile)
+		}
+		return jarPaths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if duplicates := duplicateJars(jarPaths, manifest); len(duplicates) > 0 {
+		return nil, errors.Errorf("found several versions of %s in the jar folder", strings.Join(duplicates, "; "))
+	}
+	return jarPaths, nil
+}
+
+// JarFolder returns the folder the jars are put on the classpath from.
+func (r *Runner) JarFolder() string {
+	return r.pathToJarFolder
+}
+
+// Classpath returns the classpath the daemon is started with.
+func (r *Runner) Classpath() (string, error) {
+	jarPaths, err := r.JarPaths()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(jarPaths, string(os.PathListSeparator)), nil
+}
+
+// KCLVersions returns the versions of the KCL jars on the classpath by
+// artifact, e.g. amazon-kinesis-client-multilang.
+func (r *Runner) KCLVersions() (map[string]string, error) {
+	jarPaths, err := r.JarPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	return KCLJarVersions(jarPaths), nil
+}
+
+// KCLJarVersions returns the versions of the KCL jars among jarPaths by
+// artifact.
+func KCLJarVersions(jarPaths []string) map[string]string {
+	versions := map[string]string{}
+	for _, path := range jarPaths {
+		m := jarNamePattern.FindStringSubmatch(filepath.Base(path))
+		if m != nil && strings.HasPrefix(m[1], "amazon-kinesis-client") {
+			versions[m[1]] = m[2]
+		}
+	}
+	return versions
+}
+
+// getJarPaths returns the absolute paths of the .jar files in jarFolder, and
+// in its subfolders if recursive is set, in lexical order.
+func getJarPaths(jarFolder string, recursive bool) ([]string, error) {
+	root, err := filepath.Abs(jarFolder)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get jar path")
+	}
+
+	jarPaths := []string{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.EqualFold(filepath.Ext(path), ".jar") {
+			jarPaths = append(jarPaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read jar folder")
+	}
+
+	if len(jarPaths) == 0 {
+		return nil, errors.Errorf("no jars in jar folder %s", jarFolder)
+	}
+
+	sort.Strings(jarPaths)
+	return jarPaths, nil
+}
+
+// duplicateJars describes the jars for several versions of the same artifact,
+// which usually means that stale jars were left behind by an upgrade. Jars
+// listed by the manifest are told apart by their group and artifact. Other
+// jars, and all of them without a manifest, only by the artifact in their
+// file name, and are also counted for every listed artifact of that name.
+func duplicateJars(jarPaths []string, manifest *jars.Manifest) []string {
+	listed := map[string]jars.Package{}
+	if manifest != nil {
+		for _, pkg := range manifest.Packages {
+			listed[pkg.File] = pkg
+		}
+	}
+
+	byArtifact := map[string][]string{}
+	unlisted := map[string][]string{}
+	for _, path := range jarPaths {
+		name := filepath.Base(path)
+		if pkg, ok := listed[name]; ok {
+			key := pkg.Group + ":" + pkg.Artifact
+			byArtifact[key] = append(byArtifact[key], name)
+			continue
+		}
+
+		m := jarNamePattern.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		unlisted[m[1]] = append(unlisted[m[1]], name)
+	}
+
+	for artifact, names := range unlisted {
+		matched := false
+		for _, pkg := range listed {
+			if pkg.Artifact == artifact {
+				key := pkg.Group + ":" + pkg.Artifact
+				byArtifact[key] = append(byArtifact[key], names...)
+				matched = true
+			}
+		}
+		if !matched {
+			byArtifact[artifact] = append(byArtifact[artifact], names...)
+		}
+	}
+
+	duplicates := []string{}
+	for artifact, names := range byArtifact {
+		if len(names) > 1 {
+			sort.Strings(names)
+			duplicates = append(duplicates, artifact+" ("+strings.Join(names, ", ")+")")
+		}
+	}
+	sort.Strings(duplicates)
+	return duplicates
+}
@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goguardian/goguardian-go-kcl/jars"
+)
+
+func writeJarFolder(t *testing.T, files ...string) string {
+	dir := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestGetJarPaths_OnlyIncludesJars(t *testing.T) {
+	dir := writeJarFolder(t, "b-1.0.jar", "a-2.0.JAR", "README.md", "extra/c-1.0.jar")
+
+	tests := map[bool][]string{
+		false: {"a-2.0.JAR", "b-1.0.jar"},
+		true:  {"a-2.0.JAR", "b-1.0.jar", "extra/c-1.0.jar"},
+	}
+	for recursive, expected := range tests {
+		jarPaths, err := getJarPaths(dir, recursive)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		names := []string{}
+		for _, path := range jarPaths {
+			rel, _ := filepath.Rel(dir, path)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		if strings.Join(names, " ") != strings.Join(expected, " ") {
+			t.Errorf("expected %v with recursive %t but got %v", expected, recursive, names)
+		}
+	}
+
+	if _, err := getJarPaths(writeJarFolder(t, "notes.txt"), false); err == nil {
+		t.Error("expected an error for a folder without jars, but got nil")
+	}
+}
+
+func TestDuplicateJars(t *testing.T) {
+	paths := []string{
+		"/jar/amazon-kinesis-client-2.6.0.jar",
+		"/jar/amazon-kinesis-client-3.0.3.jar",
+		"/jar/amazon-kinesis-client-multilang-3.0.3.jar",
+		"/jar/listenablefuture-9999.0-empty-to-avoid-conflict-with-guava.jar",
+		"/jar/guava-32.1.1-jre.jar",
+	}
+	manifest := &jars.Manifest{Packages: []jars.Package{
+		{Group: "software.amazon.kinesis", Artifact: "amazon-kinesis-client", Version: "3.0.3", File: "amazon-kinesis-client-3.0.3.jar"},
+		{Group: "software.amazon.kinesis", Artifact: "amazon-kinesis-client-multilang", Version: "3.0.3", File: "amazon-kinesis-client-multilang-3.0.3.jar"},
+		{Group: "com.google.guava", Artifact: "listenablefuture", Version: "9999.0-empty-to-avoid-conflict-with-guava", File: "listenablefuture-9999.0-empty-to-avoid-conflict-with-guava.jar"},
+		{Group: "com.google.guava", Artifact: "guava", Version: "32.1.1-jre", File: "guava-32.1.1-jre.jar"},
+	}}
+
+	tests := map[string]struct {
+		manifest *jars.Manifest
+		expected []string
+	}{
+		"without a manifest": {
+			expected: []string{"amazon-kinesis-client (amazon-kinesis-client-2.6.0.jar, amazon-kinesis-client-3.0.3.jar)"},
+		},
+		"with a manifest": {
+			manifest: manifest,
+			expected: []string{"software.amazon.kinesis:amazon-kinesis-client (amazon-kinesis-client-2.6.0.jar, amazon-kinesis-client-3.0.3.jar)"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			duplicates := duplicateJars(paths, test.manifest)
+			if !reflect.DeepEqual(duplicates, test.expected) {
+				t.Errorf("expected %v but got %v", test.expected, duplicates)
+			}
+		})
+	}
+}
+
+func TestDuplicateJars_KCLPackages(t *testing.T) {
+	paths := []string{}
+	manifest := &jars.Manifest{}
+	for _, pkg := range jars.KCLPackages {
+		paths = append(paths, "/jar/"+pkg.Name())
+		manifest.Packages = append(manifest.Packages, jars.Package{
+			Group:    pkg.Group,
+			Artifact: pkg.Artifact,
+			Version:  pkg.Version,
+			File:     pkg.Name(),
+		})
+	}
+
+	if duplicates := duplicateJars(paths, manifest); len(duplicates) > 0 {
+		t.Errorf("expected no duplicates in the KCL packages but got %v", duplicates)
+	}
+}
+
+func TestJarPaths_WarnsWithoutManifest(t *testing.T) {
+	dir := writeJarFolder(t, "annotations-26.0.1.jar", "annotations-2.25.64.jar")
+	logs := &bytes.Buffer{}
+	r := &Runner{logger: log.New(logs, "", 0), pathToJarFolder: dir}
+
+	jarPaths, err := r.JarPaths()
+	if err != nil {
+		t.Fatalf("expected no error without a manifest but got %+v", err)
+	}
+	if len(jarPaths) != 2 {
+		t.Errorf("expected 2 jars but got %v", jarPaths)
+	}
+	if !strings.Contains(logs.String(), "annotations (annotations-2.25.64.jar, annotations-26.0.1.jar)") {
+		t.Errorf("expected a warning about the annotations jars but got %q", logs.String())
+	}
+}
+
+func TestJarPaths_VerifiesManifest(t *testing.T) {
+	dir := writeJarFolder(t, "a-1.0.jar")
+	checksum, err := jars.FileSHA256(filepath.Join(dir, "a-1.0.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &jars.Manifest{Packages: []jars.Package{{Artifact: "a", Version: "1.0", File: "a-1.0.jar", SHA256: checksum}}}
+	if err = manifest.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{pathToJarFolder: dir, verifyJars: true}
+	if _, err = r.JarPaths(); err != nil {
+		t.Errorf("unexpected error: %+v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "a-1.0.jar"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r = &Runner{pathToJarFolder: dir, verifyJars: true}
+	if _, err = r.JarPaths(); err == nil || !strings.Contains(err.Error(), "checksum mismatch for a-1.0.jar") {
+		t.Errorf("expected a checksum error but got %+v", err)
+	}
+}
+
+func TestJarPaths_DownloadsJars(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Write([]byte(req.URL.Path))
+	}))
+	defer server.Close()
+
+	d := jars.GetDownloader(
+		jars.WithMavenBaseURL(server.URL+"/"),
+		jars.WithPackages([]jars.MavenPackage{{Group: "com.example", Artifact: "a", Version: "1.0"}}),
+		jars.WithLogger(log.New(io.Discard, "", 0)),
+	)
+	dir := filepath.Join(t.TempDir(), "jars")
+
+	r := &Runner{pathToJarFolder: dir, jarDownloader: d}
+	jarPaths, err := r.JarPaths()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(jarPaths) != 1 || filepath.Base(jarPaths[0]) != "a-1.0.jar" {
+		t.Errorf("expected the downloaded jar on the classpath but got %v", jarPaths)
+	}
+
+	// A folder that is already complete is only verified.
+	r = &Runner{pathToJarFolder: dir, jarDownloader: d}
+	if _, err = r.JarPaths(); err != nil {
+		t.Errorf("unexpected error: %+v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected the jar to be downloaded once but it was downloaded %d times", requests)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/jars"
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/goguardian/goguardian-go-kcl/runner"
+	"github.com/pkg/errors"
+)
+
+const (
+	// command line flag names
+	propertiesKey = "properties"
+	javaKey       = "java"
+	jarKey        = "jar"
+	configKey     = "config"
+)
+
+// legacyEnvNames are the environment variables read for some flags before
+// every flag had a RUNNER_ variable. They are still read after the RUNNER_
+// ones.
+var legacyEnvNames = map[string]string{
+	javaKey:       "JAVA",
+	jarKey:        "JAR",
+	propertiesKey: "PROPERTIES",
+}
+
+// repeatableFlags can be given several times. Their environment variables are
+// comma separated lists and they can be repeated in the config file.
+var repeatableFlags = map[string]bool{
+	"D":           true,
+	"java-logger": true,
+	"overlay":     true,
+}
+
+// envName returns the environment variable of a flag, e.g. RUNNER_MAX_HEAP
+// for -max-heap.
+func envName(flagName string) string {
+	return "RUNNER_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// newFlagSet returns the flag set of a subcommand. The usage line lists the
+// arguments after the command name.
+func newFlagSet(name, usageLine string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n", os.Args[0], name, usageLine)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs. Flags that are not given on the command line
+// are read from their environment variable and then from the config file given
+// with -config, a properties file whose keys are flag names. Unknown keys in
+// the config file are an error.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String(configKey, "", "The path to a properties file with default values for the flags, e.g. 'jar = ./jar'")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return errors.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	if !given[configKey] {
+		*configPath = os.Getenv(envName(configKey))
+	}
+
+	config := properties.New()
+	if *configPath != "" {
+		var err error
+		config, err = properties.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		for _, key := range config.Keys() {
+			if key == configKey || (fs.Lookup(key) == nil && !isRunFlag(key)) {
+				return errors.Errorf("unknown key %s in config file %s", key, *configPath)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == configKey {
+			return
+		}
+
+		for _, value := range defaultValues(f.Name, config) {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = errors.Errorf("invalid %s '%s': %s", f.Name, value, setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// isRunFlag reports whether name is one of the runFlags, which a config file
+// shared by several subcommands may set even for those that do not use them.
+func isRunFlag(name string) bool {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	(&runFlags{}).register(fs)
+	return fs.Lookup(name) != nil
+}
+
+// defaultValues returns the values of a flag that was not given on the command
+// line.
+func defaultValues(flagName string, config *properties.Properties) []string {
+	value := os.Getenv(envName(flagName))
+	if value == "" {
+		value = os.Getenv(legacyEnvNames[flagName])
+	}
+	if value != "" {
+		if repeatableFlags[flagName] {
+			return strings.Split(value, ",")
+		}
+		return []string{value}
+	}
+
+	values := []string{}
+	for _, e := range config.Entries() {
+		if e.Key == flagName {
+			values = append(values, e.Value)
+		}
+	}
+	if len(values) > 1 && !repeatableFlags[flagName] {
+		// The last value wins, like for the daemon's properties.
+		values = values[len(values)-1:]
+	}
+	return values
+}
+
+// runFlags are the flags that configure the runner. Every subcommand that
+// needs a runner accepts them.
+type runFlags struct {
+	pathToJavaBinary     string
+	pathToPropertiesFile string
+	overlays             []string
+	envOverrides         bool
+	pathToJarFolder      string
+	recursiveJarFolder   bool
+	verifyJars           bool
+	downloadJars         bool
+	mavenBaseURL         string
+
+	shutdownTimeout   time.Duration
+	maxRestarts       int
+	restartBackoff    time.Duration
+	maxRestartBackoff time.Duration
+	orphanGracePeriod time.Duration
+	healthAddr        string
+	readinessWindow   time.Duration
+	stallWindow       time.Duration
+	restartOnStall    bool
+	diagnosticsDir    string
+	diagnosticsKeep   int
+	diagnosticsBytes  int64
+	crashDiagnostics  bool
+	crash             runner.CrashDiagnosticsConfig
+
+	jvmOptions runner.JVMOptions
+	gc         string
+	logConfig  runner.LogConfig
+	logFormat  string
+
+	credentialsMode string
+}
+
+func (f *runFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.pathToJavaBinary, javaKey, "", "The path to the java executable e.g. <path>/jdk/bin/java. If it is not given java is looked up in JAVA_HOME, PATH and common install locations")
+	fs.StringVar(&f.pathToPropertiesFile, propertiesKey, "", "The path to the properties file. If it is not given the properties are read from KCL_* environment variables, e.g. KCL_STREAM_NAME")
+	fs.Func("overlay", "The path to a properties file layered over the properties, can be repeated", func(s string) error {
+		f.overlays = append(f.overlays, s)
+		return nil
+	})
+	fs.BoolVar(&f.envOverrides, "env-overrides", false, "Let KCL_* environment variables, e.g. KCL_MAX_RECORDS, override the properties")
+	fs.StringVar(&f.pathToJarFolder, jarKey, "", "The path to the jar dependencies")
+	fs.BoolVar(&f.recursiveJarFolder, "recursive-jars", false, "Also put the jars in subfolders of the jar folder on the classpath")
+	fs.BoolVar(&f.verifyJars, "verify-jars", false, "Check the jar folder against the manifest written by jar-download before starting the java daemon")
+	fs.BoolVar(&f.downloadJars, "download-jars", false, "Download the jar dependencies before starting the java daemon if they are missing or do not match the manifest, into the user's cache directory unless -jar is given")
+	fs.StringVar(&f.mavenBaseURL, "maven-base-url", jars.DefaultMavenBaseURL, "The Maven repository the jar dependencies are downloaded from")
+
+	fs.DurationVar(&f.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long the java daemon has to shut down after SIGTERM, not counting the graceful lease handoff timeout")
+	fs.IntVar(&f.maxRestarts, "max-restarts", 5, "How many consecutive crashes of the java daemon are restarted before giving up")
+	fs.DurationVar(&f.restartBackoff, "restart-backoff", time.Second, "The delay before restarting a crashed java daemon, doubled after each consecutive crash")
+	fs.DurationVar(&f.maxRestartBackoff, "max-restart-backoff", time.Minute, "The maximum delay before restarting a crashed java daemon")
+
+	fs.DurationVar(&f.orphanGracePeriod, "orphan-grace-period", 5*time.Second, "How long record processors left running after the java daemon exited have to exit after SIGTERM before they are killed")
+	fs.StringVar(&f.healthAddr, "health-addr", "", "The address to serve /healthz, /readyz and /status on, e.g. :8080")
+	fs.DurationVar(&f.readinessWindow, "readiness-window", 5*time.Minute, "How long the java daemon stays ready after it last acquired a lease or synced shards when it holds no lease")
+	fs.DurationVar(&f.stallWindow, "stall-window", 0, "How long the java daemon may log nothing while no record processor checkpoints before a thread dump and goroutine dumps are written, 0 disables the watchdog")
+	fs.BoolVar(&f.restartOnStall, "restart-on-stall", false, "Restart the java daemon once it stalled and the dumps are written")
+	fs.StringVar(&f.diagnosticsDir, "diagnostics-dir", "", "The folder thread dumps, goroutine dumps and crash files are written to, not to be shared with other runners, goguardian-go-kcl-diagnostics-<pid> in the temporary directory by default")
+	fs.IntVar(&f.diagnosticsKeep, "diagnostics-keep", 5, "How many thread dumps, goroutine dumps, JVM error files and heap dumps of each kind are kept, at least 1")
+	fs.Int64Var(&f.diagnosticsBytes, "diagnostics-max-bytes", 0, "How many bytes the kept diagnostics files may take, apart from the newest one, 0 for no limit")
+	fs.BoolVar(&f.crashDiagnostics, "crash-diagnostics", false, "Have the JVM write its error file to the diagnostics folder and log the cause of crashes")
+	fs.BoolVar(&f.crash.HeapDumpOnOutOfMemory, "heap-dump-on-oom", false, "Write a heap dump when the JVM runs out of heap, implies -crash-diagnostics")
+	fs.BoolVar(&f.crash.ExitOnOutOfMemory, "exit-on-oom", false, "Exit the JVM on the first OutOfMemoryError so that it is restarted, implies -crash-diagnostics")
+	fs.StringVar(&f.crash.ErrorFileDir, "error-file-dir", "", "The folder the JVM writes its hs_err_pid<pid>.log error file to, the diagnostics folder by default, implies -crash-diagnostics")
+	fs.StringVar(&f.crash.HeapDumpDir, "heap-dump-dir", "", "The folder heap dumps are written to, the diagnostics folder by default, implies -crash-diagnostics")
+
+	f.jvmOptions.SystemProperties = map[string]string{}
+	fs.StringVar(&f.jvmOptions.MaxHeapSize, "max-heap", "", "The maximum heap size of the JVM, e.g. 2g")
+	fs.Float64Var(&f.jvmOptions.MaxRAMPercentage, "max-ram-percentage", 0, "The maximum heap size as a percentage of the memory available to the JVM, e.g. 75")
+	fs.StringVar(&f.gc, "gc", "", "The garbage collector of the JVM: G1, Parallel, Serial, Z or Shenandoah")
+	fs.Func("D", "A system property of the JVM as key=value, can be repeated", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value but got '%s'", s)
+		}
+		f.jvmOptions.SystemProperties[key] = value
+		return nil
+	})
+
+	f.logConfig.Levels = map[string]string{}
+	fs.StringVar(&f.logConfig.RootLevel, "java-log-level", "", "The root log level of the java daemon, e.g. WARN")
+	fs.Func("java-logger", "The log level of a logger of the java daemon as name=LEVEL, e.g. software.amazon.kinesis=DEBUG, can be repeated", func(s string) error {
+		name, level, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("expected name=LEVEL but got '%s'", s)
+		}
+		f.logConfig.Levels[name] = level
+		return nil
+	})
+	fs.StringVar(&f.credentialsMode, "credentials", "", "Resolve AWS credentials like Go services and hand them to the java daemon: env passes them as environment variables, endpoint serves them on a loopback endpoint and refreshes them before they expire")
+	fs.StringVar(&f.logFormat, "log-format", "plain", "How the output of the java daemon is logged: plain forwards it verbatim, text and json parse the daemon's log lines into structured log events")
+}
+
+// newRunner returns a runner configured by the flags. Call Close on it to
+// remove the files it generated.
+func (f *runFlags) newRunner() (*runner.Runner, error) {
+	f.jvmOptions.GarbageCollector = runner.GarbageCollector(f.gc)
+
+	opts := []runner.Option{
+		runner.WithPathToJavaBinary(f.pathToJavaBinary),
+		runner.WithPathToJarFolder(f.pathToJarFolder),
+		runner.WithRecursiveJarFolder(f.recursiveJarFolder),
+		runner.WithJarVerification(f.verifyJars),
+		runner.WithShutdownTimeout(f.shutdownTimeout),
+		runner.WithMaxRestarts(f.maxRestarts),
+		runner.WithRestartBackoff(f.restartBackoff, f.maxRestartBackoff),
+		runner.WithOrphanGracePeriod(f.orphanGracePeriod),
+		runner.WithHealthServer(f.healthAddr),
+		runner.WithReadinessWindow(f.readinessWindow),
+		runner.WithJVMOptions(&f.jvmOptions),
+		runner.WithPropertiesOverlays(f.overlays...),
+		runner.WithEnvOverrides(f.envOverrides),
+	}
+
+	if f.stallWindow > 0 {
+		opts = append(opts, runner.WithWatchdog(runner.WatchdogConfig{StallWindow: f.stallWindow, Restart: f.restartOnStall}))
+	}
+	if f.crashDiagnostics || f.crash != (runner.CrashDiagnosticsConfig{}) {
+		opts = append(opts, runner.WithCrashDiagnostics(f.crash))
+	}
+	if f.diagnosticsDir != "" {
+		opts = append(opts, runner.WithDiagnosticsDir(f.diagnosticsDir))
+	}
+	opts = append(opts, runner.WithDiagnosticsRetention(f.diagnosticsKeep, f.diagnosticsBytes))
+
+	if f.downloadJars {
+		opts = append(opts, runner.WithJarDownload(f.downloader()))
+	}
+
+	// The generated logging configuration uses the log line pattern that the
+	// structured log formats parse.
+	if f.logConfig.RootLevel != "" || len(f.logConfig.Levels) > 0 || f.logFormat != "plain" {
+		opts = append(opts, runner.WithLogConfig(&f.logConfig))
+	}
+
+	switch f.logFormat {
+	case "plain":
+	case "text", "json":
+		var handler slog.Handler = slog.NewTextHandler(os.Stdout, nil)
+		if f.logFormat == "json" {
+			handler = slog.NewJSONHandler(os.Stdout, nil)
+		}
+		opts = append(opts,
+			runner.WithStdoutLogHandler(runner.StreamLogConfig{Handler: handler, DefaultLevel: slog.LevelInfo}),
+			runner.WithStderrLogHandler(runner.StreamLogConfig{Handler: handler, DefaultLevel: slog.LevelError}),
+		)
+	default:
+		return nil, errors.Errorf("unknown log format '%s'", f.logFormat)
+	}
+
+	if f.credentialsMode != "" {
+		creds, region, err := runner.DefaultCredentials()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, runner.WithCredentials(creds, region, runner.CredentialsMode(f.credentialsMode)))
+	}
+
+	if f.pathToPropertiesFile != "" {
+		opts = append(opts, runner.WithPathToPropertiesFile(f.pathToPropertiesFile))
+	} else {
+		config, err := runner.ConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, runner.WithConfig(config))
+	}
+
+	return runner.GetRunner(opts...)
+}
+
+// downloader returns the downloader of the jar dependencies.
+func (f *runFlags) downloader(opts ...jars.Option) *jars.Downloader {
+	return jars.GetDownloader(append([]jars.Option{jars.WithMavenBaseURL(f.mavenBaseURL)}, opts...)...)
+}
+
+// javaPath returns the java binary the runner would use.
+func (f *runFlags) javaPath() (string, error) {
+	if f.pathToJavaBinary != "" {
+		return f.pathToJavaBinary, nil
+	}
+	return runner.FindJava()
+}
+
+// jarPaths returns the jar folder and the jars the runner would put on the
+// classpath, without needing java or the properties. Like the runner it
+// downloads or verifies the jars first when asked to. Warnings and downloads
+// are reported to logger.
+func (f *runFlags) jarPaths(logger *log.Logger) (string, []string, error) {
+	folder := f.pathToJarFolder
+	switch {
+	case f.downloadJars:
+		d := f.downloader(jars.WithLogger(logger))
+		if folder == "" {
+			var err error
+			if folder, err = d.CacheDir(); err != nil {
+				return "", nil, err
+			}
+		}
+		if err := d.Ensure(folder); err != nil {
+			return folder, nil, errors.Wrap(err, "failed to download jars")
+		}
+	case folder == "":
+		return "", nil, errors.New("missing path to jar folder")
+	case f.verifyJars:
+		manifest, err := jars.ReadManifest(folder)
+		if err != nil {
+			return folder, nil, err
+		}
+		if err = manifest.Verify(folder); err != nil {
+			return folder, nil, err
+		}
+	}
+
+	jarPaths, err := runner.FindJars(folder, f.recursiveJarFolder, logger)
+	return folder, jarPaths, err
+}
+
+// effectiveProperties returns the properties the runner would start the java
+// daemon with, without needing java or the jars. Without a properties file
+// they are rendered from the KCL_* environment variables.
+func (f *runFlags) effectiveProperties() (*properties.Properties, error) {
+	path := f.pathToPropertiesFile
+	if path == "" {
+		config, err := runner.ConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if path, err = config.WriteTempFile(); err != nil {
+			return nil, errors.Wrap(err, "failed to render config")
+		}
+		defer os.Remove(path)
+	}
+
+	p, _, err := runner.LoadEffectiveProperties(path, f.overlays, f.envOverrides)
+	return p, err
+}
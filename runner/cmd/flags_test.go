@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFlags_Precedence(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "runner.properties")
+	err := os.WriteFile(config, []byte("jar = /config/jar\nmax-heap = 1g\ngc = Serial\nD = a=1\nD = b=2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RUNNER_MAX_HEAP", "2g")
+	t.Setenv("RUNNER_GC", "")
+	t.Setenv("JAR", "/legacy/jar")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var rf runFlags
+	rf.register(fs)
+	if err = parseFlags(fs, []string{"-config", config, "-gc", "G1"}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if rf.gc != "G1" {
+		t.Errorf("expected the flag to win but got gc %s", rf.gc)
+	}
+	if rf.jvmOptions.MaxHeapSize != "2g" {
+		t.Errorf("expected the environment to win over the config file but got max heap %s", rf.jvmOptions.MaxHeapSize)
+	}
+	if rf.pathToJarFolder != "/legacy/jar" {
+		t.Errorf("expected the legacy environment variable to win over the config file but got jar %s", rf.pathToJarFolder)
+	}
+	if len(rf.jvmOptions.SystemProperties) != 2 {
+		t.Errorf("expected both system properties from the config file but got %v", rf.jvmOptions.SystemProperties)
+	}
+}
+
+func TestParseFlags_UnknownConfigKey(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "runner.properties")
+	if err := os.WriteFile(config, []byte("max-hep = 1g\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	(&runFlags{}).register(fs)
+	if err := parseFlags(fs, []string{"-config", config}); err == nil {
+		t.Error("expected an error for an unknown key, but got nil")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/goguardian/goguardian-go-kcl/runner"
+)
+
+// printCommand implements the print-command subcommand, which prints the
+// command that starts the java daemon quoted for a POSIX shell. The command
+// needs java, the jars and the properties, so it builds the runner. It
+// returns the exit code.
+func printCommand(args []string) int {
+	return withRunner("print-command", args, func(rf *runFlags) error {
+		r, err := rf.newRunner()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		commandLine, err := r.CommandLine()
+		if err != nil {
+			return err
+		}
+		fmt.Println(commandLine)
+		return nil
+	})
+}
+
+// printProperties implements the print-properties subcommand, which prints the
+// properties the java daemon is started with. It only needs the properties,
+// not java or the jars. It returns the exit code.
+func printProperties(args []string) int {
+	return withRunner("print-properties", args, func(rf *runFlags) error {
+		p, err := rf.effectiveProperties()
+		if err != nil {
+			return err
+		}
+		_, err = p.WriteTo(os.Stdout)
+		return err
+	})
+}
+
+// classpath implements the classpath subcommand, which prints the classpath
+// of the java daemon. It only needs the jars, not java or the properties. It
+// returns the exit code.
+func classpath(args []string) int {
+	return withRunner("classpath", args, func(rf *runFlags) error {
+		_, jarPaths, err := rf.jarPaths(log.New(os.Stderr, "", 0))
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(jarPaths, string(os.PathListSeparator)))
+		return nil
+	})
+}
+
+// version implements the version subcommand, which prints the versions of the
+// runner, the KCL jars and java. Versions that can not be determined are
+// reported without failing, each on its own. It returns the exit code.
+func version(args []string) int {
+	return withRunner("version", args, func(rf *runFlags) error {
+		module, moduleVersion := "unknown", "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			module, moduleVersion = info.Main.Path, info.Main.Version
+		}
+		fmt.Printf("runner: %s %s (%s)\n", module, moduleVersion, runtime.Version())
+
+		_, jarPaths, err := rf.jarPaths(log.New(os.Stderr, "", 0))
+		if err != nil {
+			fmt.Printf("kcl: %s\n", err)
+		} else {
+			kclVersions := runner.KCLJarVersions(jarPaths)
+			artifacts := make([]string, 0, len(kclVersions))
+			for artifact := range kclVersions {
+				artifacts = append(artifacts, artifact)
+			}
+			sort.Strings(artifacts)
+			for _, artifact := range artifacts {
+				fmt.Printf("kcl: %s %s\n", artifact, kclVersions[artifact])
+			}
+		}
+
+		javaPath, err := rf.javaPath()
+		if err != nil {
+			fmt.Printf("java: %s\n", err)
+			return nil
+		}
+		v, err := runner.GetJavaVersion(javaPath)
+		if err != nil {
+			fmt.Printf("java: %s\n", err)
+		} else {
+			fmt.Printf("java: %s\n", v)
+		}
+		return nil
+	})
+}
+
+// withRunner parses the runner flags of a subcommand and calls f with them.
+// It returns the exit code.
+func withRunner(name string, args []string, f func(rf *runFlags) error) int {
+	flags := newFlagSet(name, "[flags]")
+	var rf runFlags
+	rf.register(flags)
+	if err := parseFlags(flags, args); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flags.Usage()
+		return 2
+	}
+
+	if err := f(&rf); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
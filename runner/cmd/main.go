@@ -1,70 +1,66 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"os"
 	"strings"
-
-	"github.com/goguardian/goguardian-go-kcl/runner"
-)
-
-const (
-	// command line flag names
-	propertiesKey = "properties"
-	javaKey       = "java"
-	jarKey        = "jar"
 )
 
-func main() {
-	var pathToJavaBinary string
-	flag.StringVar(&pathToJavaBinary, javaKey, "", "The path to the java executable e.g. <path>/jdk/bin/java")
-
-	var pathToPropertiesFile string
-	flag.StringVar(&pathToPropertiesFile, propertiesKey, "", "The path to the properties file")
+// command is a subcommand of the runner. run returns the exit code.
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
 
-	var pathToJarFolder string
-	flag.StringVar(&pathToJarFolder, jarKey, "", "The path to the jar dependencies")
+var commands []command
 
-	flag.Parse()
+func init() {
+	commands = []command{
+		{"run", "Run and supervise the java daemon (the default)", run},
+		{"validate", "Check the java binary, the jars and the properties without starting the daemon", validate},
+		{"print-command", "Print the command that starts the java daemon", printCommand},
+		{"print-properties", "Print the effective properties after expanding variables and applying overlays", printProperties},
+		{"classpath", "Print the classpath of the java daemon", classpath},
+		{"version", "Print the versions of the runner, the KCL jars and java", version},
+		{"migrate", "Rewrite a KCL 2.x properties file for KCL 3.x", migrate},
+		{"help", "Print this help", help},
+	}
+}
 
-	pathToJavaBinary = getVariable(javaKey, pathToJavaBinary)
-	pathToPropertiesFile = getVariable(propertiesKey, pathToPropertiesFile)
-	pathToJarFolder = getVariable(jarKey, pathToJarFolder)
+func main() {
+	args := os.Args[1:]
 
-	r, err := runner.GetRunner(
-		runner.WithPathToJavaBinary(pathToJavaBinary),
-		runner.WithPathToPropertiesFile(pathToPropertiesFile),
-		runner.WithPathToJarFolder(pathToJarFolder),
-	)
-	if err != nil {
-		fmt.Println(err.Error())
-		flag.Usage()
-		os.Exit(1)
+	// Without a subcommand the runner runs the daemon, like it did before it
+	// had subcommands.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(run(args))
 	}
 
-	go func() {
-		_, err := r.RunJavaDaemon()
-		if err != nil {
-			panic(err)
+	for _, c := range commands {
+		if c.name == args[0] {
+			os.Exit(c.run(args[1:]))
 		}
-	}()
+	}
 
-	select {}
+	fmt.Fprintf(os.Stderr, "Unknown command '%s'\n", args[0])
+	usage()
+	os.Exit(2)
 }
 
-// getVariable is used to fallback to environment variables if the flag was not
-// passed in through the command line.
-func getVariable(flagKey, value string) string {
-	if value == "" {
-		value = os.Getenv(strings.ToUpper(flagKey))
-	}
+func help(args []string) int {
+	usage()
+	return 0
+}
 
-	if value == "" {
-		fmt.Printf("Must provide %s\n", flagKey)
-		flag.Usage()
-		os.Exit(1)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.description)
 	}
-
-	return value
+	fmt.Fprint(os.Stderr, `
+Use <command> -h for the flags of a command. A flag that is not given is read
+from its RUNNER_ environment variable, e.g. RUNNER_MAX_HEAP for -max-heap, and
+then from the config file given with -config or RUNNER_CONFIG.
+`)
 }
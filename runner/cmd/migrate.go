@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/goguardian/goguardian-go-kcl/runner"
+)
+
+// migrate implements the migrate subcommand, which rewrites a KCL 2.x
+// properties file for a stage of the upgrade to KCL 3.x. The changes are
+// reported on stderr. It returns the exit code.
+func migrate(args []string) int {
+	flags := newFlagSet("migrate", "-properties <file> [-stage compatible|final] [-output <file>]")
+
+	var pathToPropertiesFile string
+	flags.StringVar(&pathToPropertiesFile, propertiesKey, "", "The path to the KCL 2.x properties file")
+
+	var stage string
+	flags.StringVar(&stage, "stage", string(runner.MigrationStageCompatible), "compatible for the rolling upgrade, final once every worker runs KCL 3.x")
+
+	var output string
+	flags.StringVar(&output, "output", "", "The path to write the migrated file to, defaults to stdout")
+	if err := parseFlags(flags, args); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flags.Usage()
+		return 2
+	}
+
+	if pathToPropertiesFile == "" {
+		fmt.Fprintf(os.Stderr, "Must provide %s\n", propertiesKey)
+		flags.Usage()
+		return 1
+	}
+
+	p, err := properties.Load(pathToPropertiesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	changes, err := runner.MigrateProperties(p, runner.MigrationStage(stage))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flags.Usage()
+		return 1
+	}
+
+	for _, change := range changes {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", pathToPropertiesFile, change)
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if _, err = p.WriteTo(w); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
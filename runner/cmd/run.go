@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// run implements the run subcommand, which runs the java daemon and restarts
+// it when it crashes until the runner is asked to stop. It returns the exit
+// code.
+func run(args []string) int {
+	flags := newFlagSet("run", "[flags]")
+	var rf runFlags
+	rf.register(flags)
+	if err := parseFlags(flags, args); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flags.Usage()
+		return 2
+	}
+
+	r, err := rf.newRunner()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flags.Usage()
+		return 1
+	}
+	defer r.Close()
+
+	// The daemon is shut down gracefully when the runner is asked to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err = r.Supervise(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
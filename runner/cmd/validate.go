@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/goguardian/goguardian-go-kcl/runner"
+)
+
+// validate implements the validate subcommand, which checks the java binary,
+// the jars and the properties without starting the daemon. It returns the exit
+// code.
+func validate(args []string) int {
+	flags := newFlagSet("validate", "[flags]")
+	var rf runFlags
+	rf.register(flags)
+	if err := parseFlags(flags, args); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flags.Usage()
+		return 2
+	}
+
+	if !checkSetup(&rf, os.Stdout) {
+		return 1
+	}
+	return 0
+}
+
+// checkSetup checks the properties, the jars and the java binary on their
+// own, so that every problem is reported even when the others keep the
+// runner from being built, and writes the results to w. It reports whether
+// all of them passed.
+func checkSetup(rf *runFlags, w io.Writer) bool {
+	ok := true
+
+	source := rf.pathToPropertiesFile
+	if source == "" {
+		source = "properties"
+	}
+	p, err := rf.effectiveProperties()
+	if err != nil {
+		fmt.Fprintf(w, "%s: %s\n", source, err)
+		ok = false
+	} else {
+		problems := runner.ValidateProperties(p)
+		for _, problem := range problems {
+			fmt.Fprintf(w, "%s: %s\n", source, problem)
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(w, "%s: ok\n", source)
+		}
+		ok = ok && !runner.HasErrors(problems)
+	}
+
+	jarFolder, jarPaths, err := rf.jarPaths(log.New(w, "jars: ", 0))
+	if err != nil {
+		fmt.Fprintf(w, "jars: %s\n", err)
+		ok = false
+	} else {
+		fmt.Fprintf(w, "jars: %d jars in %s\n", len(jarPaths), jarFolder)
+	}
+
+	javaPath, err := rf.javaPath()
+	if err != nil {
+		fmt.Fprintf(w, "java: %s\n", err)
+		return false
+	}
+	// Without the jars java can only be checked to run.
+	var v *runner.JavaVersion
+	if jarPaths != nil {
+		v, err = runner.CheckJavaVersion(javaPath, jarFolder, jarPaths)
+	} else {
+		v, err = runner.GetJavaVersion(javaPath)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "java: %s\n", err)
+		return false
+	}
+	fmt.Fprintf(w, "java: %s at %s\n", v, javaPath)
+	return ok
+}
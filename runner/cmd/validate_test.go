@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSetup_ChecksEachPartOnItsOwn(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "app.properties")
+	content := "executableName = " + executable + "\nstreamName = some_stream\napplicationName = some_app\n"
+	if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := &runFlags{
+		pathToPropertiesFile: path,
+		pathToJavaBinary:     filepath.Join(t.TempDir(), "java"),
+	}
+	out := &bytes.Buffer{}
+	if checkSetup(rf, out) {
+		t.Error("expected the check to fail without java and jars")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a result for the properties, the jars and java but got %q", out.String())
+	}
+	if lines[0] != path+": ok" {
+		t.Errorf("expected the properties to pass but got '%s'", lines[0])
+	}
+	if lines[1] != "jars: missing path to jar folder" {
+		t.Errorf("expected the missing jar folder to be reported but got '%s'", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "java: ") {
+		t.Errorf("expected the missing java binary to be reported but got '%s'", lines[2])
+	}
+}
@@ -0,0 +1,460 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/pkg/errors"
+)
+
+// Initial positions in the stream for shards without a checkpoint.
+const (
+	TrimHorizon = "TRIM_HORIZON"
+	Latest      = "LATEST"
+	AtTimestamp = "AT_TIMESTAMP"
+)
+
+// Retrieval modes.
+const (
+	RetrievalModeFanout  = "FANOUT"
+	RetrievalModePolling = "POLLING"
+)
+
+// Client versions of KCL v3. Use ClientVersionCompatibleWith2x while
+// migrating a fleet of KCL v2 workers.
+const (
+	ClientVersion3x               = "CLIENT_VERSION_CONFIG_3X"
+	ClientVersionCompatibleWith2x = "CLIENT_VERSION_CONFIG_COMPATIBLE_WITH_2X"
+)
+
+// DynamoDB billing modes.
+const (
+	BillingModePayPerRequest = "PAY_PER_REQUEST"
+	BillingModeProvisioned   = "PROVISIONED"
+)
+
+// TableConfig controls how KCL creates one of its DynamoDB tables. Zero values
+// leave the KCL defaults in place.
+type TableConfig struct {
+	TableName                  string
+	BillingMode                string
+	ReadCapacity               int
+	WriteCapacity              int
+	PointInTimeRecoveryEnabled *bool
+	DeletionProtectionEnabled  *bool
+}
+
+// WorkerUtilizationAwareAssignmentConfig tunes the KCL v3 lease balancing
+// algorithm. Zero values leave the KCL defaults in place.
+type WorkerUtilizationAwareAssignmentConfig struct {
+	InMemoryWorkerMetricsCaptureFrequency  time.Duration
+	WorkerMetricsReporterFrequency         time.Duration
+	NoOfPersistedMetricsPerWorkerMetrics   int
+	DisableWorkerMetrics                   *bool
+	MaxThroughputPerHostKBps               float64
+	DampeningPercentage                    int
+	ReBalanceThresholdPercentage           int
+	AllowThroughputOvershoot               *bool
+	VarianceBalancingFrequency             int
+	WorkerMetricsEMAAlpha                  float64
+	StaleWorkerMetricsEntryCleanupDuration time.Duration
+}
+
+// Config is a typed form of the MultiLangDaemon properties file documented in
+// sample/sample.properties. Zero values, and nil for the *bool fields, leave
+// the KCL defaults in place. Use WithConfig to run the daemon with it.
+type Config struct {
+	ExecutableName     string
+	StreamName         string
+	ApplicationName    string
+	RegionName         string
+	WorkerID           string
+	ProcessingLanguage string
+
+	InitialPositionInStream string
+	// InitialPositionInStreamExtended takes precedence over
+	// InitialPositionInStream when it is set.
+	InitialPositionInStreamExtended time.Time
+
+	AWSCredentialsProvider           string
+	AWSCredentialsProviderDynamoDB   string
+	AWSCredentialsProviderCloudWatch string
+
+	KinesisEndpoint  string
+	DynamoDBEndpoint string
+	RetrievalMode    string
+
+	FailoverTime                              time.Duration
+	ShardSyncInterval                         time.Duration
+	IdleTimeBetweenReads                      time.Duration
+	ParentShardPollInterval                   time.Duration
+	TaskBackoffTime                           time.Duration
+	ShutdownGrace                             time.Duration
+	MetricsBufferTime                         time.Duration
+	MaxRecords                                int
+	MaxLeasesForWorker                        int
+	MaxLeasesToStealAtOneTime                 int
+	MetricsMaxQueueSize                       int
+	MaxActiveThreads                          int
+	CallProcessRecordsEvenForEmptyRecordList  *bool
+	CleanupLeasesUponShardCompletion          *bool
+	ValidateSequenceNumberBeforeCheckpointing *bool
+
+	ClientVersionConfig              string
+	CoordinatorState                 TableConfig
+	WorkerMetrics                    TableConfig
+	GracefulLeaseHandoffTimeout      time.Duration
+	IsGracefulLeaseHandoffEnabled    *bool
+	WorkerUtilizationAwareAssignment WorkerUtilizationAwareAssignmentConfig
+
+	// Extra holds properties that have no field, such as ones added by newer
+	// KCL versions. They are written after the typed properties.
+	Extra map[string]string
+}
+
+// Bool returns a pointer to b for the *bool fields of Config.
+func Bool(b bool) *bool {
+	return &b
+}
+
+// durationFormat is how a time.Duration property is written.
+type durationFormat int
+
+const (
+	millis durationFormat = iota
+	// iso8601 is the java.time.Duration format, e.g. PT15M.
+	iso8601
+)
+
+// configField maps a property key to the Config field holding its value.
+type configField struct {
+	key  string
+	dst  interface{}
+	unit durationFormat
+}
+
+// fields returns every typed property in the order it is written.
+func (c *Config) fields() []configField {
+	w := &c.WorkerUtilizationAwareAssignment
+	return []configField{
+		{key: "executableName", dst: &c.ExecutableName},
+		{key: "streamName", dst: &c.StreamName},
+		{key: "applicationName", dst: &c.ApplicationName},
+		{key: "regionName", dst: &c.RegionName},
+		{key: "workerId", dst: &c.WorkerID},
+		{key: "processingLanguage", dst: &c.ProcessingLanguage},
+		{key: "initialPositionInStream", dst: &c.InitialPositionInStream},
+		{key: "initialPositionInStreamExtended", dst: &c.InitialPositionInStreamExtended},
+		{key: "AwsCredentialsProvider", dst: &c.AWSCredentialsProvider},
+		{key: "AwsCredentialsProviderDynamoDB", dst: &c.AWSCredentialsProviderDynamoDB},
+		{key: "AwsCredentialsProviderCloudWatch", dst: &c.AWSCredentialsProviderCloudWatch},
+		{key: "kinesisEndpoint", dst: &c.KinesisEndpoint},
+		{key: "dynamoDBEndpoint", dst: &c.DynamoDBEndpoint},
+		{key: "retrievalMode", dst: &c.RetrievalMode},
+		{key: "failoverTimeMillis", dst: &c.FailoverTime},
+		{key: "shardSyncIntervalMillis", dst: &c.ShardSyncInterval},
+		{key: "idleTimeBetweenReadsInMillis", dst: &c.IdleTimeBetweenReads},
+		{key: "parentShardPollIntervalMillis", dst: &c.ParentShardPollInterval},
+		{key: "taskBackoffTimeMillis", dst: &c.TaskBackoffTime},
+		{key: "shutdownGraceMillis", dst: &c.ShutdownGrace},
+		{key: "metricsBufferTimeMillis", dst: &c.MetricsBufferTime},
+		{key: "maxRecords", dst: &c.MaxRecords},
+		{key: "maxLeasesForWorker", dst: &c.MaxLeasesForWorker},
+		{key: "maxLeasesToStealAtOneTime", dst: &c.MaxLeasesToStealAtOneTime},
+		{key: "metricsMaxQueueSize", dst: &c.MetricsMaxQueueSize},
+		{key: "maxActiveThreads", dst: &c.MaxActiveThreads},
+		{key: "callProcessRecordsEvenForEmptyRecordList", dst: &c.CallProcessRecordsEvenForEmptyRecordList},
+		{key: "cleanupLeasesUponShardCompletion", dst: &c.CleanupLeasesUponShardCompletion},
+		{key: "validateSequenceNumberBeforeCheckpointing", dst: &c.ValidateSequenceNumberBeforeCheckpointing},
+		{key: "clientVersionConfig", dst: &c.ClientVersionConfig},
+		{key: "coordinatorStateTableName", dst: &c.CoordinatorState.TableName},
+		{key: "coordinatorStateBillingMode", dst: &c.CoordinatorState.BillingMode},
+		{key: "coordinatorStateReadCapacity", dst: &c.CoordinatorState.ReadCapacity},
+		{key: "coordinatorStateWriteCapacity", dst: &c.CoordinatorState.WriteCapacity},
+		{key: "coordinatorStatePointInTimeRecoveryEnabled", dst: &c.CoordinatorState.PointInTimeRecoveryEnabled},
+		{key: "coordinatorStateDeletionProtectionEnabled", dst: &c.CoordinatorState.DeletionProtectionEnabled},
+		{key: "gracefulLeaseHandoffTimeoutMillis", dst: &c.GracefulLeaseHandoffTimeout},
+		{key: "isGracefulLeaseHandoffEnabled", dst: &c.IsGracefulLeaseHandoffEnabled},
+		{key: "workerMetricsTableName", dst: &c.WorkerMetrics.TableName},
+		{key: "workerMetricsBillingMode", dst: &c.WorkerMetrics.BillingMode},
+		{key: "workerMetricsReadCapacity", dst: &c.WorkerMetrics.ReadCapacity},
+		{key: "workerMetricsWriteCapacity", dst: &c.WorkerMetrics.WriteCapacity},
+		{key: "workerMetricsPointInTimeRecoveryEnabled", dst: &c.WorkerMetrics.PointInTimeRecoveryEnabled},
+		{key: "workerMetricsDeletionProtectionEnabled", dst: &c.WorkerMetrics.DeletionProtectionEnabled},
+		{key: "inMemoryWorkerMetricsCaptureFrequencyMillis", dst: &w.InMemoryWorkerMetricsCaptureFrequency},
+		{key: "workerMetricsReporterFreqInMillis", dst: &w.WorkerMetricsReporterFrequency},
+		{key: "noOfPersistedMetricsPerWorkerMetrics", dst: &w.NoOfPersistedMetricsPerWorkerMetrics},
+		{key: "disableWorkerMetrics", dst: &w.DisableWorkerMetrics},
+		{key: "maxThroughputPerHostKBps", dst: &w.MaxThroughputPerHostKBps},
+		{key: "dampeningPercentage", dst: &w.DampeningPercentage},
+		{key: "reBalanceThresholdPercentage", dst: &w.ReBalanceThresholdPercentage},
+		{key: "allowThroughputOvershoot", dst: &w.AllowThroughputOvershoot},
+		{key: "varianceBalancingFrequency", dst: &w.VarianceBalancingFrequency},
+		{key: "workerMetricsEMAAlpha", dst: &w.WorkerMetricsEMAAlpha},
+		{key: "staleWorkerMetricsEntryCleanupDuration", dst: &w.StaleWorkerMetricsEntryCleanupDuration, unit: iso8601},
+	}
+}
+
+// format returns the property value of the field and false if it is unset.
+func (f configField) format() (string, bool) {
+	switch dst := f.dst.(type) {
+	case *string:
+		return *dst, *dst != ""
+	case *int:
+		return strconv.Itoa(*dst), *dst != 0
+	case *float64:
+		return strconv.FormatFloat(*dst, 'f', -1, 64), *dst != 0
+	case **bool:
+		if *dst == nil {
+			return "", false
+		}
+		return strconv.FormatBool(**dst), true
+	case *time.Duration:
+		if f.unit == iso8601 {
+			return formatISO8601(*dst), *dst != 0
+		}
+		return strconv.FormatInt(dst.Milliseconds(), 10), *dst != 0
+	case *time.Time:
+		return strconv.FormatInt(dst.Unix(), 10), !dst.IsZero()
+	default:
+		panic(fmt.Sprintf("unsupported config field type %T", f.dst))
+	}
+}
+
+// parse sets the field from the value of an environment variable. Durations
+// are Go durations such as 30s, or a number of milliseconds for keys that are
+// written in milliseconds, and times are RFC 3339 or seconds since the epoch.
+func (f configField) parse(value string) error {
+	var err error
+	switch dst := f.dst.(type) {
+	case *string:
+		*dst = value
+	case *int:
+		*dst, err = strconv.Atoi(value)
+	case *float64:
+		*dst, err = strconv.ParseFloat(value, 64)
+	case **bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		*dst = &b
+	case *time.Duration:
+		if ms, parseErr := strconv.ParseInt(value, 10, 64); parseErr == nil && f.unit == millis {
+			*dst = time.Duration(ms) * time.Millisecond
+		} else {
+			*dst, err = time.ParseDuration(value)
+		}
+	case *time.Time:
+		if seconds, parseErr := strconv.ParseInt(value, 10, 64); parseErr == nil {
+			*dst = time.Unix(seconds, 0)
+		} else {
+			*dst, err = time.Parse(time.RFC3339, value)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported config field type %T", f.dst))
+	}
+
+	return errors.Wrapf(err, "invalid %s", f.key)
+}
+
+// formatISO8601 formats d in the java.time.Duration format with second
+// precision.
+func formatISO8601(d time.Duration) string {
+	return "PT" + strconv.FormatInt(int64(d/time.Second), 10) + "S"
+}
+
+// ConfigFromEnv returns a Config built from environment variables named after
+// the property keys in upper snake case with a KCL_ prefix, e.g.
+// KCL_STREAM_NAME for streamName and KCL_FAILOVER_TIME_MILLIS for
+// failoverTimeMillis. Durations can be Go durations such as 30s.
+func ConfigFromEnv() (*Config, error) {
+	c := &Config{}
+	for _, f := range c.fields() {
+		value, ok := os.LookupEnv(EnvName(f.key))
+		if !ok || value == "" {
+			continue
+		}
+		if err := f.parse(value); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// EnvName returns the environment variable ConfigFromEnv reads for a property
+// key.
+func EnvName(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	b.WriteString("KCL_")
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// Validate checks that the required properties are set and that the others
+// have valid values.
+func (c *Config) Validate() error {
+	if c.ExecutableName == "" {
+		return errors.New("missing executableName")
+	}
+	if c.StreamName == "" {
+		return errors.New("missing streamName")
+	}
+	if c.ApplicationName == "" {
+		return errors.New("missing applicationName")
+	}
+
+	switch c.InitialPositionInStream {
+	case "", TrimHorizon, Latest:
+	case AtTimestamp:
+		if c.InitialPositionInStreamExtended.IsZero() {
+			return errors.New("AT_TIMESTAMP requires initialPositionInStreamExtended")
+		}
+	default:
+		return errors.Errorf("invalid initialPositionInStream '%s'", c.InitialPositionInStream)
+	}
+
+	switch c.RetrievalMode {
+	case "", RetrievalModeFanout, RetrievalModePolling:
+	default:
+		return errors.Errorf("invalid retrievalMode '%s'", c.RetrievalMode)
+	}
+
+	if c.ClientVersionConfig != "" &&
+		!strings.EqualFold(c.ClientVersionConfig, ClientVersion3x) &&
+		!strings.EqualFold(c.ClientVersionConfig, ClientVersionCompatibleWith2x) {
+		return errors.Errorf("invalid clientVersionConfig '%s'", c.ClientVersionConfig)
+	}
+
+	if err := c.CoordinatorState.validate("coordinatorState"); err != nil {
+		return err
+	}
+	if err := c.WorkerMetrics.validate("workerMetrics"); err != nil {
+		return err
+	}
+
+	w := c.WorkerUtilizationAwareAssignment
+	if w.DampeningPercentage < 0 || w.DampeningPercentage > 100 {
+		return errors.New("dampeningPercentage must be between 0 and 100")
+	}
+	if w.WorkerMetricsEMAAlpha < 0 || w.WorkerMetricsEMAAlpha > 1 {
+		return errors.New("workerMetricsEMAAlpha must be between 0 and 1")
+	}
+
+	for _, f := range c.fields() {
+		if negative(f.dst) {
+			return errors.Errorf("%s must not be negative", f.key)
+		}
+	}
+
+	for key := range c.Extra {
+		for _, f := range c.fields() {
+			if f.key == key {
+				return errors.Errorf("%s is set in Extra, use its Config field instead", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t TableConfig) validate(prefix string) error {
+	switch t.BillingMode {
+	case "", BillingModePayPerRequest:
+		if t.ReadCapacity != 0 || t.WriteCapacity != 0 {
+			return errors.Errorf("%sReadCapacity and %sWriteCapacity require %sBillingMode PROVISIONED", prefix, prefix, prefix)
+		}
+	case BillingModeProvisioned:
+		if t.ReadCapacity <= 0 || t.WriteCapacity <= 0 {
+			return errors.Errorf("%sBillingMode PROVISIONED requires %sReadCapacity and %sWriteCapacity", prefix, prefix, prefix)
+		}
+	default:
+		return errors.Errorf("invalid %sBillingMode '%s'", prefix, t.BillingMode)
+	}
+	return nil
+}
+
+func negative(dst interface{}) bool {
+	switch dst := dst.(type) {
+	case *int:
+		return *dst < 0
+	case *float64:
+		return *dst < 0
+	case *time.Duration:
+		return *dst < 0
+	}
+	return false
+}
+
+// Properties validates the config and returns it as a properties file.
+func (c *Config) Properties() (*properties.Properties, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := properties.New()
+	p.AddComment("Generated by runner.Config")
+	for _, f := range c.fields() {
+		if value, ok := f.format(); ok {
+			p.Set(f.key, value)
+		}
+	}
+
+	extraKeys := make([]string, 0, len(c.Extra))
+	for key := range c.Extra {
+		extraKeys = append(extraKeys, key)
+	}
+	sort.Strings(extraKeys)
+	for _, key := range extraKeys {
+		p.Set(key, c.Extra[key])
+	}
+
+	return p, nil
+}
+
+// WriteTempFile validates the config and writes it to a new temporary
+// properties file. The caller is responsible for removing the file.
+func (c *Config) WriteTempFile() (string, error) {
+	p, err := c.Properties()
+	if err != nil {
+		return "", err
+	}
+
+	return writeTempProperties(p)
+}
+
+// writeTempProperties writes p to a new temporary properties file. The caller
+// is responsible for removing the file.
+func writeTempProperties(p *properties.Properties) (string, error) {
+	f, err := os.CreateTemp("", "kcl-*.properties")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create properties file")
+	}
+
+	if _, err = p.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "failed to write properties file")
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "failed to close properties file")
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+)
+
+func getTestConfig() *Config {
+	return &Config{
+		ExecutableName:  "sample/sample",
+		StreamName:      "some_stream",
+		ApplicationName: "some_app",
+	}
+}
+
+func TestConfigProperties_RendersSetFields(t *testing.T) {
+	c := getTestConfig()
+	c.InitialPositionInStream = AtTimestamp
+	c.InitialPositionInStreamExtended = time.Unix(1636609142, 0)
+	c.FailoverTime = 3 * time.Second
+	c.CleanupLeasesUponShardCompletion = Bool(false)
+	c.CoordinatorState = TableConfig{BillingMode: BillingModeProvisioned, ReadCapacity: 5, WriteCapacity: 10}
+	c.WorkerUtilizationAwareAssignment.StaleWorkerMetricsEntryCleanupDuration = 15 * time.Minute
+	c.WorkerUtilizationAwareAssignment.WorkerMetricsEMAAlpha = 0.5
+	c.Extra = map[string]string{"someNewKey": "some value"}
+
+	p, err := c.Properties()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := map[string]string{
+		"executableName":                         "sample/sample",
+		"streamName":                             "some_stream",
+		"applicationName":                        "some_app",
+		"initialPositionInStream":                "AT_TIMESTAMP",
+		"initialPositionInStreamExtended":        "1636609142",
+		"failoverTimeMillis":                     "3000",
+		"cleanupLeasesUponShardCompletion":       "false",
+		"coordinatorStateBillingMode":            "PROVISIONED",
+		"coordinatorStateReadCapacity":           "5",
+		"coordinatorStateWriteCapacity":          "10",
+		"staleWorkerMetricsEntryCleanupDuration": "PT900S",
+		"workerMetricsEMAAlpha":                  "0.5",
+		"someNewKey":                             "some value",
+	}
+	for key, value := range expected {
+		if actual, _ := p.Get(key); actual != value {
+			t.Errorf("expected %s = '%s' but got '%s'", key, value, actual)
+		}
+	}
+
+	if len(p.Keys()) != len(expected) {
+		t.Errorf("expected only the set fields to be rendered but got %v", p.Keys())
+	}
+}
+
+func TestConfigValidate_ReturnsErrors(t *testing.T) {
+	tests := map[string]func(c *Config){
+		"missing streamName": func(c *Config) {
+			c.StreamName = ""
+		},
+		"AT_TIMESTAMP requires initialPositionInStreamExtended": func(c *Config) {
+			c.InitialPositionInStream = AtTimestamp
+		},
+		"invalid retrievalMode": func(c *Config) {
+			c.RetrievalMode = "SOMETIMES"
+		},
+		"workerMetricsBillingMode PROVISIONED requires": func(c *Config) {
+			c.WorkerMetrics.BillingMode = BillingModeProvisioned
+		},
+		"failoverTimeMillis must not be negative": func(c *Config) {
+			c.FailoverTime = -time.Second
+		},
+		"dampeningPercentage must be between 0 and 100": func(c *Config) {
+			c.WorkerUtilizationAwareAssignment.DampeningPercentage = 120
+		},
+		"streamName is set in Extra": func(c *Config) {
+			c.Extra = map[string]string{"streamName": "other_stream"}
+		},
+	}
+
+	for expected, modify := range tests {
+		c := getTestConfig()
+		modify(c)
+
+		err := c.Validate()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error containing '%s' but got %+v", expected, err)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("KCL_EXECUTABLE_NAME", "sample/sample")
+	t.Setenv("KCL_STREAM_NAME", "some_stream")
+	t.Setenv("KCL_APPLICATION_NAME", "some_app")
+	t.Setenv("KCL_FAILOVER_TIME_MILLIS", "3000")
+	t.Setenv("KCL_SHARD_SYNC_INTERVAL_MILLIS", "1m")
+	t.Setenv("KCL_IS_GRACEFUL_LEASE_HANDOFF_ENABLED", "false")
+	t.Setenv("KCL_DYNAMO_DB_ENDPOINT", "http://localhost:4566")
+
+	c, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if c.StreamName != "some_stream" || c.FailoverTime != 3*time.Second || c.ShardSyncInterval != time.Minute {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if c.IsGracefulLeaseHandoffEnabled == nil || *c.IsGracefulLeaseHandoffEnabled {
+		t.Errorf("expected isGracefulLeaseHandoffEnabled to be false")
+	}
+	if c.DynamoDBEndpoint != "http://localhost:4566" {
+		t.Errorf("unexpected dynamoDBEndpoint '%s'", c.DynamoDBEndpoint)
+	}
+
+	t.Setenv("KCL_MAX_RECORDS", "lots")
+	if _, err = ConfigFromEnv(); err == nil || !strings.Contains(err.Error(), "invalid maxRecords") {
+		t.Errorf("expected an invalid maxRecords error but got %+v", err)
+	}
+}
+
+func TestGetRunner_RendersConfig(t *testing.T) {
+	r, err := GetRunner(
+		WithPathToJavaBinary("java"),
+		WithPathToJarFolder("jar"),
+		WithConfig(getTestConfig()),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	p, err := properties.Load(r.pathToPropertiesFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if streamName, _ := p.Get("streamName"); streamName != "some_stream" {
+		t.Errorf("expected the rendered file to have streamName 'some_stream' but got '%s'", streamName)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if _, err = os.Stat(r.pathToPropertiesFile); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove the rendered file")
+	}
+}
+
+func TestGetRunner_RemovesRenderedFilesOnError(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	// The missing jar folder is found after both the properties and the
+	// logback configuration were rendered.
+	_, err := GetRunner(
+		WithPathToJavaBinary("java"),
+		WithConfig(getTestConfig()),
+		WithLogConfig(&LogConfig{RootLevel: "DEBUG"}),
+	)
+	if err == nil || err.Error() != "missing path to jar folder" {
+		t.Fatalf("expected a missing jar folder error but got %+v", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected the rendered files to be removed but found %v", files)
+	}
+}
+
+func TestClose_RunsEveryStep(t *testing.T) {
+	dir := t.TempDir()
+
+	// A folder that is not empty can not be removed like a file.
+	propertiesFile := filepath.Join(dir, "rendered.properties")
+	if err := os.MkdirAll(filepath.Join(propertiesFile, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logConfigFile := filepath.Join(dir, "logback.xml")
+	if err := os.WriteFile(logConfigFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{renderedPropertiesFile: propertiesFile, logConfigFile: logConfigFile}
+	err := r.Close()
+	if err == nil || !strings.Contains(err.Error(), "failed to remove rendered properties file") {
+		t.Errorf("expected the properties file error but got %+v", err)
+	}
+	if _, err = os.Stat(logConfigFile); !os.IsNotExist(err) {
+		t.Error("expected the logback configuration to be removed after the properties file failed")
+	}
+}
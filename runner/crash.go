@@ -0,0 +1,201 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorFileHeader bounds how much of a JVM error file is read for its
+// summary.
+const maxErrorFileHeader = 64 * 1024
+
+// CrashDiagnosticsConfig configures what the JVM leaves behind when it dies.
+type CrashDiagnosticsConfig struct {
+	// HeapDumpOnOutOfMemory writes a heap dump when the JVM runs out of
+	// heap, -XX:+HeapDumpOnOutOfMemoryError.
+	HeapDumpOnOutOfMemory bool
+	// ExitOnOutOfMemory makes the JVM exit on the first OutOfMemoryError, so
+	// that Supervise restarts it instead of it limping on,
+	// -XX:+ExitOnOutOfMemoryError.
+	ExitOnOutOfMemory bool
+	// ErrorFileDir is the folder the JVM writes hs_err_pid<pid>.log to when
+	// it crashes. Defaults to the diagnostics folder.
+	ErrorFileDir string
+	// HeapDumpDir is the folder heap dumps are written to. Defaults to the
+	// diagnostics folder.
+	HeapDumpDir string
+}
+
+// WithCrashDiagnostics configures the JVM error file and heap dumps. When the
+// daemon run by Supervise crashes, the runner looks for the error file and
+// heap dump it left behind, logs the cause of the crash and publishes an
+// EventJVMCrashed.
+func WithCrashDiagnostics(c CrashDiagnosticsConfig) Option {
+	return func(runner *Runner) {
+		runner.crashDiagnostics = &c
+	}
+}
+
+// args returns the JVM options for the crash diagnostics.
+func (c *CrashDiagnosticsConfig) args() []string {
+	args := []string{"-XX:ErrorFile=" + filepath.Join(c.ErrorFileDir, "hs_err_pid%p.log")}
+	if c.HeapDumpOnOutOfMemory {
+		args = append(args, "-XX:+HeapDumpOnOutOfMemoryError", "-XX:HeapDumpPath="+c.HeapDumpDir)
+	}
+	if c.ExitOnOutOfMemory {
+		args = append(args, "-XX:+ExitOnOutOfMemoryError")
+	}
+	return args
+}
+
+// observeOutOfMemory remembers the last OutOfMemoryError the daemon logged.
+func (r *Runner) observeOutOfMemory(e Event) {
+	if e.Type == EventOutOfMemory {
+		r.lastOutOfMemory.Store(&e)
+	}
+}
+
+// crashReport is what a crashed daemon left behind.
+type crashReport struct {
+	cause string
+	files []string
+}
+
+// reportCrash logs the cause and the files of a crash of d and publishes an
+// EventJVMCrashed.
+func (r *Runner) reportCrash(d *daemonProcess) {
+	report := r.findCrash(d)
+	if report.cause == "" && len(report.files) == 0 {
+		return
+	}
+
+	if report.cause != "" {
+		r.logger.Printf("Java daemon crash cause: %s.", report.cause)
+	}
+	if len(report.files) > 0 {
+		r.logger.Printf("Java daemon crash files: %s.", strings.Join(report.files, ", "))
+	}
+
+	e := Event{
+		Type:     EventJVMCrashed,
+		Time:     time.Now(),
+		ExitCode: d.cmd.ProcessState.ExitCode(),
+		Cause:    report.cause,
+		Files:    report.files,
+	}
+	for _, h := range r.eventHandlers {
+		h(e)
+	}
+
+	r.rotateDiagnostics()
+}
+
+// findCrash looks for the error file and heap dump of d. They are looked for
+// in the configured folders and then in the working directory and the
+// temporary directory, where the JVM writes them when it was not told
+// otherwise or could not write to the configured folder.
+func (r *Runner) findCrash(d *daemonProcess) crashReport {
+	report := crashReport{}
+	pid := strconv.Itoa(d.cmd.Process.Pid)
+
+	if path := findFile("hs_err_pid"+pid+".log", r.crashDiagnostics.ErrorFileDir, ".", os.TempDir()); path != "" {
+		report.files = append(report.files, path)
+		cause, err := summarizeErrorFile(path)
+		if err != nil {
+			r.logger.Printf("Failed to read JVM error file %s: %s.", path, err)
+		}
+		report.cause = cause
+	}
+
+	heapDump := findFile("java_pid"+pid+".hprof", r.crashDiagnostics.HeapDumpDir, ".")
+	if heapDump != "" {
+		report.files = append(report.files, heapDump)
+	}
+
+	if e := r.lastOutOfMemory.Load(); report.cause == "" && e != nil && e.Time.After(d.started) {
+		report.cause = outOfMemoryMessage(e.Line)
+	}
+	if report.cause == "" && heapDump != "" {
+		report.cause = "java.lang.OutOfMemoryError"
+	}
+	return report
+}
+
+// findFile returns the absolute path of the first of the folders that holds a
+// file with the given name, or an empty string.
+func findFile(name string, dirs ...string) string {
+	for _, dir := range dirs {
+		path, err := filepath.Abs(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if _, err = os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// outOfMemoryMessage returns the OutOfMemoryError and its message from a log
+// line, e.g. java.lang.OutOfMemoryError: Java heap space.
+func outOfMemoryMessage(line string) string {
+	i := strings.Index(line, "java.lang.OutOfMemoryError")
+	if i < 0 {
+		return strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(line[i:])
+}
+
+// summarizeErrorFile returns the cause of a crash from the header of a JVM
+// error file, e.g. SIGSEGV (0xb) at pc=0x00007f3c1a2b3c4d, pid=1, tid=7 in
+// C  [libzip.so+0x1234]  newEntry+0x56.
+func summarizeErrorFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := []string{}
+	frame := ""
+	scanner := bufio.NewScanner(io.LimitReader(f, maxErrorFileHeader))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+
+		if text == "Problematic frame:" && scanner.Scan() {
+			frame = strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "#"))
+			continue
+		}
+		if text == "" || strings.HasPrefix(text, "A fatal error has been detected") {
+			continue
+		}
+		header = append(header, text)
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+	if len(header) == 0 {
+		return "", nil
+	}
+
+	cause := header[0]
+	// Native out of memory errors explain the failed allocation on the next
+	// line.
+	if strings.HasPrefix(cause, "There is insufficient memory") && len(header) > 1 {
+		cause = fmt.Sprintf("%s %s", cause, header[1])
+	}
+	if frame != "" {
+		cause += " in " + frame
+	}
+	return cause, nil
+}
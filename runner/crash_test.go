@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSummarizeErrorFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{
+			name: "native crash",
+			header: "#\n# A fatal error has been detected by the Java Runtime Environment:\n#\n" +
+				"#  SIGSEGV (0xb) at pc=0x00007f3c1a2b3c4d, pid=1, tid=7\n#\n" +
+				"# JRE version: OpenJDK Runtime Environment (17.0.2+8) (build 17.0.2+8)\n" +
+				"# Problematic frame:\n# C  [libzip.so+0x1234]  newEntry+0x56\n#\n" +
+				"---------------  S U M M A R Y ------------\n",
+			expected: "SIGSEGV (0xb) at pc=0x00007f3c1a2b3c4d, pid=1, tid=7 in C  [libzip.so+0x1234]  newEntry+0x56",
+		},
+		{
+			name: "native out of memory",
+			header: "#\n# There is insufficient memory for the Java Runtime Environment to continue.\n" +
+				"# Native memory allocation (mmap) failed to map 65536 bytes for committing reserved memory.\n" +
+				"# Possible reasons:\n#   The system is out of physical RAM or swap space\n",
+			expected: "There is insufficient memory for the Java Runtime Environment to continue. " +
+				"Native memory allocation (mmap) failed to map 65536 bytes for committing reserved memory.",
+		},
+		{
+			name:     "no header",
+			header:   "---------------  S U M M A R Y ------------\n",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hs_err_pid1.log")
+			if err := os.WriteFile(path, []byte(test.header), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cause, err := summarizeErrorFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cause != test.expected {
+				t.Errorf("expected '%s' but got '%s'", test.expected, cause)
+			}
+		})
+	}
+}
+
+func TestOutOfMemoryMessage(t *testing.T) {
+	line := `Exception in thread "main" java.lang.OutOfMemoryError: Java heap space`
+	if m := outOfMemoryMessage(line); m != "java.lang.OutOfMemoryError: Java heap space" {
+		t.Errorf("unexpected message '%s'", m)
+	}
+}
+
+func TestSupervise_ReportsCrashes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test daemon is a shell script")
+	}
+
+	// The daemon writes an error file where it is told to and crashes.
+	r, runsFile := getTestRunner(t, `
+for a in "$@"; do case "$a" in -XX:ErrorFile=*) f="${a#-XX:ErrorFile=}";; esac; done
+f=$(echo "$f" | sed "s/%p/$$/")
+printf '#\n#  SIGSEGV (0xb) at pc=0x1, pid=%s, tid=2\n#\n# JRE version: 17\n' $$ > "$f"
+exit 134`)
+	dir := t.TempDir()
+	r.diagnosticsDir = dir
+	r.diagnosticsKeep = 2
+	r.crashDiagnostics = &CrashDiagnosticsConfig{ErrorFileDir: dir, HeapDumpDir: dir}
+
+	mu := sync.Mutex{}
+	crashes := []Event{}
+	r.eventHandlers = append(r.eventHandlers, func(e Event) {
+		if e.Type == EventJVMCrashed {
+			mu.Lock()
+			defer mu.Unlock()
+			crashes = append(crashes, e)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.Supervise(ctx); err == nil {
+		t.Fatal("expected the daemon to crash")
+	}
+	runs := countRuns(t, runsFile)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(crashes) != runs {
+		t.Fatalf("expected a crash event for each of the %d runs, got %+v", runs, crashes)
+	}
+	e := crashes[0]
+	if e.ExitCode != 134 || !strings.HasPrefix(e.Cause, "SIGSEGV (0xb) at pc=0x1") || len(e.Files) != 1 {
+		t.Errorf("unexpected crash event %+v", e)
+	}
+
+	errorFiles, err := filepath.Glob(filepath.Join(dir, "hs_err_pid*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errorFiles) != 2 {
+		t.Errorf("expected the error files to be rotated to 2, got %v", errorFiles)
+	}
+}
+
+func TestCrashDiagnosticsArgs(t *testing.T) {
+	c := &CrashDiagnosticsConfig{HeapDumpOnOutOfMemory: true, ExitOnOutOfMemory: true, ErrorFileDir: "/errors", HeapDumpDir: "/dumps"}
+	expected := []string{
+		"-XX:ErrorFile=" + filepath.Join("/errors", "hs_err_pid%p.log"),
+		"-XX:+HeapDumpOnOutOfMemoryError",
+		"-XX:HeapDumpPath=/dumps",
+		"-XX:+ExitOnOutOfMemoryError",
+	}
+	if args := c.args(); strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v but got %v", expected, args)
+	}
+}
@@ -0,0 +1,275 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+	"github.com/pkg/errors"
+)
+
+// CredentialsMode is how credentials resolved by the runner are handed to the
+// daemon.
+type CredentialsMode string
+
+const (
+	// CredentialsEnv passes the credentials as AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN. They are resolved again
+	// whenever the daemon is started, so expiring credentials are only
+	// refreshed when it restarts.
+	CredentialsEnv CredentialsMode = "env"
+	// CredentialsEndpoint serves the credentials on a loopback HTTP endpoint
+	// that the daemon and its record processors read through
+	// AWS_CONTAINER_CREDENTIALS_FULL_URI, like on ECS. They are refreshed
+	// before they expire.
+	CredentialsEndpoint CredentialsMode = "endpoint"
+)
+
+// credentialsRefreshWindow is how long before they expire credentials are
+// refreshed when they are requested from the endpoint. It is longer than the
+// five minutes before expiry at which the Java SDK fetches new credentials.
+const credentialsRefreshWindow = 10 * time.Minute
+
+// credentialsEnvNames are removed from the daemon's environment so that the
+// credentials chains of the Java SDK and of record processors do not pick
+// other credentials before the bridged ones.
+var credentialsEnvNames = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_ACCESS_KEY",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SECRET_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_ROLE_ARN",
+	"AWS_ROLE_SESSION_NAME",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_CONFIG_FILE",
+}
+
+// DefaultCredentials returns the credentials of the Go SDK's default chain
+// with the shared config enabled, so that profiles, SSO, web identity and
+// assume role profiles are resolved like in Go services, and the region of
+// that configuration if any.
+func DefaultCredentials() (*credentials.Credentials, string, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to load AWS configuration")
+	}
+
+	region := ""
+	if sess.Config.Region != nil {
+		region = *sess.Config.Region
+	}
+	return sess.Config.Credentials, region, nil
+}
+
+// WithCredentials hands creds to the daemon and its record processors in the
+// given mode instead of letting them resolve their own. Region is passed as
+// AWS_REGION unless it is empty or already set. Use DefaultCredentials for
+// the credentials Go services would use.
+func WithCredentials(creds *credentials.Credentials, region string, mode CredentialsMode) Option {
+	return func(runner *Runner) {
+		runner.credentials = creds
+		runner.credentialsRegion = region
+		runner.credentialsMode = mode
+	}
+}
+
+// daemonEnv returns the environment to start the daemon with, or nil to
+// inherit the runner's.
+func (r *Runner) daemonEnv(ctx context.Context) ([]string, error) {
+	var extra []string
+	if env := r.javaToolOptionsEnv(); env != "" {
+		extra = append(extra, env)
+	}
+	if r.diagnosticsDir != "" {
+		extra = append(extra, kcl.DiagnosticsDirEnv+"="+r.diagnosticsDir)
+	}
+
+	if r.credentials == nil {
+		if extra == nil {
+			return nil, nil
+		}
+		return append(os.Environ(), extra...), nil
+	}
+
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if !contains(credentialsEnvNames, name) {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, extra...)
+
+	// The Java SDK and the Go SDK of record processors read shared
+	// configuration files before the container endpoint.
+	env = append(env,
+		"AWS_SHARED_CREDENTIALS_FILE="+os.DevNull,
+		"AWS_CONFIG_FILE="+os.DevNull,
+	)
+	if r.credentialsRegion != "" && os.Getenv("AWS_REGION") == "" {
+		env = append(env, "AWS_REGION="+r.credentialsRegion)
+	}
+
+	switch r.credentialsMode {
+	case CredentialsEnv:
+		v, err := r.credentials.GetWithContext(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve credentials")
+		}
+		if expiresAt, err := r.credentials.ExpiresAt(); err == nil {
+			r.logger.Printf("Passing credentials from %s that expire at %s, they are refreshed when the java daemon restarts.", v.ProviderName, expiresAt.Format(time.RFC3339))
+		}
+
+		env = append(env,
+			"AWS_ACCESS_KEY_ID="+v.AccessKeyID,
+			"AWS_SECRET_ACCESS_KEY="+v.SecretAccessKey,
+		)
+		if v.SessionToken != "" {
+			env = append(env, "AWS_SESSION_TOKEN="+v.SessionToken)
+		}
+	case CredentialsEndpoint:
+		if r.credentialsServer == nil {
+			s, err := startCredentialsServer(r.credentials)
+			if err != nil {
+				return nil, err
+			}
+			r.credentialsServer = s
+		}
+
+		env = append(env,
+			"AWS_CONTAINER_CREDENTIALS_FULL_URI="+r.credentialsServer.url,
+			"AWS_CONTAINER_AUTHORIZATION_TOKEN="+r.credentialsServer.token,
+		)
+	default:
+		return nil, errors.Errorf("unknown credentials mode '%s'", r.credentialsMode)
+	}
+
+	return env, nil
+}
+
+// credentialsServer serves credentials in the format of the ECS container
+// credentials endpoint on a loopback address. Requests must carry a random
+// token in the Authorization header.
+type credentialsServer struct {
+	credentials *credentials.Credentials
+	url         string
+	token       string
+	server      *http.Server
+
+	// mu serializes refreshes so that concurrent requests near expiry do not
+	// all refresh the credentials.
+	mu sync.Mutex
+}
+
+// containerCredentials is the response of the container credentials endpoint.
+type containerCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string `json:",omitempty"`
+	Expiration      string `json:",omitempty"`
+}
+
+func startCredentialsServer(creds *credentials.Credentials) (*credentialsServer, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, errors.Wrap(err, "failed to generate credentials endpoint token")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen for credentials endpoint")
+	}
+
+	s := &credentialsServer{
+		credentials: creds,
+		url:         "http://" + listener.Addr().String() + "/credentials",
+		token:       hex.EncodeToString(token),
+	}
+	s.server = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go s.server.Serve(listener)
+
+	return s, nil
+}
+
+func (s *credentialsServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	v, expiresAt, err := s.get(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c := containerCredentials{
+		AccessKeyID:     v.AccessKeyID,
+		SecretAccessKey: v.SecretAccessKey,
+		Token:           v.SessionToken,
+	}
+	if !expiresAt.IsZero() {
+		c.Expiration = expiresAt.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// get returns the credentials and when they expire, or a zero time if they do
+// not. Credentials that expire within credentialsRefreshWindow are refreshed.
+func (s *credentialsServer) get(ctx context.Context) (credentials.Value, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, err := s.credentials.GetWithContext(ctx)
+	if err != nil {
+		return v, time.Time{}, errors.Wrap(err, "failed to resolve credentials")
+	}
+
+	expiresAt, err := s.credentials.ExpiresAt()
+	if err != nil {
+		// The provider does not expire its credentials.
+		return v, time.Time{}, nil
+	}
+	if time.Until(expiresAt) > credentialsRefreshWindow {
+		return v, expiresAt, nil
+	}
+
+	s.credentials.Expire()
+	v, err = s.credentials.GetWithContext(ctx)
+	if err != nil {
+		return v, time.Time{}, errors.Wrap(err, "failed to refresh credentials")
+	}
+	expiresAt, _ = s.credentials.ExpiresAt()
+	return v, expiresAt, nil
+}
+
+// Close stops the endpoint.
+func (s *credentialsServer) Close() error {
+	return s.server.Close()
+}
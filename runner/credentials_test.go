@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+)
+
+// expiringProvider returns new credentials that expire in five minutes on
+// every retrieval.
+type expiringProvider struct {
+	credentials.Expiry
+	retrievals int
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	p.retrievals++
+	p.SetExpiration(time.Now().Add(5*time.Minute), 0)
+	return credentials.Value{
+		AccessKeyID:     fmt.Sprintf("key-%d", p.retrievals),
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+	}, nil
+}
+
+func TestCredentialsServer_RefreshesBeforeExpiry(t *testing.T) {
+	s, err := startCredentialsServer(credentials.NewCredentials(&expiringProvider{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get(s.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a request without the token to be unauthorized but got %d", resp.StatusCode)
+	}
+
+	// The Go SDK of record processors reads the endpoint like the Java SDK.
+	cfg := defaults.Config()
+	creds := endpointcreds.NewCredentialsClient(*cfg, defaults.Handlers(), s.url, func(p *endpointcreds.Provider) {
+		p.AuthorizationToken = s.token
+	})
+	v, err := creds.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// The first credentials expire within the refresh window, so the
+	// endpoint serves the next ones.
+	if v.AccessKeyID != "key-2" || v.SecretAccessKey != "secret" || v.SessionToken != "token" {
+		t.Errorf("expected refreshed credentials but got %+v", v)
+	}
+	if expiresAt, err := creds.ExpiresAt(); err != nil || time.Until(expiresAt) > 5*time.Minute {
+		t.Errorf("expected the expiration to be passed on but got %s, %v", expiresAt, err)
+	}
+}
+
+func TestDaemonEnv_Credentials(t *testing.T) {
+	t.Setenv("AWS_PROFILE", "some_profile")
+	t.Setenv("AWS_REGION", "")
+
+	r := &Runner{
+		logger:            log.New(io.Discard, "", 0),
+		credentials:       credentials.NewStaticCredentials("some_key", "some_secret", ""),
+		credentialsRegion: "us-west-2",
+		credentialsMode:   CredentialsEnv,
+	}
+	env, err := r.daemonEnv(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	joined := "\n" + strings.Join(env, "\n") + "\n"
+	for _, expected := range []string{"\nAWS_ACCESS_KEY_ID=some_key\n", "\nAWS_SECRET_ACCESS_KEY=some_secret\n", "\nAWS_REGION=us-west-2\n"} {
+		if !strings.Contains(joined, expected) {
+			t.Errorf("expected %s in the environment", strings.TrimSpace(expected))
+		}
+	}
+	if strings.Contains(joined, "AWS_PROFILE=") || strings.Contains(joined, "AWS_SESSION_TOKEN=") {
+		t.Errorf("expected AWS_PROFILE and an empty session token to be left out but got %v", env)
+	}
+
+	r.credentialsMode = CredentialsEndpoint
+	env, err = r.daemonEnv(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer r.Close()
+	if !strings.Contains(strings.Join(env, "\n"), "AWS_CONTAINER_CREDENTIALS_FULL_URI="+r.credentialsServer.url) {
+		t.Errorf("expected the endpoint in the environment but got %v", env)
+	}
+}
@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+	"github.com/pkg/errors"
+)
+
+// diagnosticsPatterns match the kinds of files written to the diagnostics
+// folders. Each kind is rotated on its own.
+var diagnosticsPatterns = []string{
+	"threads-*.txt",
+	"goroutines-*.txt",
+	"hs_err_pid*.log",
+	"*.hprof",
+}
+
+// WithDiagnosticsDir sets the folder diagnostics are written to. Defaults to
+// goguardian-go-kcl-diagnostics-<pid> in the temporary directory, with the
+// PID of the runner. The folder must not be shared with other runners, since
+// each one clears the record processor registrations in it when it starts a
+// daemon.
+func WithDiagnosticsDir(dir string) Option {
+	return func(runner *Runner) {
+		runner.diagnosticsDir = dir
+	}
+}
+
+// WithDiagnosticsRetention bounds the disk used by thread dumps, goroutine
+// dumps, JVM error files and heap dumps. Before the daemon is started and
+// after diagnostics were written only the newest keep files of each kind are
+// kept, and the oldest files are removed while they take more than maxBytes,
+// except for the newest one. A maxBytes of 0 does not bound the size.
+// Defaults to 5 files of each kind, and keep must be at least 1 so that the
+// files just written are kept.
+func WithDiagnosticsRetention(keep int, maxBytes int64) Option {
+	return func(runner *Runner) {
+		runner.diagnosticsKeep = keep
+		runner.diagnosticsMaxBytes = maxBytes
+	}
+}
+
+// defaultDiagnosticsDir returns the diagnostics folder used when none is
+// given. It is named after the PID of the runner so that runners on the same
+// host do not share it.
+func defaultDiagnosticsDir() string {
+	return filepath.Join(os.TempDir(), "goguardian-go-kcl-diagnostics-"+strconv.Itoa(os.Getpid()))
+}
+
+// processorsDir is the folder record processors register in.
+func (r *Runner) processorsDir() string {
+	return filepath.Join(r.diagnosticsDir, kcl.ProcessorsDir)
+}
+
+// resetProcessorsDir removes the registrations of the record processors of a
+// previous daemon.
+func (r *Runner) resetProcessorsDir() error {
+	if err := os.RemoveAll(r.processorsDir()); err != nil {
+		return errors.Wrap(err, "failed to clear record processor registrations")
+	}
+	if err := os.MkdirAll(r.processorsDir(), 0o755); err != nil {
+		return errors.Wrap(err, "failed to make diagnostics folder")
+	}
+	return nil
+}
+
+// diagnosticsDirs returns the folders diagnostics are written to.
+func (r *Runner) diagnosticsDirs() []string {
+	dirs := []string{r.diagnosticsDir}
+	if r.crashDiagnostics != nil {
+		for _, dir := range []string{r.crashDiagnostics.ErrorFileDir, r.crashDiagnostics.HeapDumpDir} {
+			if !contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// prepareDiagnostics makes the diagnostics folders and rotates the files in
+// them before a daemon is started.
+func (r *Runner) prepareDiagnostics() error {
+	if err := r.resetProcessorsDir(); err != nil {
+		return err
+	}
+	for _, dir := range r.diagnosticsDirs() {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return errors.Wrap(err, "failed to make diagnostics folder")
+		}
+	}
+
+	r.rotateDiagnostics()
+	return nil
+}
+
+// diagnosticsFile is a file in a diagnostics folder.
+type diagnosticsFile struct {
+	path string
+	info os.FileInfo
+}
+
+// rotateDiagnostics removes the files of the diagnostics folders beyond the
+// retention.
+func (r *Runner) rotateDiagnostics() {
+	kept := []diagnosticsFile{}
+	for _, pattern := range diagnosticsPatterns {
+		files := []diagnosticsFile{}
+		for _, dir := range r.diagnosticsDirs() {
+			files = append(files, listDiagnostics(dir, pattern)...)
+		}
+		sortNewestFirst(files)
+
+		for i, f := range files {
+			if i < r.diagnosticsKeep {
+				kept = append(kept, f)
+				continue
+			}
+			r.removeDiagnostics(f)
+		}
+	}
+
+	if r.diagnosticsMaxBytes <= 0 {
+		return
+	}
+
+	sortNewestFirst(kept)
+	var size int64
+	for i, f := range kept {
+		size += f.info.Size()
+		if i > 0 && size > r.diagnosticsMaxBytes {
+			r.removeDiagnostics(f)
+		}
+	}
+}
+
+func (r *Runner) removeDiagnostics(f diagnosticsFile) {
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		r.logger.Printf("Failed to remove old diagnostics file %s: %s.", f.path, err)
+		return
+	}
+	r.logger.Printf("Removed old diagnostics file %s.", f.path)
+}
+
+// listDiagnostics returns the files in dir that match pattern.
+func listDiagnostics(dir, pattern string) []diagnosticsFile {
+	paths, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil
+	}
+
+	files := []diagnosticsFile{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, diagnosticsFile{path, info})
+	}
+	return files
+}
+
+func sortNewestFirst(files []diagnosticsFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].info.ModTime().After(files[j].info.ModTime())
+	})
+}
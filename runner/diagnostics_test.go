@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRotateDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"threads-1.txt", 10, 3 * time.Hour},
+		{"threads-2.txt", 10, 2 * time.Hour},
+		{"threads-3.txt", 10, time.Hour},
+		{"hs_err_pid1.log", 10, 3 * time.Hour},
+		{"java_pid1.hprof", 100, 4 * time.Hour},
+		{"java_pid2.hprof", 100, 2 * time.Hour},
+		{"other.txt", 10, 5 * time.Hour},
+	}
+	now := time.Now()
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, make([]byte, f.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Runner{
+		logger:              log.New(io.Discard, "", 0),
+		diagnosticsDir:      dir,
+		diagnosticsKeep:     2,
+		diagnosticsMaxBytes: 150,
+	}
+	r.rotateDiagnostics()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := []string{}
+	for _, e := range entries {
+		remaining = append(remaining, e.Name())
+	}
+	sort.Strings(remaining)
+
+	// Only two thread dumps are kept, and the older heap dump does not fit
+	// into the size limit with the newer files.
+	expected := []string{"hs_err_pid1.log", "java_pid2.hprof", "other.txt", "threads-2.txt", "threads-3.txt"}
+	if len(remaining) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, remaining)
+	}
+	for i := range expected {
+		if remaining[i] != expected[i] {
+			t.Fatalf("expected %v but got %v", expected, remaining)
+		}
+	}
+}
+
+func TestGetRunner_RejectsDiagnosticsRetentionWithoutFiles(t *testing.T) {
+	base, _ := getTestRunner(t, "")
+	for _, keep := range []int{0, -1} {
+		_, err := GetRunner(
+			WithPathToJavaBinary(base.pathToJavaBinary),
+			WithPathToJarFolder(base.pathToJarFolder),
+			WithPathToPropertiesFile(base.pathToPropertiesFile),
+			WithDiagnosticsRetention(keep, 0),
+		)
+		if err == nil {
+			t.Errorf("expected an error for keeping %d files, but got nil", keep)
+		}
+	}
+}
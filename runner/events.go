@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventType is a kind of lifecycle event of the daemon.
+type EventType string
+
+const (
+	// EventLeaseAcquired is published for each lease the worker takes.
+	EventLeaseAcquired EventType = "LeaseAcquired"
+	// EventLeaseLost is published when the worker loses a lease.
+	EventLeaseLost EventType = "LeaseLost"
+	// EventShardSyncCompleted is published when the worker finished syncing
+	// the lease table with the shards of the stream.
+	EventShardSyncCompleted EventType = "ShardSyncCompleted"
+	// EventThrottled is published when Kinesis, DynamoDB or CloudWatch
+	// throttle a request of the worker.
+	EventThrottled EventType = "Throttled"
+	// EventWorkerShutdown is published when the worker starts shutting down.
+	EventWorkerShutdown EventType = "WorkerShutdown"
+	// EventChildProcessExited is published when a record processor exits.
+	EventChildProcessExited EventType = "ChildProcessExited"
+	// EventOutOfMemory is published when the JVM logs an OutOfMemoryError.
+	EventOutOfMemory EventType = "OutOfMemory"
+	// EventJVMCrashed is published by Supervise with crash diagnostics when
+	// the daemon crashed and left an error file or a heap dump behind, or
+	// logged an OutOfMemoryError. It is not recognized in a log line.
+	EventJVMCrashed EventType = "JVMCrashed"
+)
+
+// Event is a lifecycle event of the daemon recognized in its log output.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// ShardID is the shard of the lease events, if the log line names it.
+	ShardID string
+	// ExitCode is the exit code of EventChildProcessExited and
+	// EventJVMCrashed, -1 if the JVM was killed by a signal.
+	ExitCode int
+	// Line is the log line the event was recognized in.
+	Line string
+	// Cause summarizes why the JVM died for EventJVMCrashed, e.g. the signal
+	// and problematic frame of its error file.
+	Cause string
+	// Files are the error file and heap dump of EventJVMCrashed.
+	Files []string
+}
+
+// EventHandler is called for each event. It is called on the goroutines that
+// read the daemon's stdout and stderr, possibly concurrently, so it should
+// not block.
+type EventHandler func(Event)
+
+// eventPattern recognizes an event in a log line. The named groups shard and
+// code are the ShardID and ExitCode of the event. Patterns for several shards,
+// like the one for taken leases, set shards instead, a comma separated list.
+type eventPattern struct {
+	eventType EventType
+	pattern   *regexp.Regexp
+}
+
+// eventPatterns match the messages logged by KCL 3.x.
+var eventPatterns = []eventPattern{
+	{EventLeaseAcquired, regexp.MustCompile(`successfully took \d+ leases: (?P<shards>.*)$`)},
+	{EventLeaseLost, regexp.MustCompile(`lost lease with key (?P<shard>\S+)`)},
+	{EventShardSyncCompleted, regexp.MustCompile(`(?i)shard sync(?: task)? (?:completed|succeeded|finished)|ShardSyncTask.*(?:completed|finished)`)},
+	{EventThrottled, regexp.MustCompile(`ProvisionedThroughputExceededException|LimitExceededException|ThrottlingException|Rate exceeded`)},
+	{EventWorkerShutdown, regexp.MustCompile(`Worker shutdown requested|Starting worker's final shutdown|Worker loop is complete`)},
+	{EventChildProcessExited, regexp.MustCompile(`Child process exited with value: (?P<code>-?\d+)`)},
+	{EventOutOfMemory, regexp.MustCompile(`java\.lang\.OutOfMemoryError`)},
+}
+
+// WithEventHandler calls h for each lifecycle event recognized in the
+// daemon's output, in addition to any other handlers.
+func WithEventHandler(h EventHandler) Option {
+	return func(runner *Runner) {
+		runner.eventHandlers = append(runner.eventHandlers, h)
+	}
+}
+
+// WithEventPattern publishes an event of type t for each output line of the
+// daemon that matches pattern, in addition to the built in patterns. The
+// named groups shard and code set the ShardID and ExitCode of the event.
+func WithEventPattern(t EventType, pattern *regexp.Regexp) Option {
+	return func(runner *Runner) {
+		runner.eventPatterns = append(runner.eventPatterns, eventPattern{t, pattern})
+	}
+}
+
+// publishEvents calls the event handlers for each event recognized in line.
+func (r *Runner) publishEvents(line string) {
+	for _, p := range r.eventPatterns {
+		m := p.pattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		e := Event{Type: p.eventType, Time: time.Now(), Line: line}
+		shards := []string{""}
+		for i, name := range p.pattern.SubexpNames() {
+			switch name {
+			case "shard":
+				shards[0] = m[i]
+			case "shards":
+				shards = strings.Split(m[i], ",")
+			case "code":
+				e.ExitCode, _ = strconv.Atoi(m[i])
+			}
+		}
+
+		for _, shard := range shards {
+			e.ShardID = strings.TrimSpace(shard)
+			for _, h := range r.eventHandlers {
+				h(e)
+			}
+		}
+	}
+}
+
+// maxObservedLineLength bounds the memory used for a line without a newline.
+const maxObservedLineLength = 64 * 1024
+
+// lineObserver calls onLine with each line read through it.
+type lineObserver struct {
+	io.ReadCloser
+	partial []byte
+	onLine  func(string)
+}
+
+func (o *lineObserver) Read(p []byte) (int, error) {
+	n, err := o.ReadCloser.Read(p)
+
+	data := p[:n]
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		o.partial = append(o.partial, data[:i]...)
+		o.onLine(strings.TrimSuffix(string(o.partial), "\r"))
+		o.partial = o.partial[:0]
+		data = data[i+1:]
+	}
+	if len(o.partial)+len(data) <= maxObservedLineLength {
+		o.partial = append(o.partial, data...)
+	}
+
+	if err != nil && len(o.partial) > 0 {
+		o.onLine(string(o.partial))
+		o.partial = o.partial[:0]
+	}
+	return n, err
+}
+
+// observeLines returns getPipe with the event handlers and the watchdog
+// observing the lines read from the pipe.
+func (r *Runner) observeLines(getPipe func() (io.ReadCloser, error)) func() (io.ReadCloser, error) {
+	if len(r.eventHandlers) == 0 && r.watchdog == nil {
+		return getPipe
+	}
+
+	return func() (io.ReadCloser, error) {
+		pipe, err := getPipe()
+		if err != nil {
+			return nil, err
+		}
+		return &lineObserver{ReadCloser: pipe, onLine: r.observeLine}, nil
+	}
+}
+
+// observeLine records the activity of the daemon and publishes the events in
+// line.
+func (r *Runner) observeLine(line string) {
+	r.lastOutput.Store(time.Now().UnixNano())
+	r.outputCapture.line(line)
+	r.publishEvents(line)
+}
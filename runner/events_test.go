@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+func recordEvents(opts ...Option) (*Runner, *[]Event) {
+	events := &[]Event{}
+	mu := &sync.Mutex{}
+	r := &Runner{eventPatterns: append([]eventPattern(nil), eventPatterns...)}
+	WithEventHandler(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		*events = append(*events, e)
+	})(r)
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, events
+}
+
+func TestPublishEvents(t *testing.T) {
+	r, events := recordEvents(WithEventPattern("Checkpointed", regexp.MustCompile(`checkpointed (?P<shard>shardId-\d+)`)))
+
+	lines := []string{
+		"2024-11-04 10:02:03,123 [LeaseCoordinator-0001] INFO  s.a.k.l.d.DynamoDBLeaseTaker - Worker some-worker successfully took 2 leases: shardId-000000000001, shardId-000000000002",
+		"2024-11-04 10:02:04,123 [LeaseRenewer-0001] INFO  s.a.k.l.d.DynamoDBLeaseRenewer - Worker some-worker lost lease with key shardId-000000000003",
+		"2024-11-04 10:02:05,123 [main] WARN  s.a.k.r.p.PollingRecordsFetcher - ProvisionedThroughputExceededException: Rate exceeded for shard",
+		"2024-11-04 10:02:06,123 [pool-4-thread-1] INFO  s.a.k.m.MultiLangShardRecordProcessor - Child process exited with value: 137",
+		"2024-11-04 10:02:07,123 [main] INFO  s.a.k.m.MultiLangDaemon - checkpointed shardId-000000000004",
+		"2024-11-04 10:02:08,123 [main] INFO  s.a.k.c.Scheduler - Nothing to see here",
+	}
+	for _, line := range lines {
+		r.publishEvents(line)
+	}
+
+	expected := []Event{
+		{Type: EventLeaseAcquired, ShardID: "shardId-000000000001"},
+		{Type: EventLeaseAcquired, ShardID: "shardId-000000000002"},
+		{Type: EventLeaseLost, ShardID: "shardId-000000000003"},
+		{Type: EventThrottled},
+		{Type: EventChildProcessExited, ExitCode: 137},
+		{Type: "Checkpointed", ShardID: "shardId-000000000004"},
+	}
+	if len(*events) != len(expected) {
+		t.Fatalf("expected %d events but got %+v", len(expected), *events)
+	}
+	for i, e := range *events {
+		if e.Type != expected[i].Type || e.ShardID != expected[i].ShardID || e.ExitCode != expected[i].ExitCode {
+			t.Errorf("expected event %+v but got %+v", expected[i], e)
+		}
+		if e.Line == "" || e.Time.IsZero() {
+			t.Errorf("expected the event to have a line and time but got %+v", e)
+		}
+	}
+}
+
+func TestLineObserver_SplitsLinesAcrossReads(t *testing.T) {
+	lines := []string{}
+	o := &lineObserver{
+		ReadCloser: io.NopCloser(iotest.OneByteReader(strings.NewReader("first\r\nsecond\nlast"))),
+		onLine: func(line string) {
+			lines = append(lines, line)
+		},
+	}
+
+	data, err := io.ReadAll(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "first\r\nsecond\nlast" {
+		t.Errorf("expected the data to be passed through but got '%s'", data)
+	}
+	if strings.Join(lines, "|") != "first|second|last" {
+		t.Errorf("expected the lines first, second and last but got %v", lines)
+	}
+}
+
+func TestSupervise_PublishesEvents(t *testing.T) {
+	r, _ := getTestRunner(t, "echo 'Worker w lost lease with key shardId-000000000001'")
+	events := make(chan Event, 1)
+	WithEventHandler(func(e Event) {
+		events <- e
+	})(r)
+
+	if err := r.Supervise(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	select {
+	case e := <-events:
+		if e.Type != EventLeaseLost || e.ShardID != "shardId-000000000001" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	default:
+		t.Error("expected a lease lost event")
+	}
+}
@@ -0,0 +1,246 @@
+package runner
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithHealthServer serves the health endpoints of HealthHandler on addr,
+// e.g. :8080, while Supervise runs.
+func WithHealthServer(addr string) Option {
+	return func(runner *Runner) {
+		runner.healthAddr = addr
+	}
+}
+
+// WithReadinessWindow sets how long the daemon stays ready after it last
+// acquired a lease or completed a shard sync when it holds no lease and runs
+// no record processor. Defaults to 5m.
+func WithReadinessWindow(d time.Duration) Option {
+	return func(runner *Runner) {
+		runner.health.readinessWindow = d
+	}
+}
+
+// healthState tracks the daemon started by Supervise for the health
+// endpoints.
+type healthState struct {
+	mu sync.Mutex
+
+	readinessWindow time.Duration
+	started         time.Time
+
+	running            bool
+	pid                int
+	daemonStarted      time.Time
+	restarts           int
+	consecutiveCrashes int
+	lastExit           string
+	lastExitTime       time.Time
+	lastCrashCause     string
+
+	initialized  bool
+	shuttingDown bool
+	leases       map[string]bool
+	lastActivity time.Time
+}
+
+func newHealthState() *healthState {
+	return &healthState{
+		readinessWindow: 5 * time.Minute,
+		started:         time.Now(),
+		leases:          map[string]bool{},
+	}
+}
+
+// daemonStart records that a daemon process started.
+func (h *healthState) daemonStart(pid int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.daemonStarted.IsZero() {
+		h.restarts++
+	}
+	h.running = true
+	h.pid = pid
+	h.daemonStarted = time.Now()
+	h.initialized = false
+	h.shuttingDown = false
+	h.leases = map[string]bool{}
+}
+
+// daemonExit records that the daemon exited with err, and how many times in
+// a row it has crashed.
+func (h *healthState) daemonExit(err error, consecutiveCrashes int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.running = false
+	h.pid = 0
+	h.consecutiveCrashes = consecutiveCrashes
+	h.lastExit = "exited successfully"
+	if err != nil {
+		h.lastExit = err.Error()
+	}
+	h.lastExitTime = time.Now()
+	h.leases = map[string]bool{}
+}
+
+// observe updates the state from an event of the daemon.
+func (h *healthState) observe(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch e.Type {
+	case EventLeaseAcquired:
+		if e.ShardID != "" {
+			h.leases[e.ShardID] = true
+		}
+	case EventLeaseLost:
+		delete(h.leases, e.ShardID)
+		return
+	case EventShardSyncCompleted:
+	case EventWorkerShutdown:
+		h.shuttingDown = true
+		return
+	case EventJVMCrashed:
+		h.lastCrashCause = e.Cause
+		return
+	default:
+		return
+	}
+
+	h.initialized = true
+	h.lastActivity = e.Time
+}
+
+// HealthStatus is the state of the runner reported by the status endpoint.
+type HealthStatus struct {
+	Live  bool `json:"live"`
+	Ready bool `json:"ready"`
+
+	Uptime             string     `json:"uptime"`
+	DaemonRunning      bool       `json:"daemonRunning"`
+	DaemonPID          int        `json:"daemonPid,omitempty"`
+	DaemonUptime       string     `json:"daemonUptime,omitempty"`
+	Restarts           int        `json:"restarts"`
+	ConsecutiveCrashes int        `json:"consecutiveCrashes"`
+	LastExit           string     `json:"lastExit,omitempty"`
+	LastExitTime       *time.Time `json:"lastExitTime,omitempty"`
+	// LastCrashCause is the cause of the last crash found by the crash
+	// diagnostics.
+	LastCrashCause string `json:"lastCrashCause,omitempty"`
+
+	Initialized  bool       `json:"initialized"`
+	ShuttingDown bool       `json:"shuttingDown"`
+	Leases       []string   `json:"leases"`
+	LastActivity *time.Time `json:"lastActivity,omitempty"`
+	// ChildProcesses are the PIDs of the record processors the daemon runs.
+	// They are only known on Linux.
+	ChildProcesses []int `json:"childProcesses"`
+}
+
+// status returns the current state.
+func (h *healthState) status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	s := HealthStatus{
+		Uptime:             now.Sub(h.started).Round(time.Second).String(),
+		DaemonRunning:      h.running,
+		DaemonPID:          h.pid,
+		Restarts:           h.restarts,
+		ConsecutiveCrashes: h.consecutiveCrashes,
+		LastExit:           h.lastExit,
+		LastCrashCause:     h.lastCrashCause,
+		Initialized:        h.initialized,
+		ShuttingDown:       h.shuttingDown,
+		Leases:             []string{},
+		ChildProcesses:     []int{},
+	}
+	if !h.lastExitTime.IsZero() {
+		lastExitTime := h.lastExitTime
+		s.LastExitTime = &lastExitTime
+	}
+	if !h.lastActivity.IsZero() {
+		lastActivity := h.lastActivity
+		s.LastActivity = &lastActivity
+	}
+	if h.running {
+		s.DaemonUptime = now.Sub(h.daemonStarted).Round(time.Second).String()
+		if pids, err := childPIDs(h.pid); err == nil {
+			s.ChildProcesses = pids
+		}
+	}
+	for shard := range h.leases {
+		s.Leases = append(s.Leases, shard)
+	}
+	sort.Strings(s.Leases)
+
+	// A single crash is being restarted, while several in a row are a crash
+	// loop.
+	s.Live = h.running || h.consecutiveCrashes <= 1
+
+	recentlyActive := !h.lastActivity.IsZero() && now.Sub(h.lastActivity) < h.readinessWindow
+	s.Ready = h.running && h.initialized && !h.shuttingDown &&
+		(len(s.Leases) > 0 || len(s.ChildProcesses) > 0 || recentlyActive)
+
+	return s
+}
+
+// HealthHandler returns a handler for the health endpoints of the daemon run
+// by Supervise:
+//
+//   - /healthz responds 200 while the daemon runs or is restarted after a
+//     single crash, and 503 once it is crash looping.
+//   - /readyz responds 200 once the daemon acquired a lease or completed a
+//     shard sync, as long as it holds a lease, runs a record processor or did
+//     either within the readiness window, and 503 otherwise.
+//   - /status responds with the HealthStatus as JSON.
+func (r *Runner) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		writeProbe(w, r.health.status().Live)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		writeProbe(w, r.health.status().Ready)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.health.status())
+	})
+	return mux
+}
+
+func writeProbe(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "not ok", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// serveHealth serves the health endpoints on the health address. It returns
+// once the server is listening, with a function that stops it.
+func (r *Runner) serveHealth() (func(), error) {
+	listener, err := net.Listen("tcp", r.healthAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen for health endpoints")
+	}
+
+	server := &http.Server{
+		Handler:           r.HealthHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go server.Serve(listener)
+	r.logger.Printf("Serving health endpoints on %s.", listener.Addr())
+
+	return func() { server.Close() }, nil
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func getHealth(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestHealthState(t *testing.T) {
+	h := newHealthState()
+	if s := h.status(); !s.Live || s.Ready {
+		t.Errorf("expected a runner that did not start the daemon yet to be live but not ready, got %+v", s)
+	}
+
+	// A PID that does not exist, so that it has no children.
+	h.daemonStart(1 << 30)
+	h.observe(Event{Type: EventLeaseAcquired, ShardID: "shardId-1", Time: time.Now()})
+	if s := h.status(); !s.Ready || len(s.Leases) != 1 {
+		t.Errorf("expected a daemon with a lease to be ready, got %+v", s)
+	}
+
+	h.observe(Event{Type: EventLeaseLost, ShardID: "shardId-1", Time: time.Now()})
+	h.lastActivity = time.Now().Add(-time.Hour)
+	if s := h.status(); s.Ready {
+		t.Errorf("expected a daemon without leases or recent activity not to be ready, got %+v", s)
+	}
+
+	h.daemonExit(errors.New("exited with code 1"), 1)
+	if s := h.status(); !s.Live || s.Ready {
+		t.Errorf("expected a daemon restarting after one crash to be live but not ready, got %+v", s)
+	}
+
+	h.daemonStart(2)
+	h.daemonExit(errors.New("exited with code 1"), 2)
+	if s := h.status(); s.Live || s.Restarts != 1 {
+		t.Errorf("expected a crash looping daemon not to be live, got %+v", s)
+	}
+}
+
+func TestSupervise_ReportsHealth(t *testing.T) {
+	r, _ := getTestRunner(t, "sleep 10 >/dev/null 2>&1 &\n"+
+		"echo 'Worker w successfully took 1 leases: shardId-000000000001'\n"+
+		"exec sleep 10")
+	handler := r.HealthHandler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.Supervise(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for getHealth(handler, "/readyz").Code != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the daemon to become ready, status %s", getHealth(handler, "/status").Body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var s HealthStatus
+	if err := json.Unmarshal(getHealth(handler, "/status").Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.DaemonRunning || len(s.Leases) != 1 || s.Leases[0] != "shardId-000000000001" {
+		t.Errorf("expected a running daemon with one lease but got %+v", s)
+	}
+	if runtime.GOOS == "linux" && len(s.ChildProcesses) != 1 {
+		t.Errorf("expected the background sleep as the only child but got %v", s.ChildProcesses)
+	}
+
+	cancel()
+	<-done
+	if code := getHealth(handler, "/readyz").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("expected a stopped daemon not to be ready but got %d", code)
+	}
+}
@@ -0,0 +1,291 @@
+package runner
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// minimumJavaVersion is the oldest Java release supported by KCL 2.x and 3.x.
+const minimumJavaVersion = 8
+
+// javaInstallGlobs are common install locations searched for a java binary
+// when neither JAVA_HOME nor PATH has one.
+var javaInstallGlobs = []string{
+	"/usr/lib/jvm/*/bin/java",
+	"/usr/java/*/bin/java",
+	"/opt/java/*/bin/java",
+	"/usr/local/opt/openjdk*/bin/java",
+	"/opt/homebrew/opt/openjdk*/bin/java",
+	"/Library/Java/JavaVirtualMachines/*/Contents/Home/bin/java",
+}
+
+// JavaVersion is the vendor and version reported by a java binary.
+type JavaVersion struct {
+	Vendor string
+	// Version is the full version, e.g. 1.8.0_292 or 17.0.2.
+	Version string
+	// Major is the feature release, e.g. 8 or 17.
+	Major int
+}
+
+func (v JavaVersion) String() string {
+	if v.Vendor == "" {
+		return "Java " + v.Version
+	}
+	return v.Vendor + " Java " + v.Version
+}
+
+// FindJava returns the path to a java binary. It looks in JAVA_HOME, then in
+// PATH and then in common install locations, where it picks the newest
+// release.
+func FindJava() (string, error) {
+	java := "java"
+	if runtime.GOOS == "windows" {
+		java = "java.exe"
+	}
+
+	if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" {
+		path := filepath.Join(javaHome, "bin", java)
+		if isExecutable(path) {
+			return path, nil
+		}
+		return "", errors.Errorf("JAVA_HOME is set to %s but %s is not an executable", javaHome, path)
+	}
+
+	if path, err := exec.LookPath(java); err == nil {
+		return path, nil
+	}
+
+	best := ""
+	bestMajor := 0
+	for _, pattern := range javaInstallGlobs {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			if !isExecutable(path) {
+				continue
+			}
+			v, err := GetJavaVersion(path)
+			if err != nil {
+				continue
+			}
+			if v.Major > bestMajor {
+				best = path
+				bestMajor = v.Major
+			}
+		}
+	}
+	if best == "" {
+		return "", errors.New("failed to find java, set JAVA_HOME or pass the path to the java binary")
+	}
+
+	return best, nil
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
+
+var javaVersionPattern = regexp.MustCompile(`version "([^"]+)"`)
+
+// GetJavaVersion runs java -version with the java binary at path and parses
+// its vendor and version.
+func GetJavaVersion(path string) (*JavaVersion, error) {
+	// The properties give the vendor, which the version banner only hints
+	// at. Both are printed to stderr.
+	output, err := exec.Command(path, "-XshowSettings:properties", "-version").CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to run %s -version: %s", path, bytes.TrimSpace(output))
+	}
+
+	return parseJavaVersion(string(output))
+}
+
+func parseJavaVersion(output string) (*JavaVersion, error) {
+	v := &JavaVersion{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value := splitSetting(line)
+		switch key {
+		case "java.vendor":
+			v.Vendor = value
+		case "java.version":
+			v.Version = value
+		}
+		if m := javaVersionPattern.FindStringSubmatch(line); m != nil && v.Version == "" {
+			v.Version = m[1]
+		}
+	}
+
+	if v.Version == "" {
+		return nil, errors.Errorf("failed to find the version in java -version output '%s'", strings.TrimSpace(output))
+	}
+
+	major, err := parseJavaMajorVersion(v.Version)
+	if err != nil {
+		return nil, err
+	}
+	v.Major = major
+
+	return v, nil
+}
+
+func splitSetting(line string) (string, string) {
+	i := strings.Index(line, " = ")
+	if i < 0 {
+		return "", ""
+	}
+	return line[:i], line[i+3:]
+}
+
+// parseJavaMajorVersion returns the feature release of a version, which is
+// the second number for versions before Java 9, e.g. 1.8.0_292.
+func parseJavaMajorVersion(version string) (int, error) {
+	parts := strings.FieldsFunc(version, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == '+'
+	})
+	if len(parts) > 1 && parts[0] == "1" {
+		parts = parts[1:]
+	}
+
+	if len(parts) == 0 {
+		return 0, errors.Errorf("invalid java version '%s'", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errors.Errorf("invalid java version '%s'", version)
+	}
+	return major, nil
+}
+
+// RequiredJavaVersion returns the oldest Java release that can run the KCL
+// jars in jarFolder, found from the class file version of their classes.
+func RequiredJavaVersion(jarFolder string) (int, error) {
+	jarPaths, err := getJarPaths(jarFolder, false)
+	if err != nil {
+		return 0, err
+	}
+
+	return requiredJavaVersion(jarPaths)
+}
+
+func requiredJavaVersion(jarPaths []string) (int, error) {
+	required := minimumJavaVersion
+	for _, jarPath := range jarPaths {
+		if !strings.HasPrefix(filepath.Base(jarPath), "amazon-kinesis-client") {
+			continue
+		}
+
+		major, err := jarJavaVersion(jarPath)
+		if err != nil {
+			return 0, err
+		}
+		if major > required {
+			required = major
+		}
+	}
+
+	return required, nil
+}
+
+// jarJavaVersion returns the newest Java release targeted by a class in the
+// jar at path. Classes for newer releases in multi-release jars are ignored,
+// since older releases use the base classes.
+func jarJavaVersion(path string) (int, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open jar %s", path)
+	}
+	defer r.Close()
+
+	newest := 0
+	header := make([]byte, 8)
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, ".class") || strings.HasPrefix(f.Name, "META-INF/") ||
+			strings.HasSuffix(f.Name, "module-info.class") {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read %s in jar %s", f.Name, path)
+		}
+		_, err = io.ReadFull(rc, header)
+		rc.Close()
+		if err != nil || binary.BigEndian.Uint32(header) != 0xCAFEBABE {
+			return 0, errors.Errorf("invalid class file %s in jar %s", f.Name, path)
+		}
+
+		// Class file major version 52 is Java 8, 53 is Java 9 and so on.
+		if major := int(binary.BigEndian.Uint16(header[6:])) - 44; major > newest {
+			newest = major
+		}
+	}
+
+	return newest, nil
+}
+
+// JavaVersion returns the version of the java binary after checking that it
+// can run the jars in the jar folder.
+func (r *Runner) JavaVersion() (*JavaVersion, error) {
+	if err := r.checkJavaVersion(); err != nil {
+		return nil, err
+	}
+	return r.javaVersion, nil
+}
+
+// CheckJavaVersion returns the version of the java binary at javaPath after
+// checking that it can run the KCL jars among jarPaths, which are in
+// jarFolder.
+func CheckJavaVersion(javaPath, jarFolder string, jarPaths []string) (*JavaVersion, error) {
+	v, err := GetJavaVersion(javaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	required, err := requiredJavaVersion(jarPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Major < required {
+		return nil, errors.Errorf("%s at %s is too old, the KCL jars in %s need Java %d or newer",
+			v, javaPath, jarFolder, required)
+	}
+	return v, nil
+}
+
+// checkJavaVersion checks that the java binary can run the jars in the jar
+// folder.
+func (r *Runner) checkJavaVersion() error {
+	if r.javaVersion != nil {
+		return nil
+	}
+
+	jarPaths, err := r.JarPaths()
+	if err != nil {
+		return err
+	}
+
+	v, err := CheckJavaVersion(r.pathToJavaBinary, r.pathToJarFolder, jarPaths)
+	if err != nil {
+		return err
+	}
+
+	r.logger.Printf("Using %s at %s.", v, r.pathToJavaBinary)
+	r.javaVersion = v
+	return nil
+}
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseJavaVersion(t *testing.T) {
+	tests := map[string]JavaVersion{
+		"Property settings:\n    java.vendor = Amazon.com Inc.\n    java.version = 17.0.2\n\n" +
+			"openjdk version \"17.0.2\" 2022-01-18 LTS\n": {Vendor: "Amazon.com Inc.", Version: "17.0.2", Major: 17},
+		"java version \"1.8.0_292\"\nJava(TM) SE Runtime Environment (build 1.8.0_292-b10)\n": {Version: "1.8.0_292", Major: 8},
+		"openjdk version \"21\" 2023-09-19\n":                                                 {Version: "21", Major: 21},
+		"openjdk version \"11.0.20+8\"\n":                                                     {Version: "11.0.20+8", Major: 11},
+	}
+
+	for output, expected := range tests {
+		v, err := parseJavaVersion(output)
+		if err != nil {
+			t.Errorf("unexpected error: %+v", err)
+			continue
+		}
+		if *v != expected {
+			t.Errorf("expected %+v but got %+v", expected, *v)
+		}
+	}
+
+	if _, err := parseJavaVersion("bash: java: command not found"); err == nil {
+		t.Error("expected an error, but got nil")
+	}
+}
+
+// writeTestJar writes a jar with a class file of the given class file major
+// version.
+func writeTestJar(t *testing.T, path string, classVersion byte) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	files := map[string]byte{
+		"software/amazon/kinesis/Some.class":       classVersion,
+		"META-INF/versions/21/software/Some.class": 65,
+	}
+	for name, version := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte{0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, version})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequiredJavaVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeTestJar(t, filepath.Join(dir, "amazon-kinesis-client-3.0.3.jar"), 55)
+	writeTestJar(t, filepath.Join(dir, "some-other-library-1.0.jar"), 61)
+
+	required, err := RequiredJavaVersion(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if required != 11 {
+		t.Errorf("expected Java 11 to be required but got %d", required)
+	}
+}
+
+func TestCheckJavaVersion_FailsForOldJava(t *testing.T) {
+	dir := t.TempDir()
+	writeTestJar(t, filepath.Join(dir, "amazon-kinesis-client-multilang-3.0.3.jar"), 55)
+
+	java := filepath.Join(dir, "java")
+	err := os.WriteFile(java, []byte("#!/bin/sh\necho 'java version \"1.8.0_292\"' >&2\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{
+		logger:           log.New(io.Discard, "", 0),
+		pathToJavaBinary: java,
+		pathToJarFolder:  dir,
+	}
+	err = r.checkJavaVersion()
+	if err == nil || !strings.Contains(err.Error(), "need Java 11 or newer") {
+		t.Errorf("expected an error about the java version but got %+v", err)
+	}
+}
+
+func TestFindJava_UsesJavaHome(t *testing.T) {
+	javaHome := t.TempDir()
+	if err := os.Mkdir(filepath.Join(javaHome, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	java := filepath.Join(javaHome, "bin", "java")
+	if err := os.WriteFile(java, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JAVA_HOME", javaHome)
+
+	path, err := FindJava()
+	if err != nil || path != java {
+		t.Errorf("expected %s but got %s, %+v", java, path, err)
+	}
+
+	t.Setenv("JAVA_HOME", t.TempDir())
+	if _, err = FindJava(); err == nil || !strings.Contains(err.Error(), "JAVA_HOME") {
+		t.Errorf("expected an error about JAVA_HOME but got %+v", err)
+	}
+}
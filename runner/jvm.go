@@ -0,0 +1,241 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GarbageCollector is a garbage collector of the JVM.
+type GarbageCollector string
+
+const (
+	G1GC         GarbageCollector = "G1"
+	ParallelGC   GarbageCollector = "Parallel"
+	SerialGC     GarbageCollector = "Serial"
+	ZGC          GarbageCollector = "Z"
+	ShenandoahGC GarbageCollector = "Shenandoah"
+)
+
+// garbageCollectors are the flags selecting each garbage collector and the
+// first Java release where it is production ready.
+var garbageCollectors = map[GarbageCollector]struct {
+	flag       string
+	minVersion int
+}{
+	G1GC:         {"-XX:+UseG1GC", 8},
+	ParallelGC:   {"-XX:+UseParallelGC", 8},
+	SerialGC:     {"-XX:+UseSerialGC", 8},
+	ZGC:          {"-XX:+UseZGC", 15},
+	ShenandoahGC: {"-XX:+UseShenandoahGC", 12},
+}
+
+// javaToolOptionsEnv is read by the JVM for options that come before the ones
+// on the command line.
+const javaToolOptionsEnv = "JAVA_TOOL_OPTIONS"
+
+var heapSizePattern = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+// JavaAgent is a -javaagent option.
+type JavaAgent struct {
+	// Path is the path to the agent's jar.
+	Path string
+	// Options are passed to the agent after the path, separated by '='.
+	Options string
+}
+
+// JVMOptions configures the JVM that runs the daemon. Zero values leave the
+// JVM defaults in place.
+type JVMOptions struct {
+	// InitialHeapSize and MaxHeapSize are -Xms and -Xmx, e.g. 512m or 2g.
+	InitialHeapSize string
+	MaxHeapSize     string
+
+	// InitialRAMPercentage and MaxRAMPercentage size the heap as a
+	// percentage of the memory available to the JVM, which is the
+	// container's memory limit when running in a container. They can not be
+	// combined with the fixed heap sizes.
+	InitialRAMPercentage float64
+	MaxRAMPercentage     float64
+
+	GarbageCollector GarbageCollector
+
+	// SystemProperties are passed as -Dkey=value.
+	SystemProperties map[string]string
+
+	Agents []JavaAgent
+
+	// JavaToolOptions are merged into the JAVA_TOOL_OPTIONS environment
+	// variable inherited by the runner, replacing inherited options for the
+	// same flag or system property.
+	JavaToolOptions []string
+
+	// Args are passed to the JVM after the other options.
+	Args []string
+}
+
+// Validate returns an error if the options are inconsistent.
+func (o *JVMOptions) Validate() error {
+	for name, size := range map[string]string{"initial heap size": o.InitialHeapSize, "max heap size": o.MaxHeapSize} {
+		if size != "" && !heapSizePattern.MatchString(size) {
+			return errors.Errorf("invalid %s '%s'", name, size)
+		}
+	}
+
+	for name, percentage := range map[string]float64{"initial RAM percentage": o.InitialRAMPercentage, "max RAM percentage": o.MaxRAMPercentage} {
+		if percentage < 0 || percentage > 100 {
+			return errors.Errorf("%s must be between 0 and 100", name)
+		}
+	}
+
+	if (o.InitialHeapSize != "" || o.MaxHeapSize != "") && (o.InitialRAMPercentage != 0 || o.MaxRAMPercentage != 0) {
+		return errors.New("heap sizes and RAM percentages can not be combined")
+	}
+
+	if o.GarbageCollector != "" {
+		if _, ok := garbageCollectors[o.GarbageCollector]; !ok {
+			return errors.Errorf("unknown garbage collector '%s'", o.GarbageCollector)
+		}
+	}
+
+	for key := range o.SystemProperties {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			return errors.Errorf("invalid system property '%s'", key)
+		}
+	}
+
+	for _, agent := range o.Agents {
+		if agent.Path == "" {
+			return errors.New("missing java agent path")
+		}
+	}
+
+	return nil
+}
+
+// args returns the command line options for a JVM of the given major
+// version, or of any version if it is 0.
+func (o *JVMOptions) args(javaMajorVersion int) ([]string, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	if o.InitialHeapSize != "" {
+		args = append(args, "-Xms"+o.InitialHeapSize)
+	}
+	if o.MaxHeapSize != "" {
+		args = append(args, "-Xmx"+o.MaxHeapSize)
+	}
+	if o.InitialRAMPercentage != 0 {
+		args = append(args, fmt.Sprintf("-XX:InitialRAMPercentage=%.1f", o.InitialRAMPercentage))
+	}
+	if o.MaxRAMPercentage != 0 {
+		args = append(args, fmt.Sprintf("-XX:MaxRAMPercentage=%.1f", o.MaxRAMPercentage))
+	}
+
+	if o.GarbageCollector != "" {
+		gc := garbageCollectors[o.GarbageCollector]
+		if javaMajorVersion != 0 && javaMajorVersion < gc.minVersion {
+			return nil, errors.Errorf("the %s garbage collector needs Java %d or newer", o.GarbageCollector, gc.minVersion)
+		}
+		args = append(args, gc.flag)
+	}
+
+	for _, agent := range o.Agents {
+		arg := "-javaagent:" + agent.Path
+		if agent.Options != "" {
+			arg += "=" + agent.Options
+		}
+		args = append(args, arg)
+	}
+
+	keys := make([]string, 0, len(o.SystemProperties))
+	for key := range o.SystemProperties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-D"+key+"="+o.SystemProperties[key])
+	}
+
+	return append(args, o.Args...), nil
+}
+
+// javaToolOptions merges the JavaToolOptions into inherited, the current
+// value of JAVA_TOOL_OPTIONS. It returns inherited unchanged when there are no
+// JavaToolOptions.
+func (o *JVMOptions) javaToolOptions(inherited string) string {
+	if len(o.JavaToolOptions) == 0 {
+		return inherited
+	}
+
+	overridden := map[string]bool{}
+	for _, opt := range o.JavaToolOptions {
+		overridden[jvmOptionKey(opt)] = true
+	}
+
+	merged := []string{}
+	for _, opt := range strings.Fields(inherited) {
+		if !overridden[jvmOptionKey(opt)] {
+			merged = append(merged, opt)
+		}
+	}
+
+	return strings.Join(append(merged, o.JavaToolOptions...), " ")
+}
+
+var jvmOptionKeyPattern = regexp.MustCompile(`^(-Xm[sx]|-Xss|-XX:[+-]?[A-Za-z0-9]+|-D[^=]+|-javaagent:[^=]+|[^=:]+)`)
+
+// jvmOptionKey returns the part of a JVM option that identifies it, so that
+// e.g. -Xmx1g and -Xmx2g or -XX:+UseG1GC and -XX:-UseG1GC have the same key.
+func jvmOptionKey(opt string) string {
+	key := jvmOptionKeyPattern.FindString(opt)
+	if strings.HasPrefix(key, "-XX:") {
+		key = "-XX:" + strings.TrimLeft(key[4:], "+-")
+	}
+	return key
+}
+
+// CommandLine returns the command that starts the daemon, including the
+// JAVA_TOOL_OPTIONS it is started with if any, quoted for a POSIX shell. It
+// is meant for debugging and does not check the java binary.
+func (r *Runner) CommandLine(javaProperties ...string) (string, error) {
+	args, err := r.javaArgs(javaProperties)
+	if err != nil {
+		return "", err
+	}
+
+	words := []string{}
+	if env := r.javaToolOptionsEnv(); env != "" {
+		words = append(words, shellQuote(env))
+	}
+	words = append(words, shellQuote(r.pathToJavaBinary))
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// javaToolOptionsEnv returns the JAVA_TOOL_OPTIONS environment variable to
+// start the daemon with, or an empty string to inherit the runner's.
+func (r *Runner) javaToolOptionsEnv() string {
+	if r.jvmOptions == nil || len(r.jvmOptions.JavaToolOptions) == 0 {
+		return ""
+	}
+	return javaToolOptionsEnv + "=" + r.jvmOptions.javaToolOptions(os.Getenv(javaToolOptionsEnv))
+}
+
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+func shellQuote(s string) string {
+	if shellSafePattern.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJVMOptionsArgs(t *testing.T) {
+	o := &JVMOptions{
+		MaxRAMPercentage: 75,
+		GarbageCollector: G1GC,
+		SystemProperties: map[string]string{"b": "2", "a": "1"},
+		Agents:           []JavaAgent{{Path: "/opt/agent.jar", Options: "service=kcl"}},
+		Args:             []string{"-XX:+ExitOnOutOfMemoryError"},
+	}
+
+	args, err := o.args(17)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := "-XX:MaxRAMPercentage=75.0 -XX:+UseG1GC -javaagent:/opt/agent.jar=service=kcl -Da=1 -Db=2 -XX:+ExitOnOutOfMemoryError"
+	if strings.Join(args, " ") != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, strings.Join(args, " "))
+	}
+
+	o = &JVMOptions{GarbageCollector: ZGC}
+	if _, err = o.args(11); err == nil || !strings.Contains(err.Error(), "needs Java 15") {
+		t.Errorf("expected an error about the Java version but got %+v", err)
+	}
+}
+
+func TestJVMOptionsValidate_ReturnsErrors(t *testing.T) {
+	tests := map[string]*JVMOptions{
+		"invalid max heap size":                    {MaxHeapSize: "2 GB"},
+		"can not be combined":                      {MaxHeapSize: "2g", MaxRAMPercentage: 50},
+		"max RAM percentage must be between":       {MaxRAMPercentage: 150},
+		"unknown garbage collector 'CMS'":          {GarbageCollector: "CMS"},
+		"invalid system property 'a=b'":            {SystemProperties: map[string]string{"a=b": "c"}},
+		"missing java agent path":                  {Agents: []JavaAgent{{Options: "x"}}},
+		"initial RAM percentage must be between 0": {InitialRAMPercentage: -1},
+	}
+
+	for expected, o := range tests {
+		err := o.Validate()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error containing '%s' but got %+v", expected, err)
+		}
+	}
+}
+
+func TestJVMOptionsJavaToolOptions_OverridesInheritedOptions(t *testing.T) {
+	o := &JVMOptions{JavaToolOptions: []string{"-Xmx1g", "-XX:-UseContainerSupport", "-Dfoo=new"}}
+
+	merged := o.javaToolOptions("-javaagent:/dd.jar -Xmx512m -XX:+UseContainerSupport -Dfoo=old -Dfoobar=1")
+
+	expected := "-javaagent:/dd.jar -Dfoobar=1 -Xmx1g -XX:-UseContainerSupport -Dfoo=new"
+	if merged != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, merged)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	r, _ := getTestRunner(t, "")
+	r.jvmOptions = &JVMOptions{
+		MaxHeapSize:      "1g",
+		SystemProperties: map[string]string{"some.property": "it's here"},
+		JavaToolOptions:  []string{"-Xss1m"},
+	}
+	t.Setenv(javaToolOptionsEnv, "")
+
+	commandLine, err := r.CommandLine("-Dother=1")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expectedPrefix := "JAVA_TOOL_OPTIONS=-Xss1m " + r.pathToJavaBinary + ` -Xmx1g '-Dsome.property=it'\''s here' -Dother=1 -cp `
+	if !strings.HasPrefix(commandLine, expectedPrefix) {
+		t.Errorf("expected the command line to start with\n%s\nbut got\n%s", expectedPrefix, commandLine)
+	}
+	if !strings.HasSuffix(commandLine, " software.amazon.kinesis.multilang.MultiLangDaemon "+r.pathToPropertiesFile) {
+		t.Errorf("expected the command line to end with the daemon class and properties file but got\n%s", commandLine)
+	}
+}
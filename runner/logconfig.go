@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultLogPattern is the logback pattern of the generated logging
+// configuration. The structured log parsing of WithStdoutLogHandler
+// understands it.
+const DefaultLogPattern = "%d{yyyy-MM-dd HH:mm:ss,SSS} [%thread] %-5level %logger{36} - %msg%n"
+
+var logbackLevels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "ALL", "OFF"}
+
+// LogConfig is the logging configuration of the daemon, which logs with
+// logback.
+type LogConfig struct {
+	// RootLevel is the level of the root logger. Defaults to INFO.
+	RootLevel string
+	// Levels are the levels of loggers by name, e.g.
+	// "software.amazon.kinesis": "DEBUG".
+	Levels map[string]string
+	// Pattern is the logback pattern of log lines. Defaults to
+	// DefaultLogPattern.
+	Pattern string
+}
+
+// WithLogConfig starts the daemon with a logback configuration generated from
+// c instead of the one on the classpath. Call Close to remove the generated
+// file.
+func WithLogConfig(c *LogConfig) Option {
+	return func(runner *Runner) {
+		runner.logConfig = c
+	}
+}
+
+// Validate returns an error if a level is not a logback level.
+func (c *LogConfig) Validate() error {
+	if c.RootLevel != "" && !isLogbackLevel(c.RootLevel) {
+		return errors.Errorf("invalid root log level '%s'", c.RootLevel)
+	}
+
+	for name, level := range c.Levels {
+		if name == "" {
+			return errors.New("missing logger name")
+		}
+		if !isLogbackLevel(level) {
+			return errors.Errorf("invalid log level '%s' for %s", level, name)
+		}
+	}
+	return nil
+}
+
+func isLogbackLevel(level string) bool {
+	return contains(logbackLevels, strings.ToUpper(level))
+}
+
+// Logback returns the logback XML configuration. Everything is logged to
+// stdout.
+func (c *LogConfig) Logback() ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	rootLevel := "INFO"
+	if c.RootLevel != "" {
+		rootLevel = strings.ToUpper(c.RootLevel)
+	}
+	pattern := c.Pattern
+	if pattern == "" {
+		pattern = DefaultLogPattern
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	b.WriteString("<!-- Generated by runner.LogConfig -->\n")
+	b.WriteString("<configuration>\n")
+	b.WriteString("  <appender name=\"STDOUT\" class=\"ch.qos.logback.core.ConsoleAppender\">\n")
+	b.WriteString("    <encoder>\n      <pattern>" + escapeXML(pattern) + "</pattern>\n    </encoder>\n")
+	b.WriteString("  </appender>\n")
+
+	names := make([]string, 0, len(c.Levels))
+	for name := range c.Levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString("  <logger name=\"" + escapeXML(name) + "\" level=\"" + strings.ToUpper(c.Levels[name]) + "\"/>\n")
+	}
+
+	b.WriteString("  <root level=\"" + rootLevel + "\">\n    <appender-ref ref=\"STDOUT\"/>\n  </root>\n")
+	b.WriteString("</configuration>\n")
+
+	return b.Bytes(), nil
+}
+
+func escapeXML(s string) string {
+	b := &strings.Builder{}
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+// WriteTempFile writes the logback configuration to a new temporary file and
+// returns its path. The caller is responsible for removing it.
+func (c *LogConfig) WriteTempFile() (string, error) {
+	data, err := c.Logback()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "kcl-logback-*.xml")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create logback configuration file")
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "failed to write logback configuration file")
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "failed to close logback configuration file")
+	}
+
+	return f.Name(), nil
+}
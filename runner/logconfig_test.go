@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogConfigLogback(t *testing.T) {
+	c := &LogConfig{
+		RootLevel: "warn",
+		Levels: map[string]string{
+			"software.amazon.kinesis": "DEBUG",
+			"io.netty":                "error",
+		},
+	}
+
+	data, err := c.Logback()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []string{
+		"<pattern>" + DefaultLogPattern + "</pattern>",
+		`<logger name="io.netty" level="ERROR"/>
+  <logger name="software.amazon.kinesis" level="DEBUG"/>`,
+		`<root level="WARN">`,
+	}
+	for _, s := range expected {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("expected the configuration to contain '%s' but got\n%s", s, data)
+		}
+	}
+
+	if _, err = (&LogConfig{Levels: map[string]string{"a": "LOUD"}}).Logback(); err == nil {
+		t.Error("expected an error for an invalid level, but got nil")
+	}
+}
+
+func TestDefaultLogPattern_IsParsed(t *testing.T) {
+	// The line logback writes with DefaultLogPattern.
+	line := "2024-11-04 10:02:03,123 [main] INFO  s.a.k.multilang.MultiLangDaemon - Starting"
+
+	e, ok := parseLogLine(line, time.Now())
+	if !ok || e.message != "Starting" || e.logger != "s.a.k.multilang.MultiLangDaemon" {
+		t.Errorf("expected the line to be parsed but got %+v", e)
+	}
+}
+
+func TestGetRunner_GeneratesLogConfig(t *testing.T) {
+	base, _ := getTestRunner(t, "")
+	r, err := GetRunner(
+		WithPathToJavaBinary(base.pathToJavaBinary),
+		WithPathToJarFolder(base.pathToJarFolder),
+		WithPathToPropertiesFile(base.pathToPropertiesFile),
+		WithLogConfig(&LogConfig{RootLevel: "DEBUG"}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	commandLine, err := r.CommandLine()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if !strings.Contains(commandLine, " -Dlogback.configurationFile="+r.logConfigFile+" ") {
+		t.Errorf("expected the command line to point at %s but got %s", r.logConfigFile, commandLine)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if _, err = os.Stat(r.logConfigFile); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove the logback configuration")
+	}
+}
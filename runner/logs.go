@@ -0,0 +1,203 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StreamLogConfig configures how an output stream of the daemon is logged
+// with slog.
+type StreamLogConfig struct {
+	Handler slog.Handler
+	// DefaultLevel is the level of lines that are not logback log lines,
+	// e.g. messages printed by the JVM itself.
+	DefaultLevel slog.Level
+}
+
+// WithStdoutLogHandler logs the daemon's stdout through a slog handler
+// instead of the logger, parsing each logback log line into its level, time,
+// logger, thread and message.
+func WithStdoutLogHandler(c StreamLogConfig) Option {
+	return func(runner *Runner) {
+		runner.stdoutLog = &c
+	}
+}
+
+// WithStderrLogHandler does the same as WithStdoutLogHandler for the daemon's
+// stderr.
+func WithStderrLogHandler(c StreamLogConfig) Option {
+	return func(runner *Runner) {
+		runner.stderrLog = &c
+	}
+}
+
+// continuationTimeout is how long a log event waits for more stack trace
+// lines before it is logged.
+var continuationTimeout = 100 * time.Millisecond
+
+// logLinePattern matches the log lines of the MultiLangDaemon's logback
+// configuration, e.g.
+// 2024-11-05 10:02:03,123 [main] INFO  s.a.k.multilang.MultiLangDaemon - Starting
+// or the logback default of 10:02:03.123 [main] INFO s.a.k.c.Scheduler -- Starting.
+var logLinePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[.,]\d{1,9})?|\d{2}:\d{2}:\d{2}[.,]\d{3})\s+\[([^\]]*)\]\s+(TRACE|DEBUG|INFO|WARN|ERROR)\s+(\S+)\s+--?\s?(.*)$`)
+
+// stackTracePattern matches the lines of a stack trace after the first one.
+var stackTracePattern = regexp.MustCompile(`^(\s+|Caused by: |Suppressed: |\.\.\. \d+ more)`)
+
+var logLevels = map[string]slog.Level{
+	"TRACE": slog.LevelDebug - 4,
+	"DEBUG": slog.LevelDebug,
+	"INFO":  slog.LevelInfo,
+	"WARN":  slog.LevelWarn,
+	"ERROR": slog.LevelError,
+}
+
+// logEvent is a log line of the daemon and the stack trace lines after it.
+type logEvent struct {
+	time    time.Time
+	level   slog.Level
+	logger  string
+	thread  string
+	message string
+	// structured is set for logback log lines, which take every following
+	// line that is not a log line as a continuation. Other lines only take
+	// stack trace lines.
+	structured bool
+	stackTrace []string
+}
+
+// parseLogLine parses a logback log line. Lines that only have a time of day
+// are assumed to be from the day of now.
+func parseLogLine(line string, now time.Time) (*logEvent, bool) {
+	m := logLinePattern.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+
+	e := &logEvent{
+		time:       now,
+		level:      logLevels[m[3]],
+		thread:     m[2],
+		logger:     m[4],
+		message:    strings.TrimRight(m[5], " "),
+		structured: true,
+	}
+
+	timestamp := strings.Replace(m[1], ",", ".", 1)
+	if len(timestamp) > 12 {
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", strings.Replace(timestamp, "T", " ", 1), now.Location()); err == nil {
+			e.time = t
+		}
+	} else if t, err := time.ParseInLocation("15:04:05.000", timestamp, now.Location()); err == nil {
+		y, mo, d := now.Date()
+		e.time = time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), now.Location())
+	}
+
+	return e, true
+}
+
+// isContinuation reports whether line belongs to the event e.
+func (e *logEvent) isContinuation(line string) bool {
+	return e.structured || stackTracePattern.MatchString(line)
+}
+
+func (e *logEvent) record(stream string) slog.Record {
+	r := slog.NewRecord(e.time, e.level, e.message, 0)
+	r.AddAttrs(slog.String("stream", stream))
+	if e.logger != "" {
+		r.AddAttrs(slog.String("logger", e.logger))
+	}
+	if e.thread != "" {
+		r.AddAttrs(slog.String("thread", e.thread))
+	}
+	if len(e.stackTrace) > 0 {
+		r.AddAttrs(slog.String("stack_trace", strings.Join(e.stackTrace, "\n")))
+	}
+	return r
+}
+
+// pipeOutput logs an output stream of the daemon with the logger, or with
+// config's slog handler if it is set, and publishes the events in it.
+func (r *Runner) pipeOutput(getPipe func() (io.ReadCloser, error), config *StreamLogConfig, stream string, wg *sync.WaitGroup) error {
+	getPipe = r.observeLines(getPipe)
+	if config == nil || config.Handler == nil {
+		return pipeToLogger(r.logger, getPipe, wg)
+	}
+	return pipeToSlog(getPipe, *config, stream, wg)
+}
+
+// pipeToSlog logs each log line read from the pipe as an event through the
+// config's handler, with the stack trace lines that follow it.
+func pipeToSlog(getPipe func() (io.ReadCloser, error), config StreamLogConfig, stream string, wg *sync.WaitGroup) error {
+	pipe, err := getPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to get pipe")
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		var pending *logEvent
+		flush := func() {
+			if pending == nil {
+				return
+			}
+			if config.Handler.Enabled(context.Background(), pending.level) {
+				config.Handler.Handle(context.Background(), pending.record(stream))
+			}
+			pending = nil
+		}
+
+		timer := time.NewTimer(continuationTimeout)
+		timer.Stop()
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					flush()
+					return
+				}
+
+				if e, ok := parseLogLine(line, time.Now()); ok {
+					flush()
+					pending = e
+				} else if pending != nil && pending.isContinuation(line) {
+					pending.stackTrace = append(pending.stackTrace, line)
+				} else {
+					flush()
+					pending = &logEvent{time: time.Now(), level: config.DefaultLevel, message: line}
+				}
+
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(continuationTimeout)
+			case <-timer.C:
+				flush()
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	now := time.Date(2024, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	e, ok := parseLogLine("2024-11-04 10:02:03,123 [main] WARN  s.a.k.multilang.MultiLangDaemon - Something happened ", now)
+	if !ok {
+		t.Fatal("expected the line to be parsed")
+	}
+	expected := logEvent{
+		time:       time.Date(2024, 11, 4, 10, 2, 3, 123000000, time.UTC),
+		level:      slog.LevelWarn,
+		thread:     "main",
+		logger:     "s.a.k.multilang.MultiLangDaemon",
+		message:    "Something happened",
+		structured: true,
+	}
+	if !e.time.Equal(expected.time) || e.level != expected.level || e.thread != expected.thread ||
+		e.logger != expected.logger || e.message != expected.message || !e.structured {
+		t.Errorf("expected %+v but got %+v", expected, *e)
+	}
+
+	e, ok = parseLogLine("10:02:03.123 [pool-1-thread-2] ERROR s.a.k.c.Scheduler -- Failed", now)
+	if !ok {
+		t.Fatal("expected the line to be parsed")
+	}
+	if !e.time.Equal(time.Date(2024, 11, 5, 10, 2, 3, 123000000, time.UTC)) || e.level != slog.LevelError || e.message != "Failed" {
+		t.Errorf("unexpected event %+v", *e)
+	}
+
+	for _, line := range []string{"Picked up JAVA_TOOL_OPTIONS: -Xmx1g", "\tat java.lang.Thread.run(Thread.java:829)"} {
+		if _, ok := parseLogLine(line, now); ok {
+			t.Errorf("expected '%s' not to be parsed", line)
+		}
+	}
+}
+
+// recordingHandler keeps the records it handles.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func attrs(r slog.Record) map[string]string {
+	m := map[string]string{}
+	r.Attrs(func(a slog.Attr) bool {
+		m[a.Key] = a.Value.String()
+		return true
+	})
+	return m
+}
+
+func TestPipeToSlog_JoinsStackTraces(t *testing.T) {
+	output := "Picked up JAVA_TOOL_OPTIONS: -Xmx1g\n" +
+		"2024-11-04 10:02:03,123 [main] ERROR s.a.k.c.Scheduler - Worker failed\n" +
+		"java.lang.RuntimeException: boom\n" +
+		"\tat software.amazon.kinesis.coordinator.Scheduler.run(Scheduler.java:42)\n" +
+		"Caused by: java.io.IOException: broken pipe\n" +
+		"\t... 3 more\n" +
+		"2024-11-04 10:02:04,000 [main] INFO  s.a.k.c.Scheduler - Restarting\n"
+	getPipe := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(output)), nil
+	}
+
+	handler := &recordingHandler{}
+	wg := &sync.WaitGroup{}
+	err := pipeToSlog(getPipe, StreamLogConfig{Handler: handler, DefaultLevel: slog.LevelWarn}, "stderr", wg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if len(handler.records) != 3 {
+		t.Fatalf("expected 3 events but got %d", len(handler.records))
+	}
+
+	first := handler.records[0]
+	if first.Message != "Picked up JAVA_TOOL_OPTIONS: -Xmx1g" || first.Level != slog.LevelWarn || attrs(first)["stream"] != "stderr" {
+		t.Errorf("unexpected first event %v %v", first, attrs(first))
+	}
+
+	second := handler.records[1]
+	expectedStackTrace := "java.lang.RuntimeException: boom\n" +
+		"\tat software.amazon.kinesis.coordinator.Scheduler.run(Scheduler.java:42)\n" +
+		"Caused by: java.io.IOException: broken pipe\n" +
+		"\t... 3 more"
+	if second.Message != "Worker failed" || second.Level != slog.LevelError {
+		t.Errorf("unexpected second event %v", second)
+	}
+	if a := attrs(second); a["stack_trace"] != expectedStackTrace || a["logger"] != "s.a.k.c.Scheduler" || a["thread"] != "main" {
+		t.Errorf("unexpected attributes of the second event %v", a)
+	}
+
+	if handler.records[2].Message != "Restarting" {
+		t.Errorf("unexpected third event %v", handler.records[2])
+	}
+}
+
+func TestPipeToSlog_LogsEventAfterTimeout(t *testing.T) {
+	pipeReader, pipeWriter := io.Pipe()
+	defer pipeWriter.Close()
+	getPipe := func() (io.ReadCloser, error) {
+		return pipeReader, nil
+	}
+
+	handler := &recordingHandler{}
+	err := pipeToSlog(getPipe, StreamLogConfig{Handler: handler}, "stdout", &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.WriteString(pipeWriter, "2024-11-04 10:02:03,123 [main] INFO  s.a.k.c.Scheduler - Still running\n")
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		handler.mu.Lock()
+		n := len(handler.records)
+		handler.mu.Unlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected the event to be logged while the pipe is still open")
+}
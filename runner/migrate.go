@@ -0,0 +1,205 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/pkg/errors"
+)
+
+// MigrationStage is a step of a rolling upgrade from KCL 2.x to 3.x.
+type MigrationStage string
+
+const (
+	// MigrationStageCompatible runs KCL 3.x with the KCL 2.x lease balancing
+	// algorithm so that upgraded and not yet upgraded workers can share a
+	// lease table. Deploy it to every worker first.
+	MigrationStageCompatible MigrationStage = "compatible"
+	// MigrationStageFinal removes the compatibility settings. Deploy it once
+	// every worker runs KCL 3.x.
+	MigrationStageFinal MigrationStage = "final"
+)
+
+// renamedKeys are the KCL 2.x multilang keys for credentials providers and
+// their KCL 3.x names.
+var renamedKeys = [][2]string{
+	{"AWSCredentialsProvider", "AwsCredentialsProvider"},
+	{"AWSCredentialsProviderDynamoDB", "AwsCredentialsProviderDynamoDB"},
+	{"AWSCredentialsProviderCloudWatch", "AwsCredentialsProviderCloudWatch"},
+}
+
+// renamedCredentialsProviders maps AWS SDK for Java v1 credentials providers,
+// used by KCL 2.x multilang, to their AWS SDK for Java v2 names used by KCL
+// 3.x.
+var renamedCredentialsProviders = map[string]string{
+	"DefaultAWSCredentialsProviderChain":      "DefaultCredentialsProvider",
+	"SystemPropertiesCredentialsProvider":     "SystemPropertyCredentialsProvider",
+	"STSAssumeRoleSessionCredentialsProvider": "StsAssumeRoleCredentialsProvider",
+	"EC2ContainerCredentialsProviderWrapper":  "ContainerCredentialsProvider",
+}
+
+// leaseBalancingKeys only affect the KCL 2.x lease balancing algorithm and
+// are ignored once the fleet uses the KCL 3.x one.
+var leaseBalancingKeys = []string{
+	"maxLeasesToStealAtOneTime",
+}
+
+// MigrationChange describes a change made to a properties file, or something
+// to do by hand, during a migration.
+type MigrationChange struct {
+	Line    int
+	Key     string
+	Message string
+}
+
+func (c MigrationChange) String() string {
+	if c.Line == 0 {
+		return c.Message
+	}
+	return fmt.Sprintf("line %d: %s", c.Line, c.Message)
+}
+
+// MigrateProperties rewrites a KCL 2.x properties file in place for a stage
+// of the upgrade to KCL 3.x and returns the changes it made. Both stages
+// rename the credentials provider keys and values. The compatible stage sets
+// clientVersionConfig to CLIENT_VERSION_CONFIG_COMPATIBLE_WITH_2X and the
+// final stage removes it along with the KCL 2.x lease balancing keys.
+func MigrateProperties(p *properties.Properties, stage MigrationStage) ([]MigrationChange, error) {
+	if stage != MigrationStageCompatible && stage != MigrationStageFinal {
+		return nil, errors.Errorf("unknown migration stage '%s'", stage)
+	}
+
+	changes := []MigrationChange{}
+	lines := map[string]int{}
+	for _, e := range p.Entries() {
+		lines[e.Key] = e.Line
+	}
+
+	for _, keys := range renamedKeys {
+		oldKey, newKey := keys[0], keys[1]
+		if _, ok := p.Get(oldKey); ok {
+			if _, ok := p.Get(newKey); ok {
+				p.Delete(oldKey)
+				changes = append(changes, MigrationChange{
+					Line:    lines[oldKey],
+					Key:     oldKey,
+					Message: fmt.Sprintf("removed %s, which KCL 3.x ignores, since %s is also set", oldKey, newKey),
+				})
+			} else {
+				p.Rename(oldKey, newKey)
+				lines[newKey] = lines[oldKey]
+				changes = append(changes, MigrationChange{
+					Line:    lines[oldKey],
+					Key:     oldKey,
+					Message: fmt.Sprintf("renamed %s to %s", oldKey, newKey),
+				})
+			}
+		}
+
+		value, ok := p.Get(newKey)
+		if !ok {
+			continue
+		}
+		// Providers can be followed by arguments, e.g. a role ARN.
+		parts := strings.SplitN(value, "|", 2)
+		provider, ok := renamedCredentialsProviders[parts[0]]
+		if !ok {
+			continue
+		}
+		parts[0] = provider
+		p.Set(newKey, strings.Join(parts, "|"))
+		changes = append(changes, MigrationChange{
+			Line:    lines[newKey],
+			Key:     newKey,
+			Message: fmt.Sprintf("replaced the AWS SDK v1 credentials provider %s with %s", value, strings.Join(parts, "|")),
+		})
+	}
+
+	if stage == MigrationStageCompatible {
+		changes = append(changes, migrateToCompatible(p, lines)...)
+	} else {
+		changes = append(changes, migrateToFinal(p, lines)...)
+	}
+
+	sortChanges(changes)
+	return changes, nil
+}
+
+func migrateToCompatible(p *properties.Properties, lines map[string]int) []MigrationChange {
+	changes := []MigrationChange{}
+
+	if version, ok := p.Get("clientVersionConfig"); !ok || !strings.EqualFold(version, ClientVersionCompatibleWith2x) {
+		p.Set("clientVersionConfig", ClientVersionCompatibleWith2x)
+		changes = append(changes, MigrationChange{
+			Line:    lines["clientVersionConfig"],
+			Key:     "clientVersionConfig",
+			Message: "set clientVersionConfig = " + ClientVersionCompatibleWith2x + " for the rolling upgrade",
+		})
+	}
+
+	for _, key := range leaseBalancingKeys {
+		if _, ok := p.Get(key); ok {
+			changes = append(changes, MigrationChange{
+				Line:    lines[key],
+				Key:     key,
+				Message: fmt.Sprintf("%s is only used until the fleet switches to the KCL 3.x lease balancing algorithm", key),
+			})
+		}
+	}
+
+	applicationName := p.GetDefault("applicationName", "<applicationName>")
+	tables := []struct {
+		key          string
+		defaultTable string
+	}{
+		{"coordinatorStateTableName", applicationName + "-CoordinatorState"},
+		{"workerMetricsTableName", applicationName + "-WorkerMetricStats"},
+	}
+	for _, table := range tables {
+		changes = append(changes, MigrationChange{
+			Message: fmt.Sprintf("KCL 3.x creates the DynamoDB table %s, make sure the workers are allowed to create and use it",
+				p.GetDefault(table.key, table.defaultTable)),
+		})
+	}
+
+	return changes
+}
+
+func migrateToFinal(p *properties.Properties, lines map[string]int) []MigrationChange {
+	changes := []MigrationChange{}
+
+	if version, ok := p.Get("clientVersionConfig"); ok && !strings.EqualFold(version, ClientVersion3x) {
+		p.Delete("clientVersionConfig")
+		changes = append(changes, MigrationChange{
+			Line:    lines["clientVersionConfig"],
+			Key:     "clientVersionConfig",
+			Message: "removed clientVersionConfig so that the fleet uses the KCL 3.x lease balancing algorithm",
+		})
+	}
+
+	for _, key := range leaseBalancingKeys {
+		if _, ok := p.Get(key); ok {
+			p.Delete(key)
+			changes = append(changes, MigrationChange{
+				Line:    lines[key],
+				Key:     key,
+				Message: fmt.Sprintf("removed %s, which KCL 3.x lease balancing ignores", key),
+			})
+		}
+	}
+
+	return changes
+}
+
+// sortChanges orders changes by line, with the ones that are not about a line
+// last, so the report reads top to bottom.
+func sortChanges(changes []MigrationChange) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Line == 0 || changes[j].Line == 0 {
+			return changes[i].Line != 0 && changes[j].Line == 0
+		}
+		return changes[i].Line < changes[j].Line
+	})
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+)
+
+func migrate(t *testing.T, content string, stage MigrationStage) (string, []string) {
+	p, err := properties.Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := MigrateProperties(p, stage)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	messages := []string{}
+	for _, change := range changes {
+		messages = append(messages, change.String())
+	}
+	return p.String(), messages
+}
+
+func TestMigrateProperties_Compatible(t *testing.T) {
+	migrated, changes := migrate(t, validProperties+
+		"# The role to assume.\n"+
+		"AWSCredentialsProvider = STSAssumeRoleSessionCredentialsProvider|arn:aws:iam::123456789012:role/some_role\n"+
+		"maxLeasesToStealAtOneTime = 2\n", MigrationStageCompatible)
+
+	expectedFile := validProperties +
+		"# The role to assume.\n" +
+		"AwsCredentialsProvider = StsAssumeRoleCredentialsProvider|arn:aws:iam::123456789012:role/some_role\n" +
+		"maxLeasesToStealAtOneTime = 2\n" +
+		"clientVersionConfig = CLIENT_VERSION_CONFIG_COMPATIBLE_WITH_2X\n"
+	if migrated != expectedFile {
+		t.Errorf("expected the file\n%s\nbut got\n%s", expectedFile, migrated)
+	}
+
+	expectedChanges := []string{
+		"line 5: renamed AWSCredentialsProvider to AwsCredentialsProvider",
+		"line 5: replaced the AWS SDK v1 credentials provider STSAssumeRoleSessionCredentialsProvider|arn:aws:iam::123456789012:role/some_role with StsAssumeRoleCredentialsProvider|arn:aws:iam::123456789012:role/some_role",
+		"line 6: maxLeasesToStealAtOneTime is only used until the fleet switches to the KCL 3.x lease balancing algorithm",
+		"set clientVersionConfig = CLIENT_VERSION_CONFIG_COMPATIBLE_WITH_2X for the rolling upgrade",
+		"KCL 3.x creates the DynamoDB table some_app-CoordinatorState, make sure the workers are allowed to create and use it",
+		"KCL 3.x creates the DynamoDB table some_app-WorkerMetricStats, make sure the workers are allowed to create and use it",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expectedChanges, "\n") {
+		t.Errorf("expected changes\n%s\nbut got\n%s", strings.Join(expectedChanges, "\n"), strings.Join(changes, "\n"))
+	}
+}
+
+func TestMigrateProperties_Final(t *testing.T) {
+	compatible, _ := migrate(t, validProperties+
+		"AWSCredentialsProvider = DefaultAWSCredentialsProviderChain\n"+
+		"AwsCredentialsProviderDynamoDB = DefaultCredentialsProvider\n"+
+		"AWSCredentialsProviderDynamoDB = DefaultAWSCredentialsProviderChain\n"+
+		"maxLeasesToStealAtOneTime = 2\n", MigrationStageCompatible)
+
+	final, changes := migrate(t, compatible, MigrationStageFinal)
+
+	expectedFile := validProperties +
+		"AwsCredentialsProvider = DefaultCredentialsProvider\n" +
+		"AwsCredentialsProviderDynamoDB = DefaultCredentialsProvider\n"
+	if final != expectedFile {
+		t.Errorf("expected the file\n%s\nbut got\n%s", expectedFile, final)
+	}
+	if len(changes) != 2 {
+		t.Errorf("expected clientVersionConfig and maxLeasesToStealAtOneTime to be removed but got %v", changes)
+	}
+
+	if problems := validate(t, final); len(problems) != 0 {
+		t.Errorf("expected the final file to be valid but got %v", problems)
+	}
+}
+
+func TestMigrateProperties_ReturnsErrorForUnknownStage(t *testing.T) {
+	if _, err := MigrateProperties(properties.New(), "later"); err == nil {
+		t.Error("expected an error, but got nil")
+	}
+}
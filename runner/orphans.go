@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processTrackingInterval is how often the descendants of the daemon are
+// listed.
+const processTrackingInterval = time.Second
+
+// WithOrphanGracePeriod sets how long record processors that are still running
+// after the daemon exited have to exit after SIGTERM before they are killed.
+// Defaults to 5s.
+func WithOrphanGracePeriod(d time.Duration) Option {
+	return func(runner *Runner) {
+		runner.orphanGracePeriod = d
+	}
+}
+
+// processInfo is a process listed by processTable.
+type processInfo struct {
+	pid     int
+	ppid    int
+	pgid    int
+	command string
+	state   string
+	// startTime tells a process apart from a later one with the same PID.
+	startTime uint64
+}
+
+func (p processInfo) String() string {
+	return fmt.Sprintf("%d (%s)", p.pid, p.command)
+}
+
+// processTracker remembers the descendants of the daemon, the record
+// processors, while it runs. Once the daemon exited it terminates those that
+// are still running, which would otherwise keep processing records without
+// holding a lease.
+type processTracker struct {
+	r    *Runner
+	pid  int
+	stop chan struct{}
+	done chan struct{}
+
+	// descendants are the processes seen below the daemon by PID.
+	descendants map[int]processInfo
+	// terminated is set once the tracker noticed that the daemon exited and
+	// terminated the processes it left behind.
+	terminated bool
+}
+
+// trackProcesses tracks the descendants of the daemon with the given PID
+// until it exits or wait is called. On Linux it terminates the processes left
+// behind as soon as it notices that the daemon exited, so that they are also
+// cleaned up for daemons started by RunJavaDaemon.
+func (r *Runner) trackProcesses(pid int) *processTracker {
+	t := &processTracker{
+		r:           r,
+		pid:         pid,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		descendants: map[int]processInfo{},
+	}
+	go t.run()
+	return t
+}
+
+func (t *processTracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(processTrackingInterval)
+	defer ticker.Stop()
+
+	var daemon processInfo
+	for {
+		table, err := processTable()
+		if err != nil {
+			// Only the process group is known, which wait terminates.
+			return
+		}
+
+		current, ok := table[t.pid]
+		if !ok || (daemon.pid != 0 && current.startTime != daemon.startTime) {
+			t.terminateOrphans()
+			t.terminated = true
+			return
+		}
+		daemon = current
+		t.update(table)
+
+		select {
+		case <-ticker.C:
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// update adds the current descendants of the daemon.
+func (t *processTracker) update(table map[int]processInfo) {
+	for pid, p := range descendants(table, t.pid) {
+		t.descendants[pid] = p
+	}
+}
+
+// descendants returns the processes of the table below the process pid by
+// PID.
+func descendants(table map[int]processInfo, pid int) map[int]processInfo {
+	children := map[int][]processInfo{}
+	for _, p := range table {
+		children[p.ppid] = append(children[p.ppid], p)
+	}
+
+	found := map[int]processInfo{}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			found[child.pid] = child
+			queue = append(queue, child.pid)
+		}
+	}
+	return found
+}
+
+// survivors returns the tracked descendants and the members of the daemon's
+// process group that are still running.
+func (t *processTracker) survivors() []processInfo {
+	table, err := processTable()
+	if err != nil {
+		return nil
+	}
+
+	survivors := []processInfo{}
+	for pid, p := range table {
+		if pid == t.pid {
+			continue
+		}
+		if known, ok := t.descendants[pid]; (ok && known.startTime == p.startTime) || p.pgid == t.pid {
+			survivors = append(survivors, p)
+		}
+	}
+	sort.Slice(survivors, func(i, j int) bool { return survivors[i].pid < survivors[j].pid })
+	return survivors
+}
+
+// groupAlive reports whether a process of the daemon's process group is still
+// running.
+func (t *processTracker) groupAlive() bool {
+	return signalProcess(-t.pid, 0) == nil
+}
+
+// terminateOrphans sends SIGTERM to the descendants and the process group of
+// the daemon that are still running, and SIGKILL to those still running after
+// the grace period. Without a process table only the process group is
+// signalled.
+func (t *processTracker) terminateOrphans() {
+	_, err := processTable()
+	listed := err == nil
+
+	var survivors []processInfo
+	running := func() bool {
+		if !listed {
+			return t.groupAlive()
+		}
+		survivors = t.survivors()
+		return len(survivors) > 0
+	}
+	if !running() {
+		return
+	}
+
+	t.r.logger.Printf("Terminating processes left behind by the java daemon: %s.", describeProcesses(survivors, t.pid))
+	t.signal(survivors, syscall.SIGTERM)
+
+	deadline := time.Now().Add(t.r.orphanGracePeriod)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if !running() {
+			t.r.logger.Println("Processes left behind by the java daemon exited.")
+			return
+		}
+	}
+
+	t.r.logger.Printf("Killing processes left behind by the java daemon: %s.", describeProcesses(survivors, t.pid))
+	t.signal(survivors, syscall.SIGKILL)
+}
+
+// signal sends sig to the processes and to the daemon's process group.
+func (t *processTracker) signal(processes []processInfo, sig syscall.Signal) {
+	signalProcess(-t.pid, sig)
+	for _, p := range processes {
+		signalProcess(p.pid, sig)
+	}
+}
+
+// describeProcesses lists processes for the logs, or the process group if
+// none are known.
+func describeProcesses(processes []processInfo, pgid int) string {
+	if len(processes) == 0 {
+		return fmt.Sprintf("process group %d", pgid)
+	}
+
+	descriptions := make([]string, len(processes))
+	for i, p := range processes {
+		descriptions[i] = p.String()
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// wait terminates the processes left behind once the daemon exited. It must
+// be called after the daemon was waited for and only once.
+func (t *processTracker) wait() {
+	close(t.stop)
+	<-t.done
+	if !t.terminated {
+		t.terminateOrphans()
+	}
+}
@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSupervise_TerminatesOrphans(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("processes are only tracked on Linux")
+	}
+
+	pidsFile := filepath.Join(t.TempDir(), "pids")
+	// One record processor stays in the daemon's process group and one
+	// leaves it, which only the tracked descendants catch.
+	r, _ := getTestRunner(t, "sleep 60 >/dev/null 2>&1 &\necho $! >> "+pidsFile+"\n"+
+		"setsid sleep 60 >/dev/null 2>&1 &\necho $! >> "+pidsFile+"\n"+
+		"sleep 1.5")
+
+	if err := r.Supervise(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	data, err := os.ReadFile(pidsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := processTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range strings.Fields(string(data)) {
+		pid, _ := strconv.Atoi(field)
+		if p, ok := table[pid]; ok {
+			signalProcess(pid, 9)
+			t.Errorf("expected the orphaned record processor %s to be terminated", p)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"os"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/pkg/errors"
+)
+
+// WithPropertiesOverlays layers the properties files at paths over the
+// properties file, in order. A key set in an overlay replaces the value of the
+// files before it. Overlays are expanded like the properties file.
+func WithPropertiesOverlays(paths ...string) Option {
+	return func(runner *Runner) {
+		runner.propertiesOverlays = append(runner.propertiesOverlays, paths...)
+	}
+}
+
+// WithEnvOverrides lets KCL_ environment variables, named like for
+// ConfigFromEnv, override the properties after the overlays, e.g.
+// KCL_MAX_RECORDS=500 for maxRecords.
+func WithEnvOverrides(enabled bool) Option {
+	return func(runner *Runner) {
+		runner.envOverrides = enabled
+	}
+}
+
+// LoadEffectiveProperties loads the properties file at path and layers the
+// overlays over it, expanding ${NAME} and ${NAME:-default} in every file from
+// the environment, and then applies the KCL_ environment variables if
+// envOverrides is set. It also reports whether the result differs from the
+// file at path.
+func LoadEffectiveProperties(path string, overlays []string, envOverrides bool) (*properties.Properties, bool, error) {
+	p, err := properties.Load(path)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to read %s", path)
+	}
+	original := p.String()
+	if err = p.Expand(os.LookupEnv); err != nil {
+		return nil, false, errors.Wrapf(err, "failed to expand %s", path)
+	}
+
+	for _, overlay := range overlays {
+		o, err := loadExpanded(overlay)
+		if err != nil {
+			return nil, false, err
+		}
+		p.Merge(o)
+	}
+
+	if envOverrides {
+		if err = applyEnvOverrides(p); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return p, p.String() != original, nil
+}
+
+// loadExpanded loads the properties file at path and expands its variables.
+func loadExpanded(path string) (*properties.Properties, error) {
+	p, err := properties.Load(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	if err = p.Expand(os.LookupEnv); err != nil {
+		return nil, errors.Wrapf(err, "failed to expand %s", path)
+	}
+	return p, nil
+}
+
+// applyEnvOverrides sets every known key and every key of p that has a KCL_
+// environment variable. Values of typed keys are parsed like by ConfigFromEnv,
+// so durations can be Go durations such as 30s.
+func applyEnvOverrides(p *properties.Properties) error {
+	c := &Config{}
+	fields := map[string]configField{}
+	keys := append([]string(nil), untypedKeys...)
+	for _, f := range c.fields() {
+		fields[f.key] = f
+		keys = append(keys, f.key)
+	}
+	keys = append(keys, p.Keys()...)
+
+	for _, key := range keys {
+		value, ok := os.LookupEnv(EnvName(key))
+		if !ok || value == "" {
+			continue
+		}
+
+		if f, ok := fields[key]; ok {
+			if err := f.parse(value); err != nil {
+				return errors.Wrapf(err, "invalid %s", EnvName(key))
+			}
+			value, _ = f.format()
+		}
+		p.Set(key, value)
+	}
+	return nil
+}
+
+// renderEffectiveProperties replaces the properties file with a temporary
+// file holding the effective properties, including the executableName given
+// to WithExecutableName, when they differ from it.
+func (r *Runner) renderEffectiveProperties() error {
+	p, changed, err := LoadEffectiveProperties(r.pathToPropertiesFile, r.propertiesOverlays, r.envOverrides)
+	if err != nil {
+		return err
+	}
+	if current, _ := p.Get("executableName"); r.executableName != "" && current != r.executableName {
+		p.Set("executableName", r.executableName)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	path, err := writeTempProperties(p)
+	if err != nil {
+		return err
+	}
+	if r.renderedPropertiesFile != "" {
+		os.Remove(r.renderedPropertiesFile)
+	}
+	r.pathToPropertiesFile = path
+	r.renderedPropertiesFile = path
+	return nil
+}
+
+// EffectiveProperties returns the properties the daemon is started with,
+// after expanding variables and applying overlays and environment overrides.
+func (r *Runner) EffectiveProperties() (*properties.Properties, error) {
+	return properties.Load(r.pathToPropertiesFile)
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadEffectiveProperties(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.properties")
+	overlay := filepath.Join(dir, "prod.properties")
+	if err := os.WriteFile(base, []byte("streamName = events-${STAGE}\nregionName = ${REGION:-us-east-1}\nmaxRecords = 100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(overlay, []byte("maxRecords = 1000\nworkerId = ${HOSTNAME_FOR_TEST:-worker}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STAGE", "prod")
+	t.Setenv("KCL_FAILOVER_TIME_MILLIS", "30s")
+	t.Setenv("KCL_MAX_RECORDS", "500")
+
+	p, changed, err := LoadEffectiveProperties(base, []string{overlay}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := "streamName = events-prod\nregionName = us-east-1\nmaxRecords = 1000\nworkerId = worker\n"
+	if !changed || p.String() != expected {
+		t.Errorf("expected '%s' but got '%s' with changed %t", expected, p.String(), changed)
+	}
+
+	p, _, err = LoadEffectiveProperties(base, []string{overlay}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	for key, value := range map[string]string{"maxRecords": "500", "failoverTimeMillis": "30000"} {
+		if actual, _ := p.Get(key); actual != value {
+			t.Errorf("expected %s to be overridden with %s but got '%s'", key, value, actual)
+		}
+	}
+}
+
+func TestGetRunner_KeepsUnchangedPropertiesFile(t *testing.T) {
+	base, _ := getTestRunner(t, "")
+	r, err := GetRunner(
+		WithPathToJavaBinary(base.pathToJavaBinary),
+		WithPathToJarFolder(base.pathToJarFolder),
+		WithPathToPropertiesFile(base.pathToPropertiesFile),
+		WithPropertiesOverlays(),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer r.Close()
+
+	if r.pathToPropertiesFile != base.pathToPropertiesFile || r.renderedPropertiesFile != "" {
+		t.Errorf("expected the properties file to be used as is but got %s", r.pathToPropertiesFile)
+	}
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// sysProcAttr starts the daemon in its own process group, so that its record
+// processors can be signalled together, and has it sent SIGTERM if the runner
+// dies. Strictly the signal is sent when the thread that started the daemon
+// exits, which the Go runtime only does for threads locked by goroutines that
+// exited.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGTERM,
+	}
+}
+
+// processTable returns the running processes by PID. Zombies are left out.
+func processTable() (map[int]processInfo, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list processes")
+	}
+
+	table := map[int]processInfo{}
+	for _, stat := range stats {
+		data, err := os.ReadFile(stat)
+		if err != nil {
+			// The process exited since it was listed.
+			continue
+		}
+
+		p, ok := parseProcStat(string(data))
+		if ok && p.state != "Z" {
+			table[p.pid] = p
+		}
+	}
+	return table, nil
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat.
+func parseProcStat(stat string) (processInfo, bool) {
+	// The command name in parentheses may contain spaces and parentheses, so
+	// the other fields are counted from the last parenthesis.
+	open := strings.IndexByte(stat, '(')
+	close := strings.LastIndexByte(stat, ')')
+	if open < 0 || close < open {
+		return processInfo{}, false
+	}
+
+	fields := strings.Fields(stat[close+1:])
+	if len(fields) < 20 {
+		return processInfo{}, false
+	}
+
+	pid, err1 := strconv.Atoi(strings.TrimSpace(stat[:open]))
+	ppid, err2 := strconv.Atoi(fields[1])
+	pgid, err3 := strconv.Atoi(fields[2])
+	startTime, err4 := strconv.ParseUint(fields[19], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return processInfo{}, false
+	}
+
+	return processInfo{
+		pid:       pid,
+		ppid:      ppid,
+		pgid:      pgid,
+		command:   stat[open+1 : close],
+		state:     fields[0],
+		startTime: startTime,
+	}, true
+}
+
+// childPIDs returns the PIDs of the direct children of the process pid.
+func childPIDs(pid int) ([]int, error) {
+	table, err := processTable()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []int{}
+	for _, p := range table {
+		if p.ppid == pid {
+			children = append(children, p.pid)
+		}
+	}
+	sort.Ints(children)
+	return children, nil
+}
+
+func signalProcess(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+// goroutineDumpSignal asks a record processor of the kcl package for a
+// goroutine dump.
+const goroutineDumpSignal = syscall.SIGUSR1
@@ -0,0 +1,14 @@
+package runner
+
+import "testing"
+
+func TestParseProcStat(t *testing.T) {
+	p, ok := parseProcStat("4242 (a) b (c)) S 4200 4242 4242 0 -1 4194560 104 0 0 0 0 0 0 0 20 0 1 0 123456 1 2 3\n")
+	if !ok {
+		t.Fatal("expected the stat line to be parsed")
+	}
+	expected := processInfo{pid: 4242, ppid: 4200, pgid: 4242, command: "a) b (c)", state: "S", startTime: 123456}
+	if p != expected {
+		t.Errorf("expected %+v but got %+v", expected, p)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build unix && !linux
+
+package runner
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// sysProcAttr starts the daemon in its own process group, so that its record
+// processors can be signalled together.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// processTable is only supported on Linux.
+func processTable() (map[int]processInfo, error) {
+	return nil, errors.New("listing processes is only supported on Linux")
+}
+
+// childPIDs is only supported on Linux.
+func childPIDs(pid int) ([]int, error) {
+	return nil, errors.New("listing child processes is only supported on Linux")
+}
+
+func signalProcess(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+// goroutineDumpSignal asks a record processor of the kcl package for a
+// goroutine dump.
+const goroutineDumpSignal = syscall.SIGUSR1
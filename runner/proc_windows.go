@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// sysProcAttr leaves the daemon in the runner's process group, since Windows
+// has no process groups that can be signalled.
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// processTable is only supported on Linux.
+func processTable() (map[int]processInfo, error) {
+	return nil, errors.New("listing processes is only supported on Linux")
+}
+
+// childPIDs is only supported on Linux.
+func childPIDs(pid int) ([]int, error) {
+	return nil, errors.New("listing child processes is only supported on Linux")
+}
+
+// signalProcess is not supported on Windows.
+func signalProcess(pid int, sig syscall.Signal) error {
+	return errors.New("signalling processes is not supported on Windows")
+}
+
+// goroutineDumpSignal does not exist on Windows, where signalProcess fails.
+const goroutineDumpSignal = syscall.Signal(0x1e)
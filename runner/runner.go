@@ -2,14 +2,20 @@ package runner
 
 import (
 	"bufio"
+	"context"
+	stderrors "errors"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/goguardian/goguardian-go-kcl/jars"
 	"github.com/pkg/errors"
 )
 
@@ -21,6 +27,8 @@ func WithLogger(logger *log.Logger) Option {
 	}
 }
 
+// WithPathToJavaBinary sets the java binary that runs the daemon. Without it
+// GetRunner finds one with FindJava.
 func WithPathToJavaBinary(p string) Option {
 	return func(runner *Runner) {
 		runner.pathToJavaBinary = p
@@ -39,94 +47,386 @@ func WithPathToPropertiesFile(p string) Option {
 	}
 }
 
+// WithRestartBackoff sets the delay before Supervise restarts a crashed
+// daemon. The delay starts at initial and doubles after each consecutive
+// crash up to max. Defaults to 1s and 1m.
+func WithRestartBackoff(initial, max time.Duration) Option {
+	return func(runner *Runner) {
+		runner.initialRestartBackoff = initial
+		runner.maxRestartBackoff = max
+	}
+}
+
+// WithConfig runs the daemon with a properties file rendered from c instead
+// of one passed to WithPathToPropertiesFile. Call Close to remove the rendered
+// file.
+func WithConfig(c *Config) Option {
+	return func(runner *Runner) {
+		runner.config = c
+	}
+}
+
+// WithShutdownTimeout sets how long the daemon has to shut down gracefully
+// after its context is cancelled before it is killed. When graceful lease
+// handoff is enabled in the properties file, gracefulLeaseHandoffTimeoutMillis
+// is added to it. Defaults to 30s.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(runner *Runner) {
+		runner.shutdownTimeout = d
+	}
+}
+
+// WithJVMOptions sets the heap, garbage collector, system properties and
+// agents of the JVM that runs the daemon.
+func WithJVMOptions(o *JVMOptions) Option {
+	return func(runner *Runner) {
+		runner.jvmOptions = o
+	}
+}
+
+// WithRecursiveJarFolder also puts the jars in subfolders of the jar folder
+// on the classpath.
+func WithRecursiveJarFolder(recursive bool) Option {
+	return func(runner *Runner) {
+		runner.recursiveJarFolder = recursive
+	}
+}
+
+// WithJarVerification checks the jar folder against the manifest written by
+// jar-download before the daemon is first started, failing if a jar is
+// missing, unexpected or has a different checksum.
+func WithJarVerification(verify bool) Option {
+	return func(runner *Runner) {
+		runner.verifyJars = verify
+	}
+}
+
+// WithJarDownload makes sure that the jar folder holds the jars of d before
+// the daemon is first started, downloading them if they are missing or do not
+// match its manifest. Without a jar folder the jars are kept in the cache
+// directory of d.
+func WithJarDownload(d *jars.Downloader) Option {
+	return func(runner *Runner) {
+		runner.jarDownloader = d
+	}
+}
+
+// WithMaxRestarts sets how many consecutive crashes Supervise restarts before
+// giving up. Defaults to 5.
+func WithMaxRestarts(n int) Option {
+	return func(runner *Runner) {
+		runner.maxRestarts = n
+	}
+}
+
 type Runner struct {
 	logger *log.Logger
 
 	pathToJavaBinary     string
 	pathToPropertiesFile string
 	pathToJarFolder      string
+	recursiveJarFolder   bool
+	verifyJars           bool
+	jarDownloader        *jars.Downloader
+	// jarsVerified is set once the jar folder matched its manifest.
+	jarsVerified bool
+	// javaVersion is set once the java binary has been checked against the
+	// jars.
+	javaVersion *JavaVersion
+	jvmOptions  *JVMOptions
+
+	// stdoutLog and stderrLog log the daemon's output with slog when set.
+	stdoutLog *StreamLogConfig
+	stderrLog *StreamLogConfig
+
+	eventHandlers []EventHandler
+	eventPatterns []eventPattern
+
+	health     *healthState
+	healthAddr string
+
+	orphanGracePeriod time.Duration
+
+	watchdog            *WatchdogConfig
+	crashDiagnostics    *CrashDiagnosticsConfig
+	diagnosticsDir      string
+	diagnosticsKeep     int
+	diagnosticsMaxBytes int64
+	// lastOutOfMemory is the last EventOutOfMemory, kept for crash reports.
+	lastOutOfMemory atomic.Pointer[Event]
+	// lastOutput is when the daemon last printed a line, in nanoseconds since
+	// the epoch.
+	lastOutput    atomic.Int64
+	outputCapture outputCapture
+
+	config *Config
+	// renderedPropertiesFile is the properties file written from config or
+	// with the effective properties.
+	renderedPropertiesFile string
+	propertiesOverlays     []string
+	envOverrides           bool
+	executableName         string
+
+	credentials       *credentials.Credentials
+	credentialsRegion string
+	credentialsMode   CredentialsMode
+	credentialsServer *credentialsServer
+
+	logConfig *LogConfig
+	// logConfigFile is the logback configuration written from logConfig.
+	logConfigFile string
+
+	shutdownTimeout       time.Duration
+	initialRestartBackoff time.Duration
+	maxRestartBackoff     time.Duration
+	maxRestarts           int
+	// stableRunDuration is how long the daemon has to run before a crash is
+	// no longer counted as consecutive with the previous one.
+	stableRunDuration time.Duration
 }
 
 func GetRunner(opts ...Option) (*Runner, error) {
 	r := &Runner{
 		logger: log.New(os.Stdout, "", log.LstdFlags),
+
+		shutdownTimeout:       30 * time.Second,
+		initialRestartBackoff: time.Second,
+		maxRestartBackoff:     time.Minute,
+		maxRestarts:           5,
+		stableRunDuration:     10 * time.Minute,
+		orphanGracePeriod:     5 * time.Second,
+		diagnosticsKeep:       5,
+
+		eventPatterns: append([]eventPattern(nil), eventPatterns...),
+		health:        newHealthState(),
 	}
+	r.eventHandlers = []EventHandler{r.health.observe}
 
 	for _, opt := range opts {
 		opt(r)
 	}
 
+	// Remove the files rendered so far when the runner can not be built.
+	ok := false
+	defer func() {
+		if !ok {
+			r.Close()
+		}
+	}()
+
 	if r.pathToJavaBinary == "" {
-		return nil, errors.New("missing path to java binary")
+		path, err := FindJava()
+		if err != nil {
+			return nil, err
+		}
+		r.pathToJavaBinary = path
+	}
+
+	if r.jvmOptions != nil {
+		if err := r.jvmOptions.Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid JVM options")
+		}
+	}
+
+	if r.diagnosticsKeep < 1 {
+		return nil, errors.Errorf("invalid diagnostics retention of %d files, at least 1 must be kept", r.diagnosticsKeep)
+	}
+
+	if r.watchdog != nil && r.watchdog.StallWindow <= 0 {
+		return nil, errors.Errorf("invalid stall window %s, it must be positive", r.watchdog.StallWindow)
+	}
+
+	if r.credentials != nil && r.credentialsMode != CredentialsEnv && r.credentialsMode != CredentialsEndpoint {
+		return nil, errors.Errorf("unknown credentials mode '%s'", r.credentialsMode)
+	}
+
+	if r.config != nil {
+		if r.pathToPropertiesFile != "" {
+			return nil, errors.New("both a config and a path to a properties file were given")
+		}
+
+		if r.executableName != "" {
+			c := *r.config
+			c.ExecutableName = r.executableName
+			r.config = &c
+		}
+
+		path, err := r.config.WriteTempFile()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to render config")
+		}
+		r.pathToPropertiesFile = path
+		r.renderedPropertiesFile = path
 	}
 
 	if r.pathToPropertiesFile == "" {
 		return nil, errors.New("missing path to properties folder")
 	}
 
+	if err := r.renderEffectiveProperties(); err != nil {
+		return nil, err
+	}
+
+	if r.logConfig != nil {
+		path, err := r.logConfig.WriteTempFile()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to render log config")
+		}
+		r.logConfigFile = path
+	}
+
+	if r.pathToJarFolder == "" && r.jarDownloader != nil {
+		dir, err := r.jarDownloader.CacheDir()
+		if err != nil {
+			return nil, err
+		}
+		r.pathToJarFolder = dir
+	}
+
 	if r.pathToJarFolder == "" {
 		return nil, errors.New("missing path to jar folder")
 	}
 
+	if (r.watchdog != nil || r.crashDiagnostics != nil) && r.diagnosticsDir == "" {
+		r.diagnosticsDir = defaultDiagnosticsDir()
+	}
+	if r.diagnosticsDir != "" {
+		// Record processors run in the working directory of the daemon.
+		dir, err := filepath.Abs(r.diagnosticsDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid diagnostics folder")
+		}
+		r.diagnosticsDir = dir
+	}
+	if r.crashDiagnostics != nil {
+		c := *r.crashDiagnostics
+		for _, dir := range []*string{&c.ErrorFileDir, &c.HeapDumpDir} {
+			if *dir == "" {
+				*dir = r.diagnosticsDir
+			}
+			abs, err := filepath.Abs(*dir)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid crash diagnostics folder")
+			}
+			*dir = abs
+		}
+		r.crashDiagnostics = &c
+		r.eventHandlers = append(r.eventHandlers, r.observeOutOfMemory)
+	}
+
+	ok = true
 	return r, nil
 }
 
-func getJarPaths(jarFolder string) ([]string, error) {
-	files, err := ioutil.ReadDir(jarFolder)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read jar folder")
+// Close removes the properties file rendered from the config given to
+// WithConfig and the logback configuration generated from the one given to
+// WithLogConfig, if any, and stops the credentials endpoint. Every step is
+// run even if an earlier one failed, and their errors are returned together.
+func (r *Runner) Close() error {
+	var errs []error
+	if r.renderedPropertiesFile != "" {
+		if err := os.Remove(r.renderedPropertiesFile); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, errors.Wrap(err, "failed to remove rendered properties file"))
+		}
 	}
 
-	if len(files) == 0 {
-		return nil, errors.New("empty jar folder")
+	if r.logConfigFile != "" {
+		if err := os.Remove(r.logConfigFile); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, errors.Wrap(err, "failed to remove logback configuration file"))
+		}
 	}
 
-	jarPaths := []string{}
-	for _, file := range files {
-		jarPath, err := filepath.Abs(filepath.Join(jarFolder, file.Name()))
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to get jar path")
+	if r.credentialsServer != nil {
+		if err := r.credentialsServer.Close(); err != nil {
+			errs = append(errs, errors.Wrap(err, "failed to stop credentials endpoint"))
+		} else {
+			r.credentialsServer = nil
 		}
-
-		jarPaths = append(jarPaths, jarPath)
 	}
+	return stderrors.Join(errs...)
+}
 
-	return jarPaths, nil
+// ValidateProperties validates the properties file the daemon is started
+// with, see ValidatePropertiesFile.
+func (r *Runner) ValidateProperties() ([]Problem, error) {
+	return ValidatePropertiesFile(r.pathToPropertiesFile)
 }
 
-func (r *Runner) RunJavaDaemon(javaProperties ...string) (*exec.Cmd, error) {
-	daemonClass := "software.amazon.kinesis.multilang.MultiLangDaemon"
-	jarPaths, err := getJarPaths(r.pathToJarFolder)
+// RunJavaDaemon starts the java daemon. When ctx is cancelled the daemon is
+// sent SIGTERM so that it can shut down its record processors, and it is
+// killed if it is still running after the shutdown timeout. On Linux record
+// processors left running after the daemon exited are terminated.
+func (r *Runner) RunJavaDaemon(ctx context.Context, javaProperties ...string) (*exec.Cmd, error) {
+	d, err := r.startJavaDaemon(ctx, javaProperties)
 	if err != nil {
 		return nil, err
 	}
+	return d.cmd, nil
+}
 
-	currentDir, err := os.Getwd()
+// daemonProcess is a started daemon.
+type daemonProcess struct {
+	cmd *exec.Cmd
+	// output is done once all of the daemon's output has been logged, which
+	// must happen before cmd.Wait is called.
+	output *sync.WaitGroup
+	// tracker terminates the record processors left behind by the daemon.
+	tracker *processTracker
+	started time.Time
+}
+
+// startJavaDaemon starts the daemon in its own process group.
+func (r *Runner) startJavaDaemon(ctx context.Context, javaProperties []string) (*daemonProcess, error) {
+	if err := r.checkJavaVersion(); err != nil {
+		return nil, err
+	}
+
+	problems, err := r.ValidateProperties()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get present working directeory")
+		return nil, err
+	}
+	for _, problem := range problems {
+		r.logger.Printf("%s: %s", r.pathToPropertiesFile, problem)
+	}
+	if HasErrors(problems) {
+		return nil, errors.Errorf("invalid properties file %s", r.pathToPropertiesFile)
 	}
 
-	// TODO: check to make sure the properties file exists in currentDir
+	args, err := r.javaArgs(javaProperties)
+	if err != nil {
+		return nil, err
+	}
 
-	paths := append(jarPaths, currentDir)
-	classpath := strings.Join(paths, string(os.PathListSeparator))
+	shutdownTimeout, err := r.gracefulShutdownTimeout()
+	if err != nil {
+		return nil, err
+	}
 
-	args := []string{
-		"-cp",
-		classpath,
-		daemonClass,
-		r.pathToPropertiesFile,
+	cmd := exec.CommandContext(ctx, r.pathToJavaBinary, args...)
+	cmd.Cancel = func() error {
+		r.logger.Printf("Sending SIGTERM to java daemon, waiting up to %s for it to shut down.", shutdownTimeout)
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = shutdownTimeout
+	cmd.SysProcAttr = sysProcAttr()
+	cmd.Env, err = r.daemonEnv(ctx)
+	if err != nil {
+		return nil, err
 	}
-	args = append(javaProperties, args...)
 
-	cmd := exec.Command(r.pathToJavaBinary, args...)
+	if r.diagnosticsDir != "" {
+		if err = r.prepareDiagnostics(); err != nil {
+			return nil, err
+		}
+	}
 
-	err = pipeToLogger(r.logger, cmd.StdoutPipe)
+	output := &sync.WaitGroup{}
+	err = r.pipeOutput(cmd.StdoutPipe, r.stdoutLog, "stdout", output)
 	if err != nil {
 		return nil, err
 	}
 
-	err = pipeToLogger(r.logger, cmd.StderrPipe)
+	err = r.pipeOutput(cmd.StderrPipe, r.stderrLog, "stderr", output)
 	if err != nil {
 		return nil, err
 	}
@@ -135,17 +435,64 @@ func (r *Runner) RunJavaDaemon(javaProperties ...string) (*exec.Cmd, error) {
 	if err = cmd.Start(); err != nil {
 		return nil, errors.Wrap(err, "failed to run command to start java daemon")
 	}
+	r.health.daemonStart(cmd.Process.Pid)
+
+	return &daemonProcess{
+		cmd:     cmd,
+		output:  output,
+		tracker: r.trackProcesses(cmd.Process.Pid),
+		started: time.Now(),
+	}, nil
+}
+
+// javaArgs returns the arguments of the java binary that starts the daemon.
+// The JVM options come first, followed by javaProperties so that they can
+// override them.
+func (r *Runner) javaArgs(javaProperties []string) ([]string, error) {
+	daemonClass := "software.amazon.kinesis.multilang.MultiLangDaemon"
+	classpath, err := r.Classpath()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	if r.logConfigFile != "" {
+		args = append(args, "-Dlogback.configurationFile="+r.logConfigFile)
+	}
+	if r.crashDiagnostics != nil {
+		args = append(args, r.crashDiagnostics.args()...)
+	}
+	if r.jvmOptions != nil {
+		javaMajorVersion := 0
+		if r.javaVersion != nil {
+			javaMajorVersion = r.javaVersion.Major
+		}
 
-	return cmd, nil
+		jvmArgs, err := r.jvmOptions.args(javaMajorVersion)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid JVM options")
+		}
+		args = append(args, jvmArgs...)
+	}
+	args = append(args, javaProperties...)
+
+	return append(args,
+		"-cp",
+		classpath,
+		daemonClass,
+		r.pathToPropertiesFile,
+	), nil
 }
 
-func pipeToLogger(logger *log.Logger, getPipe func() (io.ReadCloser, error)) error {
+func pipeToLogger(logger *log.Logger, getPipe func() (io.ReadCloser, error), wg *sync.WaitGroup) error {
 	pipe, err := getPipe()
 	if err != nil {
 		return errors.Wrap(err, "failed to get pipe")
 	}
 
+	wg.Add(1)
 	go func(p io.ReadCloser) {
+		defer wg.Done()
 		scanner := bufio.NewScanner(pipe)
 		for scanner.Scan() {
 			logLine := scanner.Text()
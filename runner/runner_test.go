@@ -3,8 +3,12 @@ package runner
 import (
 	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -31,7 +35,7 @@ func TestPipeToLogger_SendsOutputToLogger(t *testing.T) {
 	}
 
 	// test
-	err := pipeToLogger(logger, getPipe)
+	err := pipeToLogger(logger, getPipe, &sync.WaitGroup{})
 	if err != nil {
 		t.Error(err)
 	}
@@ -53,10 +57,27 @@ func TestPipeToLogger_ReturnsErrorWhenGetPipeReturnsError(t *testing.T) {
 	}
 
 	// test
-	err := pipeToLogger(logger, getPipe)
+	err := pipeToLogger(logger, getPipe, &sync.WaitGroup{})
 
 	// validate
 	if err == nil {
 		t.Error("expected an error, but got nil")
 	}
 }
+
+func TestGracefulShutdownTimeout_AddsLeaseHandoffTimeout(t *testing.T) {
+	propertiesFile := filepath.Join(t.TempDir(), "some.properties")
+	err := os.WriteFile(propertiesFile, []byte("gracefulLeaseHandoffTimeoutMillis = 5000\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{pathToPropertiesFile: propertiesFile, shutdownTimeout: 10 * time.Second}
+	timeout, err := r.gracefulShutdownTimeout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout != 15*time.Second {
+		t.Errorf("expected a timeout of 15s but got %s", timeout)
+	}
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+	"github.com/pkg/errors"
+)
+
+// RecordProcessorFlag is the argument Main starts its own binary with to run
+// it as a record processor.
+const RecordProcessorFlag = "-kcl-record-processor"
+
+// WithExecutableName sets the executableName of the properties the daemon is
+// started with, overriding the one of the properties file or config.
+func WithExecutableName(name string) Option {
+	return func(runner *Runner) {
+		runner.executableName = name
+	}
+}
+
+// IsRecordProcessor reports whether Main runs the binary as a record
+// processor. Binaries that parse their own flags should do so only when it
+// returns false, since the daemon starts them with RecordProcessorFlag.
+func IsRecordProcessor() bool {
+	return len(os.Args) > 1 && os.Args[1] == RecordProcessorFlag
+}
+
+// Main lets a single binary be both the runner and its record processor.
+// Started by the daemon with RecordProcessorFlag it runs process. Otherwise it
+// supervises a daemon configured by opts whose executableName is the binary
+// itself with RecordProcessorFlag, until the binary receives SIGINT or
+// SIGTERM. Main returns once process or the daemon stopped.
+//
+//	func main() {
+//		process := kcl.GetKCLProcess(&myProcessor{})
+//		err := runner.Main(process,
+//			runner.WithPathToJarFolder("jar"),
+//			runner.WithPathToPropertiesFile("app.properties"),
+//		)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+func Main(process kcl.KCLProcess, opts ...Option) error {
+	if IsRecordProcessor() {
+		return process.Run()
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to find the path of the binary")
+	}
+	// The daemon splits executableName on whitespace.
+	if strings.ContainsAny(executable, " \t") {
+		return errors.Errorf("the path of the binary %s must not contain whitespace", executable)
+	}
+
+	r, err := GetRunner(append(opts, WithExecutableName(executable+" "+RecordProcessorFlag))...)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// The daemon is shut down gracefully when the binary is asked to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return r.Supervise(ctx)
+}
@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+type fakeProcess struct {
+	err error
+}
+
+func (p *fakeProcess) Run() error {
+	return p.err
+}
+
+func TestMain_RunsRecordProcessor(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"app", RecordProcessorFlag}
+
+	expected := errors.New("processed")
+	if err := Main(&fakeProcess{err: expected}); err != expected {
+		t.Errorf("expected the record processor's error but got %+v", err)
+	}
+}
+
+func TestMain_StartsDaemonWithItself(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake java fails unless the properties file, its last argument,
+	// runs this binary as the record processor.
+	base, _ := getTestRunner(t, `eval properties=\${$#}
+grep -qx "executableName = `+executable+` `+RecordProcessorFlag+`" "$properties"`)
+
+	err = Main(&fakeProcess{err: errors.New("unexpected run")},
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithPathToJavaBinary(base.pathToJavaBinary),
+		WithPathToJarFolder(base.pathToJarFolder),
+		WithPathToPropertiesFile(base.pathToPropertiesFile),
+		WithMaxRestarts(0),
+	)
+	if err != nil {
+		t.Errorf("unexpected error: %+v", err)
+	}
+}
+
+func TestWithExecutableName_OverridesConfig(t *testing.T) {
+	base, _ := getTestRunner(t, "")
+	r, err := GetRunner(
+		WithPathToJavaBinary(base.pathToJavaBinary),
+		WithPathToJarFolder(base.pathToJarFolder),
+		WithConfig(&Config{ExecutableName: "/bin/false", StreamName: "s", ApplicationName: "a"}),
+		WithExecutableName("/bin/true -flag"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer r.Close()
+
+	p, err := r.EffectiveProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := p.Get("executableName"); name != "/bin/true -flag" {
+		t.Errorf("expected the executableName to be overridden but got '%s'", name)
+	}
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/pkg/errors"
+)
+
+// defaultGracefulLeaseHandoffTimeout is the KCL default for
+// gracefulLeaseHandoffTimeoutMillis.
+const defaultGracefulLeaseHandoffTimeout = 30 * time.Second
+
+// gracefulShutdownTimeout returns how long to wait for the daemon to exit
+// after SIGTERM. When graceful lease handoff is enabled, which is the KCL
+// default, the daemon may wait for up to gracefulLeaseHandoffTimeoutMillis for
+// the next owner of each lease before it starts shutting down.
+func (r *Runner) gracefulShutdownTimeout() (time.Duration, error) {
+	p, err := properties.Load(r.pathToPropertiesFile)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read properties file")
+	}
+
+	enabled, err := strconv.ParseBool(p.GetDefault("isGracefulLeaseHandoffEnabled", "true"))
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid isGracefulLeaseHandoffEnabled")
+	}
+	if !enabled {
+		return r.shutdownTimeout, nil
+	}
+
+	handoffTimeout := defaultGracefulLeaseHandoffTimeout
+	if value, ok := p.Get("gracefulLeaseHandoffTimeoutMillis"); ok {
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "invalid gracefulLeaseHandoffTimeoutMillis")
+		}
+		handoffTimeout = time.Duration(millis) * time.Millisecond
+	}
+
+	return r.shutdownTimeout + handoffTimeout, nil
+}
+
+// describeShutdown returns nil if the daemon exited on its own after SIGTERM
+// and an error if it had to be killed.
+func describeShutdown(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+			return errors.New("java daemon did not shut down in time and was killed")
+		}
+	}
+
+	return nil
+}
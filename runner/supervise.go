@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Supervise runs the java daemon and restarts it whenever it crashes, waiting
+// with exponential backoff between restarts. A daemon that ran for at least
+// ten minutes before crashing resets the backoff and the crash count.
+//
+// Supervise returns nil once the daemon exits successfully, and an error if
+// the daemon can not be started, for example because the properties file is
+// invalid, or once it has crashed more times in a row than the maximum number
+// of restarts. When ctx is cancelled the daemon is shut down
+// as described in RunJavaDaemon and Supervise returns an error only if it had
+// to be killed. With a watchdog a daemon that stalls is dumped and, if
+// configured, stopped and restarted like a crashed one.
+func (r *Runner) Supervise(ctx context.Context, javaProperties ...string) error {
+	if r.healthAddr != "" {
+		stop, err := r.serveHealth()
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	backoff := r.initialRestartBackoff
+	crashes := 0
+
+	for {
+		started := time.Now()
+		d, err := r.startJavaDaemon(ctx, javaProperties)
+		if err != nil {
+			return err
+		}
+
+		var w *watchdog
+		if r.watchdog != nil {
+			w = r.watchDaemon(d)
+		}
+
+		err = waitForJavaDaemon(ctx, d)
+		stalled := w.stopWatching()
+		if stalled && ctx.Err() == nil {
+			err = errors.Errorf("stalled for %s", r.watchdog.StallWindow)
+		}
+		if ctx.Err() != nil {
+			r.health.daemonExit(err, crashes)
+			if err != nil {
+				r.logger.Printf("Java daemon failed to shut down: %s.", err)
+				return err
+			}
+			r.logger.Printf("Java daemon shut down after %s.", time.Since(started))
+			return nil
+		}
+
+		if err == nil {
+			r.health.daemonExit(nil, crashes)
+			r.logger.Println("Java daemon exited successfully.")
+			return nil
+		}
+
+		if time.Since(started) >= r.stableRunDuration {
+			backoff = r.initialRestartBackoff
+			crashes = 0
+		}
+
+		if r.crashDiagnostics != nil && !stalled {
+			r.reportCrash(d)
+		}
+
+		crashes++
+		r.health.daemonExit(err, crashes)
+		if crashes > r.maxRestarts {
+			return errors.Wrapf(err, "java daemon crashed %d times in a row", crashes)
+		}
+
+		r.logger.Printf("Java daemon crashed: %s. Restarting in %s (restart %d of %d).", err, backoff, crashes, r.maxRestarts)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+
+		backoff *= 2
+		if backoff > r.maxRestartBackoff {
+			backoff = r.maxRestartBackoff
+		}
+	}
+}
+
+// waitForJavaDaemon waits for the daemon to exit and terminates the record
+// processors it left behind. It returns an error
+// describing the exit code or signal unless the daemon exited with 0, or after
+// ctx is cancelled, unless it shut down before the shutdown timeout.
+func waitForJavaDaemon(ctx context.Context, d *daemonProcess) error {
+	d.output.Wait()
+	err := d.cmd.Wait()
+	d.tracker.wait()
+	if ctx.Err() != nil {
+		return describeShutdown(err)
+	}
+	if err == nil {
+		return nil
+	}
+
+	return describeExit(err)
+}
+
+// describeExit returns an error naming the exit code or signal of a process.
+func describeExit(err error) error {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return errors.Wrap(err, "failed waiting for java daemon")
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return errors.Errorf("killed by signal %s", status.Signal())
+	}
+
+	return errors.Errorf("exited with code %d", exitErr.ExitCode())
+}
@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getTestRunner returns a Runner whose java binary is a shell script that
+// appends a line to the runs file, available to the script as $RUNS, each
+// time it is started.
+func getTestRunner(t *testing.T, script string) (*Runner, string) {
+	dir := t.TempDir()
+
+	jarFolder := filepath.Join(dir, "jars")
+	if err := os.Mkdir(jarFolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jarFolder, "some.jar"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	propertiesFile := filepath.Join(dir, "some.properties")
+	err := os.WriteFile(propertiesFile, []byte("executableName = /bin/true\n"+
+		"streamName = some_stream\n"+
+		"applicationName = some_app\n"+
+		"isGracefulLeaseHandoffEnabled = false\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runsFile := filepath.Join(dir, "runs")
+	java := filepath.Join(dir, "java")
+	err = os.WriteFile(java, []byte("#!/bin/sh\n"+
+		"if [ \"$2\" = -version ]; then echo 'openjdk version \"17.0.2\"' >&2; exit 0; fi\n"+
+		"RUNS="+runsFile+"\necho run >> $RUNS\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := GetRunner(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithPathToJavaBinary(java),
+		WithPathToJarFolder(jarFolder),
+		WithPathToPropertiesFile(propertiesFile),
+		WithShutdownTimeout(100*time.Millisecond),
+		WithRestartBackoff(time.Millisecond, 4*time.Millisecond),
+		WithMaxRestarts(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, runsFile
+}
+
+func countRuns(t *testing.T, runsFile string) int {
+	runs, err := os.ReadFile(runsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(runs), "run")
+}
+
+func TestSupervise_GivesUpAfterMaxRestarts(t *testing.T) {
+	r, runsFile := getTestRunner(t, "exit 3")
+
+	err := r.Supervise(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exited with code 3") {
+		t.Errorf("expected an error with the exit code but got %+v", err)
+	}
+
+	if runs := countRuns(t, runsFile); runs != 3 {
+		t.Errorf("expected the daemon to be started 3 times but it was started %d times", runs)
+	}
+}
+
+func TestSupervise_RestartsUntilSuccessfulExit(t *testing.T) {
+	// Crash on the first two runs only.
+	r, runsFile := getTestRunner(t, `[ "$(wc -l < $RUNS)" -gt 2 ] || exit 1`)
+
+	if err := r.Supervise(context.Background()); err != nil {
+		t.Errorf("unexpected error: %+v", err)
+	}
+
+	if runs := countRuns(t, runsFile); runs != 3 {
+		t.Errorf("expected the daemon to be started 3 times but it was started %d times", runs)
+	}
+}
+
+func TestSupervise_ReportsSignal(t *testing.T) {
+	r, _ := getTestRunner(t, "kill -9 $$")
+	r.maxRestarts = 0
+
+	err := r.Supervise(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "killed by signal killed") {
+		t.Errorf("expected an error with the signal but got %+v", err)
+	}
+}
+
+func TestSupervise_StopsDaemonWhenContextIsCancelled(t *testing.T) {
+	r, _ := getTestRunner(t, "exec sleep 10")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	if err := r.Supervise(ctx); err != nil {
+		t.Errorf("expected the daemon to shut down on SIGTERM but got %+v", err)
+	}
+}
+
+func TestSupervise_KillsDaemonAfterShutdownTimeout(t *testing.T) {
+	// Ignore SIGTERM so only SIGKILL stops the daemon.
+	r, _ := getTestRunner(t, "trap '' TERM\nexec sleep 10")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	started := time.Now()
+	err := r.Supervise(ctx)
+	if err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("expected an error saying the daemon was killed but got %+v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected the daemon to be killed after the shutdown timeout but it took %s", elapsed)
+	}
+}
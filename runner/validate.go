@@ -0,0 +1,298 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+	"github.com/pkg/errors"
+)
+
+// untypedKeys are properties understood by the MultiLangDaemon that Config
+// has no field for. Their values are not checked.
+var untypedKeys = []string{
+	"metricsLevel",
+	"metricsEnabledDimensions",
+	"maxGetRecordsThreadPool",
+	"retryGetRecordsInSeconds",
+	"maxLeaseRenewalThreads",
+	"listShardsBackoffTimeInMillis",
+	"maxListShardsRetryAttempts",
+	"timeoutInSeconds",
+	"shardPrioritization",
+	"skipShardSyncAtWorkerInitializationIfLeasesExist",
+	"initialLeaseTableReadCapacity",
+	"initialLeaseTableWriteCapacity",
+	"userAgentPrefix",
+	"streamArn",
+	"coordinatorStateTags",
+	"workerMetricsTags",
+}
+
+// Severity is how serious a Problem is. The daemon fails to start or
+// misbehaves with an error, while a warning is most likely a mistake.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is an issue found in a properties file. Line is 0 for problems that
+// are not about a single line, such as a missing key.
+type Problem struct {
+	Line     int
+	Key      string
+	Severity Severity
+	Message  string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Severity, p.Message)
+}
+
+// HasErrors reports whether any of the problems is an error.
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePropertiesFile reads the properties file at path and validates it
+// with ValidateProperties. It only returns an error if the file can not be
+// read.
+func ValidatePropertiesFile(path string) ([]Problem, error) {
+	p, err := properties.Load(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read properties file")
+	}
+
+	return ValidateProperties(p), nil
+}
+
+// ValidateProperties checks that the required keys are present, that every
+// value has the right type, that executableName resolves to an executable
+// relative to the working directory and that there are no unknown keys. It
+// suggests the closest known key for unknown keys.
+func ValidateProperties(p *properties.Properties) []Problem {
+	problems := []Problem{}
+
+	c := &Config{}
+	fields := map[string]configField{}
+	knownKeys := append([]string(nil), untypedKeys...)
+	for _, f := range c.fields() {
+		fields[f.key] = f
+		knownKeys = append(knownKeys, f.key)
+	}
+
+	lines := map[string]int{}
+	for _, e := range p.Entries() {
+		if previous, ok := lines[e.Key]; ok {
+			problems = append(problems, Problem{
+				Line:     e.Line,
+				Key:      e.Key,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("%s is also set on line %d, the last value wins", e.Key, previous),
+			})
+		}
+		lines[e.Key] = e.Line
+
+		f, ok := fields[e.Key]
+		if !ok {
+			if !contains(untypedKeys, e.Key) {
+				problems = append(problems, Problem{
+					Line:     e.Line,
+					Key:      e.Key,
+					Severity: SeverityWarning,
+					Message:  unknownKeyMessage(e.Key, knownKeys),
+				})
+			}
+			continue
+		}
+
+		if e.Value == "" {
+			continue
+		}
+		if err := f.parseProperty(e.Value); err != nil {
+			problems = append(problems, Problem{
+				Line:     e.Line,
+				Key:      e.Key,
+				Severity: SeverityError,
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	if HasErrors(problems) {
+		return problems
+	}
+
+	// The remaining checks need every value to have parsed.
+	if err := c.Validate(); err != nil {
+		problems = append(problems, Problem{
+			Severity: SeverityError,
+			Message:  err.Error(),
+		})
+	}
+
+	if c.ExecutableName != "" {
+		if err := checkExecutable(c.ExecutableName); err != nil {
+			problems = append(problems, Problem{
+				Line:     lines["executableName"],
+				Key:      "executableName",
+				Severity: SeverityError,
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	return problems
+}
+
+// parseProperty sets the field from its value in a properties file, where
+// durations are milliseconds or a java.time.Duration and times are seconds
+// since the epoch.
+func (f configField) parseProperty(value string) error {
+	var err error
+	switch dst := f.dst.(type) {
+	case *time.Duration:
+		if f.unit == iso8601 {
+			*dst, err = parseISO8601(value)
+			break
+		}
+		var ms int64
+		ms, err = strconv.ParseInt(value, 10, 64)
+		*dst = time.Duration(ms) * time.Millisecond
+	case *time.Time:
+		var seconds int64
+		seconds, err = strconv.ParseInt(value, 10, 64)
+		*dst = time.Unix(seconds, 0)
+	default:
+		return f.parse(value)
+	}
+
+	if err != nil {
+		return errors.Errorf("invalid %s '%s'", f.key, value)
+	}
+	return nil
+}
+
+var iso8601Pattern = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISO8601 parses the java.time.Duration format, e.g. PT15M or P2D.
+func parseISO8601(s string) (time.Duration, error) {
+	m := iso8601Pattern.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil || s == "P" || strings.HasSuffix(strings.ToUpper(s), "T") {
+		return 0, errors.Errorf("invalid duration '%s'", s)
+	}
+
+	var d time.Duration
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute}
+	for i, unit := range units {
+		if m[i+1] != "" {
+			n, _ := strconv.ParseInt(m[i+1], 10, 64)
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[4] != "" {
+		seconds, _ := strconv.ParseFloat(m[4], 64)
+		d += time.Duration(seconds * float64(time.Second))
+	}
+
+	return d, nil
+}
+
+// checkExecutable checks that the program of executableName, which may be
+// followed by arguments, is an executable file. Paths are relative to the
+// working directory, which is where the MultiLangDaemon starts it from, and
+// other names are looked up in PATH.
+func checkExecutable(executableName string) error {
+	program := strings.Fields(executableName)[0]
+	if !strings.Contains(program, string(os.PathSeparator)) {
+		if _, err := exec.LookPath(program); err != nil {
+			return errors.Errorf("executableName '%s' was not found in PATH", program)
+		}
+		return nil
+	}
+
+	info, err := os.Stat(program)
+	if err != nil {
+		return errors.Errorf("executableName '%s' does not exist", program)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return errors.Errorf("executableName '%s' is not executable", program)
+	}
+	return nil
+}
+
+// unknownKeyMessage describes an unknown key and suggests the closest known
+// key if there is one that is likely to be a typo of it.
+func unknownKeyMessage(key string, knownKeys []string) string {
+	best := ""
+	bestDistance := 0
+	for _, known := range knownKeys {
+		d := levenshtein(strings.ToLower(key), strings.ToLower(known))
+		if best == "" || d < bestDistance {
+			best = known
+			bestDistance = d
+		}
+	}
+
+	if best != "" && bestDistance <= 3 && bestDistance < len(key)/2 {
+		return fmt.Sprintf("unknown key %s, did you mean %s?", key, best)
+	}
+	return fmt.Sprintf("unknown key %s", key)
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	m := first
+	for _, n := range rest {
+		if n < m {
+			m = n
+		}
+	}
+	return m
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/properties"
+)
+
+func validate(t *testing.T, content string) []string {
+	p, err := properties.Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := []string{}
+	for _, problem := range ValidateProperties(p) {
+		problems = append(problems, problem.String())
+	}
+	return problems
+}
+
+const validProperties = "executableName = /bin/sh\n" +
+	"streamName = some_stream\n" +
+	"applicationName = some_app\n"
+
+func TestValidateProperties_AcceptsValidFile(t *testing.T) {
+	problems := validate(t, validProperties+
+		"initialPositionInStream = TRIM_HORIZON\n"+
+		"failoverTimeMillis = 10000\n"+
+		"clientVersionConfig = CLIENT_VERSION_CONFIG_COMPATIBLE_WITH_2x\n"+
+		"staleWorkerMetricsEntryCleanupDuration = PT15M\n"+
+		"metricsLevel = NONE\n"+
+		"# comments are ignored = true\n")
+
+	if len(problems) != 0 {
+		t.Errorf("expected no problems but got %v", problems)
+	}
+}
+
+func TestValidateProperties_SuggestsKnownKeys(t *testing.T) {
+	problems := validate(t, validProperties+
+		"AWSCredentialsProvider = DefaultCredentialsProvider\n"+
+		"failoverTimeMilis = 10000\n"+
+		"somethingElseEntirely = 1\n")
+
+	expected := []string{
+		"line 4: warning: unknown key AWSCredentialsProvider, did you mean AwsCredentialsProvider?",
+		"line 5: warning: unknown key failoverTimeMilis, did you mean failoverTimeMillis?",
+		"line 6: warning: unknown key somethingElseEntirely",
+	}
+	if strings.Join(problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected problems %v but got %v", expected, problems)
+	}
+}
+
+func TestValidateProperties_ReportsTypeErrors(t *testing.T) {
+	problems := validate(t, validProperties+
+		"failoverTimeMillis = 10s\n"+
+		"cleanupLeasesUponShardCompletion = yes please\n")
+
+	expected := []string{
+		"line 4: error: invalid failoverTimeMillis '10s'",
+		"line 5: error: invalid cleanupLeasesUponShardCompletion",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected problems %v but got %v", expected, problems)
+	}
+	for i := range expected {
+		if !strings.HasPrefix(problems[i], expected[i]) {
+			t.Errorf("expected a problem starting with '%s' but got '%s'", expected[i], problems[i])
+		}
+	}
+}
+
+func TestValidateProperties_ReportsInvalidConfig(t *testing.T) {
+	tests := map[string]string{
+		"streamName = some_stream\napplicationName = some_app\n":                       "error: missing executableName",
+		validProperties + "initialPositionInStream = BEGINNING\n":                      "error: invalid initialPositionInStream 'BEGINNING'",
+		validProperties + "clientVersionConfig = CLIENT_VERSION_CONFIG_4X\n":           "error: invalid clientVersionConfig 'CLIENT_VERSION_CONFIG_4X'",
+		validProperties + "streamName = other_stream\n":                                "line 4: warning: streamName is also set on line 2, the last value wins",
+		"executableName = ./does/not/exist\nstreamName = s\napplicationName = a\n":     "line 1: error: executableName './does/not/exist' does not exist",
+		"executableName = no-such-program --flag\nstreamName = s\napplicationName = a": "line 1: error: executableName 'no-such-program' was not found in PATH",
+	}
+
+	for content, expected := range tests {
+		problems := validate(t, content)
+		if len(problems) != 1 || problems[0] != expected {
+			t.Errorf("expected the problem '%s' but got %v", expected, problems)
+		}
+	}
+}
+
+func TestValidatePropertiesFile_ReturnsErrorForMissingFile(t *testing.T) {
+	_, err := ValidatePropertiesFile(filepath.Join(t.TempDir(), "missing.properties"))
+	if err == nil {
+		t.Error("expected an error, but got nil")
+	}
+}
+
+func TestParseISO8601(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT15M":   15 * time.Minute,
+		"PT10H":   10 * time.Hour,
+		"P2D":     48 * time.Hour,
+		"PT1.5S":  1500 * time.Millisecond,
+		"P1DT30M": 24*time.Hour + 30*time.Minute,
+	}
+	for s, expected := range tests {
+		d, err := parseISO8601(s)
+		if err != nil || d != expected {
+			t.Errorf("expected %s to be %s but got %s, %+v", s, expected, d, err)
+		}
+	}
+
+	for _, s := range []string{"15M", "PT", "P", "PT15X"} {
+		if _, err := parseISO8601(s); err == nil {
+			t.Errorf("expected an error for '%s'", s)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// minWatchdogInterval and maxWatchdogInterval bound how often the
+	// watchdog checks for activity.
+	minWatchdogInterval = 10 * time.Millisecond
+	maxWatchdogInterval = 10 * time.Second
+	// threadDumpTimeout is how long jcmd has to print a thread dump.
+	threadDumpTimeout = 30 * time.Second
+)
+
+var (
+	// threadDumpOutputWait is how long the daemon's stdout is copied to the
+	// thread dump after SIGQUIT.
+	threadDumpOutputWait = 2 * time.Second
+	// goroutineDumpWait is how long record processors have to write their
+	// goroutine dumps before a stalled daemon is restarted.
+	goroutineDumpWait = time.Second
+)
+
+// WatchdogConfig configures how Supervise detects a stalled daemon.
+type WatchdogConfig struct {
+	// StallWindow is how long the daemon may go without logging anything
+	// while no record processor checkpoints before it is considered stalled.
+	// It should be longer than the interval the record processors checkpoint
+	// at.
+	StallWindow time.Duration
+	// Restart restarts a stalled daemon once the dumps are written.
+	// Otherwise the daemon keeps running and the dumps are written once per
+	// stall.
+	Restart bool
+}
+
+// WithWatchdog has Supervise watch the daemon for stalls. When the daemon
+// stalls a thread dump of the JVM and a goroutine dump of every record
+// processor built with the kcl package are written to the diagnostics
+// folder. Checkpoints and goroutine dumps of record processors are only seen
+// on Unix systems, and on Linux only record processors of the daemon are
+// asked for goroutine dumps. The stall window must be positive.
+func WithWatchdog(c WatchdogConfig) Option {
+	return func(runner *Runner) {
+		runner.watchdog = &c
+	}
+}
+
+// outputCapture copies the lines of the daemon's output to a writer while a
+// thread dump printed by the JVM is captured.
+type outputCapture struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (c *outputCapture) line(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.w != nil {
+		fmt.Fprintln(c.w, line)
+	}
+}
+
+func (c *outputCapture) start(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w = w
+}
+
+func (c *outputCapture) stop() {
+	c.start(nil)
+}
+
+// watchdog watches a daemon started by Supervise for stalls.
+type watchdog struct {
+	r    *Runner
+	d    *daemonProcess
+	stop chan struct{}
+	done chan struct{}
+
+	// restarted is set once the watchdog stopped the daemon.
+	restarted atomic.Bool
+}
+
+// watchDaemon watches d until stopWatching is called.
+func (r *Runner) watchDaemon(d *daemonProcess) *watchdog {
+	w := &watchdog{
+		r:    r,
+		d:    d,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// stopWatching stops the watchdog and reports whether it stopped the daemon.
+// It does nothing on a nil receiver.
+func (w *watchdog) stopWatching() bool {
+	if w == nil {
+		return false
+	}
+
+	close(w.stop)
+	<-w.done
+	return w.restarted.Load()
+}
+
+func (w *watchdog) run() {
+	defer close(w.done)
+
+	window := w.r.watchdog.StallWindow
+	interval := window / 10
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+	if interval > maxWatchdogInterval {
+		interval = maxWatchdogInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// reported is the last activity before the stall that was reported.
+	var reported time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+
+		last := w.lastActivity()
+		idle := time.Since(last)
+		if idle < window || !last.After(reported) {
+			continue
+		}
+		reported = last
+
+		w.r.logger.Printf("Java daemon stalled: no output and no checkpoint for %s. Writing diagnostics to %s.", idle.Round(time.Second), w.r.diagnosticsDir)
+		w.dump()
+
+		if w.r.watchdog.Restart {
+			w.restart()
+			return
+		}
+	}
+}
+
+// lastActivity returns when the daemon last logged a line, a record processor
+// last checkpointed or registered, or the daemon started, whichever is
+// latest.
+func (w *watchdog) lastActivity() time.Time {
+	last := w.d.started
+	if output := time.Unix(0, w.r.lastOutput.Load()); output.After(last) {
+		last = output
+	}
+
+	files, err := os.ReadDir(w.r.processorsDir())
+	if err != nil {
+		return last
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// dump asks the record processors for goroutine dumps and writes a thread
+// dump of the JVM.
+func (w *watchdog) dump() {
+	if err := os.MkdirAll(w.r.diagnosticsDir, 0o755); err != nil {
+		w.r.logger.Printf("Failed to make diagnostics folder: %s.", err)
+		return
+	}
+
+	processors := w.processors()
+	for _, pid := range processors {
+		if err := signalProcess(pid, goroutineDumpSignal); err != nil {
+			w.r.logger.Printf("Failed to ask record processor %d for a goroutine dump: %s.", pid, err)
+		}
+	}
+	if len(processors) > 0 {
+		w.r.logger.Printf("Asked %d record processors for goroutine dumps.", len(processors))
+	}
+
+	path := filepath.Join(w.r.diagnosticsDir, "threads-"+time.Now().UTC().Format("20060102T150405.000Z")+".txt")
+	if err := w.r.writeThreadDump(w.d.cmd.Process, path); err != nil {
+		w.r.logger.Printf("Failed to write thread dump of the java daemon: %s.", err)
+	} else {
+		w.r.logger.Printf("Wrote thread dump of the java daemon to %s.", path)
+	}
+
+	// Goroutine dumps that record processors are still writing are rotated
+	// the next time.
+	w.r.rotateDiagnostics()
+}
+
+// processors returns the PIDs of the record processors registered in the
+// diagnostics folder that are still running and, where processes can be
+// listed, belong to the daemon.
+func (w *watchdog) processors() []int {
+	files, err := os.ReadDir(w.r.processorsDir())
+	if err != nil {
+		return nil
+	}
+
+	table, err := processTable()
+	var daemonProcesses map[int]processInfo
+	if err == nil {
+		daemonProcesses = descendants(table, w.d.cmd.Process.Pid)
+	}
+
+	pids := []int{}
+	for _, f := range files {
+		pid, err := strconv.Atoi(f.Name())
+		if err != nil {
+			continue
+		}
+		if daemonProcesses != nil {
+			if _, ok := daemonProcesses[pid]; !ok {
+				continue
+			}
+		} else if signalProcess(pid, 0) != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// restart stops the stalled daemon so that Supervise restarts it. The daemon
+// is sent SIGTERM and killed if it is still running after the shutdown
+// timeout.
+func (w *watchdog) restart() {
+	w.restarted.Store(true)
+
+	select {
+	case <-time.After(goroutineDumpWait):
+	case <-w.stop:
+		return
+	}
+
+	timeout, err := w.r.gracefulShutdownTimeout()
+	if err != nil {
+		timeout = w.r.shutdownTimeout
+	}
+	w.r.logger.Printf("Restarting stalled java daemon, waiting up to %s for it to shut down.", timeout)
+	if err = w.d.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		w.d.cmd.Process.Kill()
+		return
+	}
+
+	select {
+	case <-time.After(timeout):
+		w.r.logger.Println("Killing stalled java daemon.")
+		w.d.cmd.Process.Kill()
+	case <-w.stop:
+	}
+}
+
+// writeThreadDump writes a thread dump of the JVM process to path. It uses
+// the jcmd binary next to the java binary if there is one, and otherwise
+// sends SIGQUIT and copies what the JVM prints to its stdout.
+func (r *Runner) writeThreadDump(process *os.Process, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create thread dump")
+	}
+	defer f.Close()
+
+	if jcmd := r.jcmdPath(); jcmd != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), threadDumpTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, jcmd, strconv.Itoa(process.Pid), "Thread.print", "-l")
+		cmd.Stdout = f
+		cmd.Stderr = f
+		if err = cmd.Run(); err == nil {
+			return f.Close()
+		}
+		r.logger.Printf("Failed to run %s: %s, sending SIGQUIT instead.", jcmd, err)
+		if err = f.Truncate(0); err != nil {
+			return errors.Wrap(err, "failed to truncate thread dump")
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, "failed to truncate thread dump")
+		}
+	}
+
+	r.outputCapture.start(f)
+	defer r.outputCapture.stop()
+	if err = process.Signal(syscall.SIGQUIT); err != nil {
+		return errors.Wrap(err, "failed to send SIGQUIT")
+	}
+	time.Sleep(threadDumpOutputWait)
+	r.outputCapture.stop()
+
+	return f.Close()
+}
+
+// jcmdPath returns the path of the jcmd binary of the JDK the java binary
+// belongs to, or an empty string if it has none, e.g. because it is a JRE.
+func (r *Runner) jcmdPath() string {
+	java, err := exec.LookPath(r.pathToJavaBinary)
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(java); err == nil {
+		java = resolved
+	}
+
+	jcmd := filepath.Join(filepath.Dir(java), "jcmd"+filepath.Ext(java))
+	if _, err = os.Stat(jcmd); err != nil {
+		return ""
+	}
+	return jcmd
+}
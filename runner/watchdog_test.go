@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+)
+
+func TestSupervise_RestartsStalledDaemon(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test daemon is a shell script")
+	}
+
+	defer func(output, goroutines time.Duration) {
+		threadDumpOutputWait, goroutineDumpWait = output, goroutines
+	}(threadDumpOutputWait, goroutineDumpWait)
+	threadDumpOutputWait = 200 * time.Millisecond
+	goroutineDumpWait = 200 * time.Millisecond
+
+	// The first daemon starts a record processor that registers for
+	// diagnostics and then neither of them prints anything. The JVM prints
+	// its thread dump on SIGQUIT.
+	r, runsFile := getTestRunner(t, `
+if [ "$(wc -l < $RUNS)" -gt 1 ]; then exit 0; fi
+trap 'echo "Full thread dump fake"' QUIT
+sh -c 'trap "touch $GO_KCL_DIAGNOSTICS_DIR/goroutines-\$\$" USR1; touch $GO_KCL_DIAGNOSTICS_DIR/processors/$$; while :; do sleep 0.05; done' &
+while :; do sleep 0.05; done`)
+	r.watchdog = &WatchdogConfig{StallWindow: 300 * time.Millisecond, Restart: true}
+	r.diagnosticsDir = t.TempDir()
+	r.orphanGracePeriod = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.Supervise(ctx); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if runs := countRuns(t, runsFile); runs != 2 {
+		t.Errorf("expected the stalled daemon to be restarted once, but it ran %d times", runs)
+	}
+
+	threadDumps, err := filepath.Glob(filepath.Join(r.diagnosticsDir, "threads-*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threadDumps) != 1 {
+		t.Fatalf("expected one thread dump, got %v", threadDumps)
+	}
+	data, err := os.ReadFile(threadDumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Full thread dump fake") {
+		t.Errorf("expected the thread dump printed by the daemon, got '%s'", data)
+	}
+
+	if runtime.GOOS == "linux" {
+		goroutineDumps, err := filepath.Glob(filepath.Join(r.diagnosticsDir, "goroutines-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(goroutineDumps) != 1 {
+			t.Errorf("expected the record processor to be asked for a goroutine dump, got %v", goroutineDumps)
+		}
+	}
+}
+
+func TestWatchdog_LastActivity(t *testing.T) {
+	r := &Runner{diagnosticsDir: t.TempDir()}
+	if err := r.resetProcessorsDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now().Add(-time.Hour)
+	w := &watchdog{r: r, d: &daemonProcess{started: started}}
+	if last := w.lastActivity(); !last.Equal(started) {
+		t.Errorf("expected the start of the daemon, got %s", last)
+	}
+
+	output := started.Add(time.Minute)
+	r.lastOutput.Store(output.UnixNano())
+	if last := w.lastActivity(); !last.Equal(output) {
+		t.Errorf("expected the last output, got %s", last)
+	}
+
+	registration := filepath.Join(r.diagnosticsDir, kcl.ProcessorsDir, "123")
+	if err := os.WriteFile(registration, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := output.Add(time.Minute)
+	if err := os.Chtimes(registration, checkpoint, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if last := w.lastActivity(); !last.Equal(checkpoint) {
+		t.Errorf("expected the last checkpoint, got %s", last)
+	}
+}
+
+func TestGetRunner_RejectsStallWindowThatIsNotPositive(t *testing.T) {
+	base, _ := getTestRunner(t, "")
+	_, err := GetRunner(
+		WithPathToJavaBinary(base.pathToJavaBinary),
+		WithPathToJarFolder(base.pathToJarFolder),
+		WithPathToPropertiesFile(base.pathToPropertiesFile),
+		WithWatchdog(WatchdogConfig{}),
+	)
+	if err == nil || !strings.Contains(err.Error(), "invalid stall window") {
+		t.Errorf("expected an invalid stall window error but got %+v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/shim"
+)
+
+const (
+	// command line flag names
+	socketKey = "socket"
+)
+
+func main() {
+	var socketPath string
+	flag.StringVar(&socketPath, socketKey, "", "The path to the Unix socket of the kcl server")
+
+	var dialTimeout time.Duration
+	flag.DurationVar(&dialTimeout, "dial-timeout", 10*time.Second, "How long to wait for the kcl server to accept connections")
+
+	flag.Parse()
+
+	if socketPath == "" {
+		socketPath = os.Getenv(strings.ToUpper(socketKey))
+	}
+
+	if socketPath == "" {
+		// Anything written to STDOUT is read by the MultiLangDaemon, so errors
+		// go to STDERR.
+		fmt.Fprintf(os.Stderr, "Must provide %s\n", socketKey)
+		os.Exit(1)
+	}
+
+	if err := shim.Run(socketPath, dialTimeout, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,58 @@
+// Package shim forwards the multi-language protocol between the
+// MultiLangDaemon and a kcl.Server. Configure the shim binary as the
+// executableName so that one long-lived server process hosts the record
+// processors for every shard instead of one process per shard.
+package shim
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Run connects to the server listening on socketPath, retrying for up to
+// dialTimeout in case the server is still starting, then copies stdin to the
+// server and the server's responses to stdout. When stdin is closed the write
+// side of the connection is closed so that the server can tear down the
+// shard's record processor, and Run returns once the server closes the
+// connection.
+func Run(socketPath string, dialTimeout time.Duration, stdin io.Reader, stdout io.Writer) error {
+	conn, err := dial(socketPath, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, stdin)
+		conn.CloseWrite()
+	}()
+
+	if _, err = io.Copy(stdout, conn); err != nil {
+		return errors.Wrap(err, "failed to copy from server")
+	}
+
+	return nil
+}
+
+func dial(socketPath string, timeout time.Duration) (*net.UnixConn, error) {
+	addr := &net.UnixAddr{Name: socketPath, Net: "unix"}
+	deadline := time.Now().Add(timeout)
+	backoff := 50 * time.Millisecond
+
+	for {
+		conn, err := net.DialUnix("unix", nil, addr)
+		if err == nil {
+			return conn, nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, errors.Wrap(err, "failed to connect to kcl server")
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2 // exponentially backoff
+	}
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// Attribute names of the snapshot table.
+const (
+	attrShardID        = "shardId"
+	attrSequenceNumber = "sequenceNumber"
+	attrState          = "state"
+)
+
+type dynamoBackend struct {
+	client    dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBBackend returns a Backend that keeps snapshots in a DynamoDB
+// table with a string hash key named shardId and a string range key named
+// sequenceNumber. The state is stored as a single binary attribute, so a
+// snapshot must fit in a 400KB item.
+func NewDynamoDBBackend(client dynamodbiface.DynamoDBAPI, tableName string) Backend {
+	return &dynamoBackend{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+func (b *dynamoBackend) key(shardID, sequenceNumber string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		attrShardID:        {S: aws.String(shardID)},
+		attrSequenceNumber: {S: aws.String(sequenceNumber)},
+	}
+}
+
+func (b *dynamoBackend) Save(snapshot *Snapshot) error {
+	state, err := json.Marshal(snapshot.State)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal state")
+	}
+
+	item := b.key(snapshot.ShardID, snapshot.SequenceNumber)
+	item[attrState] = &dynamodb.AttributeValue{B: state}
+
+	_, err = b.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(b.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to put snapshot")
+	}
+
+	return nil
+}
+
+func (b *dynamoBackend) Load(shardID, sequenceNumber string) (*Snapshot, error) {
+	output, err := b.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(b.tableName),
+		Key:            b.key(shardID, sequenceNumber),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get snapshot")
+	}
+
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+
+	snapshot := &Snapshot{
+		ShardID:        shardID,
+		SequenceNumber: sequenceNumber,
+	}
+	if attr, ok := output.Item[attrState]; ok {
+		if err = json.Unmarshal(attr.B, &snapshot.State); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal state")
+		}
+	}
+
+	return snapshot, nil
+}
+
+func (b *dynamoBackend) Delete(shardID, sequenceNumber string) error {
+	_, err := b.client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(b.tableName),
+		Key:       b.key(shardID, sequenceNumber),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete snapshot")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+type fileBackend struct {
+	dir string
+}
+
+// NewFileBackend returns a Backend that keeps each snapshot in a JSON file
+// under dir. It is only suitable while a shard is always processed on the
+// same host, otherwise use a shared backend such as DynamoDB.
+func NewFileBackend(dir string) Backend {
+	return &fileBackend{dir: dir}
+}
+
+func (b *fileBackend) path(shardID, sequenceNumber string) string {
+	return filepath.Join(b.dir, shardID, sequenceNumber+".json")
+}
+
+// Save writes the snapshot to a temporary file and renames it into place so
+// that a crash never leaves a partial snapshot.
+func (b *fileBackend) Save(snapshot *Snapshot) error {
+	bytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal snapshot")
+	}
+
+	path := b.path(snapshot.ShardID, snapshot.SequenceNumber)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create snapshot directory")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".snapshot-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create snapshot file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write snapshot file")
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to sync snapshot file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to close snapshot file")
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to rename snapshot file")
+	}
+
+	return nil
+}
+
+func (b *fileBackend) Load(shardID, sequenceNumber string) (*Snapshot, error) {
+	bytes, err := ioutil.ReadFile(b.path(shardID, sequenceNumber))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read snapshot file")
+	}
+
+	var snapshot Snapshot
+	if err = json.Unmarshal(bytes, &snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal snapshot")
+	}
+
+	return &snapshot, nil
+}
+
+func (b *fileBackend) Delete(shardID, sequenceNumber string) error {
+	err := os.Remove(b.path(shardID, sequenceNumber))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete snapshot file")
+	}
+	return nil
+}
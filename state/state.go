@@ -0,0 +1,320 @@
+// Package state provides per-partition-key state for a kcl.RecordProcessor
+// that survives lease moves and restarts.
+//
+// The Processor wraps a record processor and gives it a Store. Every
+// checkpoint first saves a snapshot of the Store to a Backend under the
+// checkpointed sequence number, so the snapshot and the checkpoint always
+// match. When a shard is initialized the snapshot for its last checkpoint is
+// loaded back into the Store. Records after the checkpoint are delivered again
+// and are applied to the restored state. Since the Store holds the effects of
+// every delivered record, a processor can only checkpoint the last delivered
+// record.
+package state
+
+import (
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+	"github.com/pkg/errors"
+)
+
+var defaultLogger = log.New(ioutil.Discard, "", log.LstdFlags)
+
+// Checkpoint values that are not sequence numbers. A shard at one of these
+// has no snapshot and starts with an empty Store.
+var sentinelCheckpoints = map[string]bool{
+	"":             true,
+	"TRIM_HORIZON": true,
+	"LATEST":       true,
+	"AT_TIMESTAMP": true,
+	"SHARD_END":    true,
+}
+
+// Snapshot is the state of a shard at a checkpoint.
+type Snapshot struct {
+	ShardID        string            `json:"shardId"`
+	SequenceNumber string            `json:"sequenceNumber"`
+	State          map[string][]byte `json:"state"`
+}
+
+// Backend persists snapshots. A shard can have more than one snapshot at a
+// time since a snapshot is saved before its checkpoint is known to succeed.
+type Backend interface {
+	Save(snapshot *Snapshot) error
+	// Load returns nil, and no error, if there is no snapshot for the
+	// sequence number.
+	Load(shardID, sequenceNumber string) (*Snapshot, error)
+	// Delete does nothing if there is no snapshot for the sequence number.
+	Delete(shardID, sequenceNumber string) error
+}
+
+// Store holds the state of a single shard keyed by partition key, or by any
+// other key the processor chooses. It is not safe for concurrent use.
+type Store struct {
+	values map[string][]byte
+}
+
+func newStore() *Store {
+	return &Store{values: map[string][]byte{}}
+}
+
+// Get returns the value for key and whether it was set.
+func (s *Store) Get(key string) ([]byte, bool) {
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set sets the value for key. The Store keeps value, so it must not be
+// modified afterwards.
+func (s *Store) Set(key string, value []byte) {
+	s.values[key] = value
+}
+
+// Delete removes key.
+func (s *Store) Delete(key string) {
+	delete(s.values, key)
+}
+
+// Keys returns every key in sorted order.
+func (s *Store) Keys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len returns the number of keys.
+func (s *Store) Len() int {
+	return len(s.values)
+}
+
+func (s *Store) snapshot(shardID, sequenceNumber string) *Snapshot {
+	values := make(map[string][]byte, len(s.values))
+	for key, value := range s.values {
+		values[key] = value
+	}
+	return &Snapshot{
+		ShardID:        shardID,
+		SequenceNumber: sequenceNumber,
+		State:          values,
+	}
+}
+
+func (s *Store) restore(snapshot *Snapshot) {
+	s.values = map[string][]byte{}
+	for key, value := range snapshot.State {
+		s.values[key] = value
+	}
+}
+
+func (s *Store) reset() {
+	s.values = map[string][]byte{}
+}
+
+// ProcessorFactory returns the record processor for a shard. The processor
+// reads and writes its state through store.
+type ProcessorFactory func(store *Store) kcl.RecordProcessor
+
+// Option signifies the type of options that can be passed to the Processor.
+type Option func(*Processor)
+
+// WithLogger adds a logger option.
+func WithLogger(l *log.Logger) Option {
+	return func(p *Processor) {
+		p.logger = l
+	}
+}
+
+// Processor is a kcl.RecordProcessor that snapshots the Store of a single
+// shard with each checkpoint.
+type Processor struct {
+	backend   Backend
+	processor kcl.RecordProcessor
+	store     *Store
+	logger    *log.Logger
+
+	shardID string
+	// committed is the sequence number of the snapshot matching the last
+	// successful checkpoint.
+	committed string
+	// lastDelivered is the sequence number of the last record passed to the
+	// processor, which a nil checkpoint refers to.
+	lastDelivered string
+	// err is set when the snapshot could not be loaded. The shard is not
+	// processed further.
+	err error
+}
+
+// GetProcessor returns a Processor that stores snapshots in backend and
+// passes its Store to the processor returned by newProcessor. Use a new
+// Processor for every shard.
+func GetProcessor(backend Backend, newProcessor ProcessorFactory, opts ...Option) *Processor {
+	p := &Processor{
+		backend: backend,
+		store:   newStore(),
+		logger:  defaultLogger,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.processor = newProcessor(p.store)
+	return p
+}
+
+// Initialize restores the Store from the snapshot for the shard's last
+// checkpoint. If the snapshot cannot be loaded the error is logged and
+// returned by Err, and the processor is not called for the shard, so that the
+// KCL process stops and the shard is retried rather than processed with
+// missing state.
+func (p *Processor) Initialize(input *kcl.InitializationInput) {
+	p.shardID = input.ShardID
+	p.committed = ""
+	p.lastDelivered = ""
+	p.err = nil
+	p.store.reset()
+
+	if !sentinelCheckpoints[input.SequenceNumber] {
+		snapshot, err := p.backend.Load(p.shardID, input.SequenceNumber)
+		if err != nil {
+			p.err = errors.Wrapf(err, "failed to load snapshot for shard %s at %s", p.shardID, input.SequenceNumber)
+			p.logger.Printf("%+v", p.err)
+			return
+		}
+
+		if snapshot == nil {
+			p.logger.Printf("No snapshot for shard %s at %s, starting with empty state", p.shardID, input.SequenceNumber)
+		} else {
+			p.store.restore(snapshot)
+			p.committed = input.SequenceNumber
+		}
+	}
+
+	p.processor.Initialize(input)
+}
+
+// Err returns the error the snapshot failed to load with, or nil. It makes the
+// Processor a kcl.FailingRecordProcessor.
+func (p *Processor) Err() error {
+	return p.err
+}
+
+func (p *Processor) ProcessRecords(input *kcl.ProcessRecordsInput) {
+	if p.err != nil {
+		return
+	}
+	if len(input.Records) > 0 {
+		p.lastDelivered = input.Records[len(input.Records)-1].SequenceNumber
+	}
+
+	p.processor.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    input.Records,
+		Checkpoint: p.checkpointFunc(input.Checkpoint),
+	})
+}
+
+// LeaseLost drops the Store. Another worker now owns the shard and restores
+// its state from the last snapshot.
+func (p *Processor) LeaseLost(input *kcl.LeaseLostInput) {
+	if p.err == nil {
+		p.processor.LeaseLost(input)
+	}
+	p.store.reset()
+	p.committed = ""
+}
+
+// ShardEnded passes through to the processor. Checkpointing the end of the
+// shard deletes its snapshot since child shards start with empty state.
+func (p *Processor) ShardEnded(input *kcl.ShardEndedInput) {
+	if p.err != nil {
+		return
+	}
+	p.processor.ShardEnded(&kcl.ShardEndedInput{
+		Checkpoint: p.shardEndCheckpointFunc(input.Checkpoint),
+	})
+}
+
+func (p *Processor) ShutdownRequested(input *kcl.ShutdownRequestedInput) {
+	if p.err != nil {
+		return
+	}
+	p.processor.ShutdownRequested(&kcl.ShutdownRequestedInput{
+		Checkpoint: p.checkpointFunc(input.Checkpoint),
+	})
+}
+
+// checkpointFunc returns a CheckpointFunc that saves a snapshot before each
+// checkpoint. A nil sequence number refers to the last delivered record. The
+// Store holds the effects of every delivered record, so it only matches the
+// last delivered record, or the restored checkpoint before any record was
+// delivered, and other sequence numbers are rejected.
+func (p *Processor) checkpointFunc(checkpoint kcl.CheckpointFunc) kcl.CheckpointFunc {
+	return func(sequenceNumber *string) error {
+		if sequenceNumber == nil {
+			if p.lastDelivered == "" {
+				return checkpoint(nil)
+			}
+			sequenceNumber = &p.lastDelivered
+		}
+		s := *sequenceNumber
+
+		current := p.lastDelivered
+		if current == "" {
+			current = p.committed
+		}
+		if s != current {
+			return errors.Errorf("can not checkpoint shard %s at %s, the state is at %s", p.shardID, s, current)
+		}
+
+		if err := p.backend.Save(p.store.snapshot(p.shardID, s)); err != nil {
+			return errors.Wrap(err, "failed to save snapshot")
+		}
+
+		if err := checkpoint(&s); err != nil {
+			if s != p.committed {
+				p.deleteSnapshot(s)
+			}
+			return err
+		}
+
+		if p.committed != "" && p.committed != s {
+			p.deleteSnapshot(p.committed)
+		}
+		p.committed = s
+		return nil
+	}
+}
+
+// shardEndCheckpointFunc returns a CheckpointFunc for ShardEnded, where a nil
+// sequence number checkpoints the end of the shard.
+func (p *Processor) shardEndCheckpointFunc(checkpoint kcl.CheckpointFunc) kcl.CheckpointFunc {
+	checkpointSequenceNumber := p.checkpointFunc(checkpoint)
+	return func(sequenceNumber *string) error {
+		if sequenceNumber != nil {
+			return checkpointSequenceNumber(sequenceNumber)
+		}
+
+		if err := checkpoint(nil); err != nil {
+			return err
+		}
+
+		if p.committed != "" {
+			p.deleteSnapshot(p.committed)
+		}
+		p.committed = ""
+		return nil
+	}
+}
+
+// deleteSnapshot deletes a snapshot that is no longer needed. A failure only
+// leaves an unused snapshot behind, so it is logged.
+func (p *Processor) deleteSnapshot(sequenceNumber string) {
+	if err := p.backend.Delete(p.shardID, sequenceNumber); err != nil {
+		p.logger.Printf("Failed to delete snapshot for shard %s at %s: %+v", p.shardID, sequenceNumber, err)
+	}
+}
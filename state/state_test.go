@@ -0,0 +1,232 @@
+package state
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+)
+
+// counter counts records per partition key and checkpoints after every call.
+type counter struct {
+	store *Store
+}
+
+func (c *counter) Initialize(input *kcl.InitializationInput) {}
+
+func (c *counter) ProcessRecords(input *kcl.ProcessRecordsInput) {
+	for _, record := range input.Records {
+		n := 0
+		if value, ok := c.store.Get(record.PartitionKey); ok {
+			n, _ = strconv.Atoi(string(value))
+		}
+		c.store.Set(record.PartitionKey, []byte(strconv.Itoa(n+1)))
+	}
+	input.Checkpoint(nil)
+}
+
+func (c *counter) LeaseLost(input *kcl.LeaseLostInput) {}
+
+func (c *counter) ShardEnded(input *kcl.ShardEndedInput) {
+	input.Checkpoint(nil)
+}
+
+func (c *counter) ShutdownRequested(input *kcl.ShutdownRequestedInput) {}
+
+func newCounter(store *Store) kcl.RecordProcessor {
+	return &counter{store: store}
+}
+
+type recorder struct {
+	checkpoints   []*string
+	checkpointErr error
+}
+
+func (r *recorder) checkpoint(sequenceNumber *string) error {
+	if r.checkpointErr != nil {
+		return r.checkpointErr
+	}
+	r.checkpoints = append(r.checkpoints, sequenceNumber)
+	return nil
+}
+
+func records(key string, sequenceNumbers ...string) []kcl.Record {
+	records := []kcl.Record{}
+	for _, s := range sequenceNumbers {
+		records = append(records, kcl.Record{PartitionKey: key, SequenceNumber: s})
+	}
+	return records
+}
+
+func count(store *Store, key string) string {
+	value, ok := store.Get(key)
+	if !ok {
+		return ""
+	}
+	return string(value)
+}
+
+func TestProcessor_RestoresSnapshotOfLastCheckpoint(t *testing.T) {
+	backend := NewFileBackend(t.TempDir())
+	r := &recorder{}
+
+	p := GetProcessor(backend, newCounter)
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "TRIM_HORIZON"})
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "1", "2"), Checkpoint: r.checkpoint})
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("b", "3"), Checkpoint: r.checkpoint})
+
+	if len(r.checkpoints) != 2 || *r.checkpoints[1] != "3" {
+		t.Fatalf("expected the nil checkpoints to be resolved to the last record but got %v", r.checkpoints)
+	}
+
+	// The snapshot of the previous checkpoint is deleted.
+	if snapshot, err := backend.Load("shard-0", "2"); err != nil || snapshot != nil {
+		t.Errorf("expected the snapshot at '2' to be deleted but got %+v, %+v", snapshot, err)
+	}
+
+	// Another worker picks up the shard at the last checkpoint.
+	restored := GetProcessor(backend, newCounter)
+	restored.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "3"})
+	if count(restored.store, "a") != "2" || count(restored.store, "b") != "1" {
+		t.Errorf("unexpected restored state %v", restored.store.values)
+	}
+}
+
+func TestProcessor_DoesNotKeepSnapshotOfFailedCheckpoint(t *testing.T) {
+	backend := NewFileBackend(t.TempDir())
+	r := &recorder{}
+
+	p := GetProcessor(backend, newCounter)
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "LATEST"})
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "1"), Checkpoint: r.checkpoint})
+
+	r.checkpointErr = errors.New("some error")
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "2"), Checkpoint: r.checkpoint})
+
+	if snapshot, err := backend.Load("shard-0", "2"); err != nil || snapshot != nil {
+		t.Errorf("expected no snapshot at '2' but got %+v, %+v", snapshot, err)
+	}
+
+	// The shard is retried from the last successful checkpoint, so record 2
+	// is applied to the state from before it.
+	restored := GetProcessor(backend, newCounter)
+	restored.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "1"})
+	if count(restored.store, "a") != "1" {
+		t.Errorf("expected the state at '1' but got %v", restored.store.values)
+	}
+}
+
+func TestProcessor_StartsEmptyWithoutSnapshot(t *testing.T) {
+	backend := NewFileBackend(t.TempDir())
+
+	p := GetProcessor(backend, newCounter)
+	p.store.Set("a", []byte("5"))
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "123"})
+
+	if p.store.Len() != 0 {
+		t.Errorf("expected empty state but got %v", p.store.values)
+	}
+}
+
+func TestProcessor_ShardEndDeletesSnapshot(t *testing.T) {
+	backend := NewFileBackend(t.TempDir())
+	r := &recorder{}
+
+	p := GetProcessor(backend, newCounter)
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "TRIM_HORIZON"})
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "1"), Checkpoint: r.checkpoint})
+	p.ShardEnded(&kcl.ShardEndedInput{Checkpoint: r.checkpoint})
+
+	if len(r.checkpoints) != 2 || r.checkpoints[1] != nil {
+		t.Fatalf("expected a nil checkpoint for the end of the shard but got %v", r.checkpoints)
+	}
+	if snapshot, err := backend.Load("shard-0", "1"); err != nil || snapshot != nil {
+		t.Errorf("expected the snapshot to be deleted but got %+v, %+v", snapshot, err)
+	}
+}
+
+func TestProcessor_LeaseLostDropsState(t *testing.T) {
+	p := GetProcessor(NewFileBackend(t.TempDir()), newCounter)
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "TRIM_HORIZON"})
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "1"), Checkpoint: (&recorder{}).checkpoint})
+	p.LeaseLost(&kcl.LeaseLostInput{})
+
+	if p.store.Len() != 0 {
+		t.Errorf("expected empty state but got %v", p.store.values)
+	}
+}
+
+func TestProcessor_RejectsCheckpointBeforeLastDelivered(t *testing.T) {
+	backend := NewFileBackend(t.TempDir())
+	r := &recorder{}
+
+	p := GetProcessor(backend, newCounter)
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "TRIM_HORIZON"})
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "1"), Checkpoint: r.checkpoint})
+
+	// The processor checkpoints record 2 after record 3 is already in the
+	// Store.
+	var err error
+	p.processor = &checkpointer{counter: counter{store: p.store}, at: "2", err: &err}
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "2", "3"), Checkpoint: r.checkpoint})
+	if err == nil {
+		t.Fatal("expected an error for a checkpoint before the last delivered record, but got nil")
+	}
+	if len(r.checkpoints) != 1 {
+		t.Errorf("expected only the first checkpoint but got %v", r.checkpoints)
+	}
+	if snapshot, loadErr := backend.Load("shard-0", "2"); loadErr != nil || snapshot != nil {
+		t.Errorf("expected no snapshot at '2' but got %+v, %+v", snapshot, loadErr)
+	}
+
+	// The shard is retried from the last checkpoint and records 2 and 3 are
+	// delivered again, each applied once.
+	restored := GetProcessor(backend, newCounter)
+	restored.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "1"})
+	restored.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "2", "3"), Checkpoint: r.checkpoint})
+	if count(restored.store, "a") != "3" {
+		t.Errorf("expected each record to be applied once but got %v", restored.store.values)
+	}
+}
+
+// checkpointer counts records like counter but checkpoints at a fixed
+// sequence number.
+type checkpointer struct {
+	counter
+	at  string
+	err *error
+}
+
+func (c *checkpointer) ProcessRecords(input *kcl.ProcessRecordsInput) {
+	c.counter.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    input.Records,
+		Checkpoint: func(*string) error { return nil },
+	})
+	*c.err = input.Checkpoint(&c.at)
+}
+
+type failingBackend struct {
+	Backend
+}
+
+func (b failingBackend) Load(shardID, sequenceNumber string) (*Snapshot, error) {
+	return nil, errors.New("throttled")
+}
+
+func TestProcessor_FailsWhenSnapshotCannotBeLoaded(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(failingBackend{NewFileBackend(t.TempDir())}, newCounter)
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0", SequenceNumber: "123"})
+
+	if err := p.Err(); err == nil || !strings.Contains(err.Error(), "throttled") {
+		t.Fatalf("expected the load error but got %+v", err)
+	}
+
+	// The shard is not processed with missing state.
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Records: records("a", "124"), Checkpoint: r.checkpoint})
+	if p.store.Len() != 0 || len(r.checkpoints) != 0 {
+		t.Errorf("expected no records to be processed but got state %v and checkpoints %v", p.store.values, r.checkpoints)
+	}
+}
@@ -0,0 +1,337 @@
+// Package window provides a kcl.RecordProcessor that aggregates records into
+// tumbling or sliding event-time windows keyed by partition key.
+//
+// Each shard tracks a watermark, the largest event time seen minus the
+// allowed lateness. A window is closed and emitted once its end is at or
+// before the watermark, and records that only belong to closed windows are
+// late. A record is only checkpointed once every window it belongs to has been
+// emitted, so after a restart or lease move the records of open windows are
+// delivered again and no window is lost.
+package window
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+)
+
+var defaultLogger = log.New(ioutil.Discard, "", log.LstdFlags)
+
+// Accumulator aggregates the records of a single window.
+type Accumulator interface {
+	Add(record kcl.Record)
+}
+
+// Records is the default Accumulator. It keeps every record in the window.
+type Records struct {
+	Records []kcl.Record
+}
+
+func (r *Records) Add(record kcl.Record) {
+	r.Records = append(r.Records, record)
+}
+
+// Window is an aggregate over the records for one partition key whose event
+// time is in [Start, End).
+type Window struct {
+	ShardID string
+	Key     string
+	Start   time.Time
+	End     time.Time
+	Value   Accumulator
+}
+
+// Handler receives closed windows in order of their end time. If it returns
+// an error the window is kept and emitted again on the next ProcessRecords
+// call, and nothing past it is checkpointed.
+type Handler func(*Window) error
+
+// EventTimeFunc extracts the event time of a record.
+type EventTimeFunc func(kcl.Record) time.Time
+
+// Option signifies the type of options that can be passed to the Processor.
+type Option func(*Processor)
+
+// WithSlide makes the windows sliding windows that start every slide. By
+// default the slide equals the size, which makes them tumbling windows. A
+// slide that is not positive or is larger than the size is ignored.
+func WithSlide(slide time.Duration) Option {
+	return func(p *Processor) {
+		p.slide = slide
+	}
+}
+
+// WithAllowedLateness holds windows open for d after the largest event time
+// seen passes their end, for records that arrive out of order.
+func WithAllowedLateness(d time.Duration) Option {
+	return func(p *Processor) {
+		p.allowedLateness = d
+	}
+}
+
+// WithEventTime sets how the event time of a record is determined. By
+// default it is the record's approximate arrival timestamp.
+func WithEventTime(f EventTimeFunc) Option {
+	return func(p *Processor) {
+		p.eventTime = f
+	}
+}
+
+// WithAccumulator sets the constructor for the aggregate of each window. By
+// default every record is kept in a *Records.
+func WithAccumulator(newAccumulator func() Accumulator) Option {
+	return func(p *Processor) {
+		p.newAccumulator = newAccumulator
+	}
+}
+
+// WithLateRecordHandler is called with records whose windows have all been
+// emitted. By default late records are dropped.
+func WithLateRecordHandler(f func(kcl.Record)) Option {
+	return func(p *Processor) {
+		p.lateRecord = f
+	}
+}
+
+// WithLogger adds a logger option.
+func WithLogger(l *log.Logger) Option {
+	return func(p *Processor) {
+		p.logger = l
+	}
+}
+
+type windowKey struct {
+	key   string
+	start int64
+}
+
+// pendingRecord is a record that has not been checkpointed yet and the end
+// of the last window it belongs to.
+type pendingRecord struct {
+	sequenceNumber string
+	lastWindowEnd  int64
+}
+
+// Processor is a kcl.RecordProcessor that aggregates the records of a single
+// shard into windows.
+type Processor struct {
+	handler         Handler
+	size            int64
+	slide           time.Duration
+	allowedLateness time.Duration
+	eventTime       EventTimeFunc
+	newAccumulator  func() Accumulator
+	lateRecord      func(kcl.Record)
+	logger          *log.Logger
+
+	shardID      string
+	windows      map[windowKey]*Window
+	pending      []pendingRecord
+	maxEventTime int64
+}
+
+// GetProcessor returns a Processor that emits windows of the given size to
+// handler. Use a new Processor for every shard. It panics if size is not
+// positive.
+func GetProcessor(handler Handler, size time.Duration, opts ...Option) *Processor {
+	if size <= 0 {
+		panic(fmt.Sprintf("window: size must be positive, got %s", size))
+	}
+
+	p := &Processor{
+		handler: handler,
+		size:    int64(size),
+		slide:   size,
+		eventTime: func(r kcl.Record) time.Time {
+			return r.ArrivalTime()
+		},
+		newAccumulator: func() Accumulator {
+			return &Records{}
+		},
+		lateRecord: func(kcl.Record) {},
+		logger:     defaultLogger,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.slide <= 0 || int64(p.slide) > p.size {
+		p.slide = size
+	}
+
+	p.reset()
+	return p
+}
+
+func (p *Processor) reset() {
+	p.windows = map[windowKey]*Window{}
+	p.pending = nil
+	p.maxEventTime = math.MinInt64
+}
+
+// watermark returns the time, in nanoseconds since the epoch, before which
+// every window is closed.
+func (p *Processor) watermark() int64 {
+	if p.maxEventTime == math.MinInt64 {
+		return math.MinInt64
+	}
+	return p.maxEventTime - int64(p.allowedLateness)
+}
+
+// windowStarts returns the start of every window containing t.
+func (p *Processor) windowStarts(t int64) []int64 {
+	slide := int64(p.slide)
+	last := t - mod(t, slide)
+
+	starts := []int64{}
+	for start := last; start+p.size > t; start -= slide {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+func (p *Processor) Initialize(input *kcl.InitializationInput) {
+	p.shardID = input.ShardID
+	p.reset()
+}
+
+func (p *Processor) ProcessRecords(input *kcl.ProcessRecordsInput) {
+	for _, record := range input.Records {
+		t := p.eventTime(record).UnixNano()
+		if t > p.maxEventTime {
+			p.maxEventTime = t
+		}
+		p.add(record, t)
+	}
+
+	p.emitAndCheckpoint(p.watermark(), input.Checkpoint)
+}
+
+// add assigns the record to every window that contains its event time and
+// is still open.
+func (p *Processor) add(record kcl.Record, t int64) {
+	watermark := p.watermark()
+	lastWindowEnd := int64(math.MinInt64)
+	for _, start := range p.windowStarts(t) {
+		end := start + p.size
+		if end <= watermark {
+			continue
+		}
+
+		k := windowKey{key: record.PartitionKey, start: start}
+		w, ok := p.windows[k]
+		if !ok {
+			w = &Window{
+				ShardID: p.shardID,
+				Key:     record.PartitionKey,
+				Start:   time.Unix(0, start),
+				End:     time.Unix(0, end),
+				Value:   p.newAccumulator(),
+			}
+			p.windows[k] = w
+		}
+		w.Value.Add(record)
+
+		if end > lastWindowEnd {
+			lastWindowEnd = end
+		}
+	}
+
+	if lastWindowEnd == math.MinInt64 {
+		p.lateRecord(record)
+	}
+
+	p.pending = append(p.pending, pendingRecord{
+		sequenceNumber: record.SequenceNumber,
+		lastWindowEnd:  lastWindowEnd,
+	})
+}
+
+// emit sends every window ending at or before watermark to the handler in
+// order of end time and returns the time before which every window has been
+// emitted.
+func (p *Processor) emit(watermark int64) int64 {
+	closed := []windowKey{}
+	for k, w := range p.windows {
+		if w.End.UnixNano() <= watermark {
+			closed = append(closed, k)
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool {
+		if closed[i].start != closed[j].start {
+			return closed[i].start < closed[j].start
+		}
+		return closed[i].key < closed[j].key
+	})
+
+	for _, k := range closed {
+		w := p.windows[k]
+		if err := p.handler(w); err != nil {
+			p.logger.Printf("Failed to emit window %s [%s, %s) for shard %s: %+v", w.Key, w.Start, w.End, p.shardID, err)
+			// Records in windows ending before this one are safe.
+			return w.End.UnixNano() - 1
+		}
+		delete(p.windows, k)
+	}
+
+	return watermark
+}
+
+// emitAndCheckpoint emits the closed windows and checkpoints the last record
+// of the longest prefix of pending records whose windows have all been
+// emitted.
+func (p *Processor) emitAndCheckpoint(watermark int64, checkpoint kcl.CheckpointFunc) {
+	emitted := p.emit(watermark)
+
+	n := 0
+	for n < len(p.pending) && p.pending[n].lastWindowEnd <= emitted {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	sequenceNumber := p.pending[n-1].sequenceNumber
+	if err := checkpoint(&sequenceNumber); err != nil {
+		p.logger.Printf("Failed to checkpoint shard %s at %s: %+v", p.shardID, sequenceNumber, err)
+		return
+	}
+	p.pending = p.pending[n:]
+}
+
+// LeaseLost drops every open window. The records in them were not
+// checkpointed and will be delivered to the new owner of the shard.
+func (p *Processor) LeaseLost(input *kcl.LeaseLostInput) {
+	p.reset()
+}
+
+// ShardEnded emits every open window, since no more records will arrive for
+// the shard, and checkpoints the end of the shard.
+func (p *Processor) ShardEnded(input *kcl.ShardEndedInput) {
+	if p.emit(math.MaxInt64) != math.MaxInt64 {
+		return
+	}
+
+	if err := input.Checkpoint(nil); err != nil {
+		p.logger.Printf("Failed to checkpoint end of shard %s: %+v", p.shardID, err)
+	}
+}
+
+// ShutdownRequested keeps open windows unemitted. Their records are not
+// checkpointed so they are delivered again to the next owner of the shard.
+func (p *Processor) ShutdownRequested(input *kcl.ShutdownRequestedInput) {}
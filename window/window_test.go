@@ -0,0 +1,221 @@
+package window
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/goguardian/goguardian-go-kcl/kcl"
+)
+
+type recorder struct {
+	windows     []string
+	checkpoints []*string
+	emitErr     error
+}
+
+func (r *recorder) emit(w *Window) error {
+	if r.emitErr != nil {
+		return r.emitErr
+	}
+
+	data := []string{}
+	for _, record := range w.Value.(*Records).Records {
+		data = append(data, string(record.Data))
+	}
+	r.windows = append(r.windows, fmt.Sprintf("%s[%d,%d)%v", w.Key, w.Start.Unix(), w.End.Unix(), data))
+	return nil
+}
+
+func (r *recorder) checkpoint(sequenceNumber *string) error {
+	r.checkpoints = append(r.checkpoints, sequenceNumber)
+	return nil
+}
+
+func (r *recorder) lastCheckpoint() string {
+	if len(r.checkpoints) == 0 {
+		return ""
+	}
+	if r.checkpoints[len(r.checkpoints)-1] == nil {
+		return "nil"
+	}
+	return *r.checkpoints[len(r.checkpoints)-1]
+}
+
+// record returns a record that arrived at the given second.
+func record(key string, second int64, sequenceNumber string) kcl.Record {
+	return kcl.Record{
+		Data:                        []byte(sequenceNumber),
+		PartitionKey:                key,
+		SequenceNumber:              sequenceNumber,
+		ApproximateArrivalTimestamp: second * 1000,
+	}
+}
+
+func TestProcessRecords_TumblingWindows(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.emit, 10*time.Second)
+	p.Initialize(&kcl.InitializationInput{ShardID: "shard-0"})
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records: []kcl.Record{
+			record("a", 1, "1"),
+			record("b", 2, "2"),
+			record("a", 9, "3"),
+		},
+		Checkpoint: r.checkpoint,
+	})
+	if len(r.windows) != 0 || len(r.checkpoints) != 0 {
+		t.Fatalf("expected nothing to be emitted or checkpointed but got %v", r.windows)
+	}
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    []kcl.Record{record("a", 12, "4")},
+		Checkpoint: r.checkpoint,
+	})
+
+	expected := "[a[0,10)[1 3] b[0,10)[2]]"
+	if fmt.Sprint(r.windows) != expected {
+		t.Errorf("expected windows %s but got %v", expected, r.windows)
+	}
+	if r.lastCheckpoint() != "3" {
+		t.Errorf("expected a checkpoint at '3' but got '%s'", r.lastCheckpoint())
+	}
+}
+
+func TestProcessRecords_SlidingWindows(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.emit, 10*time.Second, WithSlide(5*time.Second))
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    []kcl.Record{record("a", 7, "1"), record("a", 21, "2")},
+		Checkpoint: r.checkpoint,
+	})
+
+	expected := "[a[0,10)[1] a[5,15)[1]]"
+	if fmt.Sprint(r.windows) != expected {
+		t.Errorf("expected windows %s but got %v", expected, r.windows)
+	}
+	if r.lastCheckpoint() != "1" {
+		t.Errorf("expected a checkpoint at '1' but got '%s'", r.lastCheckpoint())
+	}
+}
+
+func TestProcessRecords_AllowedLatenessAndLateRecords(t *testing.T) {
+	r := &recorder{}
+	late := []string{}
+	p := GetProcessor(r.emit, 10*time.Second,
+		WithAllowedLateness(5*time.Second),
+		WithLateRecordHandler(func(record kcl.Record) {
+			late = append(late, record.SequenceNumber)
+		}),
+	)
+
+	// The watermark is 12-5=7 so the first window stays open for the out of
+	// order record.
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    []kcl.Record{record("a", 1, "1"), record("a", 12, "2"), record("a", 8, "3")},
+		Checkpoint: r.checkpoint,
+	})
+	if len(r.windows) != 0 {
+		t.Fatalf("expected no windows to be emitted but got %v", r.windows)
+	}
+
+	// The watermark is now 16-5=11.
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    []kcl.Record{record("a", 16, "4"), record("a", 2, "5")},
+		Checkpoint: r.checkpoint,
+	})
+
+	expected := "[a[0,10)[1 3]]"
+	if fmt.Sprint(r.windows) != expected {
+		t.Errorf("expected windows %s but got %v", expected, r.windows)
+	}
+	if fmt.Sprint(late) != "[5]" {
+		t.Errorf("expected record 5 to be late but got %v", late)
+	}
+
+	// Record 2 is in the open [10,20) window so only record 1 can be
+	// checkpointed.
+	if r.lastCheckpoint() != "1" {
+		t.Errorf("expected a checkpoint at '1' but got '%s'", r.lastCheckpoint())
+	}
+}
+
+func TestProcessRecords_EventTimeFunc(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.emit, 10*time.Second, WithEventTime(func(record kcl.Record) time.Time {
+		// The event time is in the data.
+		var second int64
+		fmt.Sscan(string(record.Data), &second)
+		return time.Unix(second, 0)
+	}))
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records: []kcl.Record{
+			{Data: []byte("3"), PartitionKey: "a", SequenceNumber: "1"},
+			{Data: []byte("15"), PartitionKey: "a", SequenceNumber: "2"},
+		},
+		Checkpoint: r.checkpoint,
+	})
+
+	expected := "[a[0,10)[3]]"
+	if fmt.Sprint(r.windows) != expected {
+		t.Errorf("expected windows %s but got %v", expected, r.windows)
+	}
+}
+
+func TestProcessRecords_DoesNotCheckpointPastFailedWindow(t *testing.T) {
+	r := &recorder{emitErr: errors.New("some error")}
+	p := GetProcessor(r.emit, 10*time.Second)
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    []kcl.Record{record("a", 1, "1"), record("a", 25, "2")},
+		Checkpoint: r.checkpoint,
+	})
+	if len(r.checkpoints) != 0 {
+		t.Fatalf("expected no checkpoint but got '%s'", r.lastCheckpoint())
+	}
+
+	// The window is retried on the next call.
+	r.emitErr = nil
+	p.ProcessRecords(&kcl.ProcessRecordsInput{Checkpoint: r.checkpoint})
+	if fmt.Sprint(r.windows) != "[a[0,10)[1]]" {
+		t.Errorf("expected the failed window to be emitted again but got %v", r.windows)
+	}
+	if r.lastCheckpoint() != "1" {
+		t.Errorf("expected a checkpoint at '1' but got '%s'", r.lastCheckpoint())
+	}
+}
+
+func TestShardEnded_EmitsOpenWindows(t *testing.T) {
+	r := &recorder{}
+	p := GetProcessor(r.emit, 10*time.Second)
+
+	p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Records:    []kcl.Record{record("a", 1, "1")},
+		Checkpoint: r.checkpoint,
+	})
+	p.ShardEnded(&kcl.ShardEndedInput{Checkpoint: r.checkpoint})
+
+	if fmt.Sprint(r.windows) != "[a[0,10)[1]]" {
+		t.Errorf("expected the open window to be emitted but got %v", r.windows)
+	}
+	if r.lastCheckpoint() != "nil" {
+		t.Errorf("expected a nil checkpoint but got '%s'", r.lastCheckpoint())
+	}
+}
+
+func TestGetProcessor_PanicsWithoutPositiveSize(t *testing.T) {
+	for _, size := range []time.Duration{0, -time.Second} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected GetProcessor to panic for size %s", size)
+				}
+			}()
+			GetProcessor((&recorder{}).emit, size)
+		}()
+	}
+}